@@ -0,0 +1,163 @@
+#!/usr/bin/env python3
+# Streams the screen with a GStreamer pipeline and changes its bitrate and resolution while it runs, as the provider asks.
+# Messages are received from the provider as JSON lines, either a target: {"bitrate": 800000, "scale": 0.75}
+# or a keyframe request when the player lost packets: {"keyframe": true}
+
+import json
+import os
+import socket
+import threading
+import time
+
+import gi
+
+gi.require_version("Gst", "1.0")
+gi.require_version("GstVideo", "1.0")
+from gi.repository import GLib, Gst, GstVideo
+
+HOST = "172.17.0.1"
+# Encoders restart with a keyframe at another resolution, so it changes at most once in this many seconds.
+# The bitrate of the running encoder changes right away, the provider limits how often targets change.
+RESCALE_INTERVAL = 10
+FPS = 30
+# Clients joining a running stream decode it from the next periodic keyframe
+KEYFRAME_DISTANCE = 2 * FPS
+
+width = int(os.environ["screenwidth"])
+height = int(os.environ["screenheight"])
+video_port = os.environ["videoport"]
+control_port = int(os.environ["controlport"])
+codec = os.environ.get("videocodec", "vp8")
+
+# Low latency encoder and RTP payloader of each codec the provider can negotiate.
+# H.264 is constrained baseline to match the profile offered to browsers.
+CODEC_ELEMENTS = {
+    "vp8": "vp8enc name=encoder deadline=1 cpu-used=8 end-usage=cbr lag-in-frames=0 error-resilient=partitions "
+           "keyframe-max-dist=%d ! rtpvp8pay mtu=1200" % KEYFRAME_DISTANCE,
+    "vp9": "vp9enc name=encoder deadline=1 cpu-used=8 end-usage=cbr lag-in-frames=0 error-resilient=partitions "
+           "keyframe-max-dist=%d ! rtpvp9pay mtu=1200" % KEYFRAME_DISTANCE,
+    "h264": "x264enc name=encoder speed-preset=ultrafast tune=zerolatency key-int-max=%d "
+            "! video/x-h264,profile=constrained-baseline ! rtph264pay config-interval=-1 mtu=1200" % KEYFRAME_DISTANCE,
+}
+# Bitrate property of each encoder and its unit in bps
+BITRATE_PROPERTIES = {
+    "vp8": ("target-bitrate", 1),
+    "vp9": ("target-bitrate", 1),
+    "h264": ("bitrate", 1000),
+}
+
+
+class Encoder:
+    def __init__(self, bitrate):
+        self.bitrate = bitrate
+        self.scale = 1.0
+        # Latest scale the provider asked for, applied once the rescale interval has elapsed
+        self.target_scale = 1.0
+        self.last_rescale = 0.0
+        self.pending_rescale = None
+        self.pipeline = None
+        self.code = 0
+        self.loop = GLib.MainLoop()
+        self.lock = threading.Lock()
+
+    def caps(self):
+        # Encoders need even dimensions
+        out_width = int(width * self.scale) // 2 * 2
+        out_height = int(height * self.scale) // 2 * 2
+        return "video/x-raw,format=I420,width=%d,height=%d" % (out_width, out_height)
+
+    def start(self):
+        with self.lock:
+            self.pipeline = Gst.parse_launch((
+                "ximagesrc display-name=:99 show-pointer=false use-damage=false ! video/x-raw,framerate=%d/1 "
+                "! videoconvert ! videoscale ! capsfilter name=scale caps=\"%s\" "
+                "! queue max-size-buffers=1 leaky=downstream ! %s "
+                "! udpsink host=%s port=%s sync=false"
+            ) % (FPS, self.caps(), CODEC_ELEMENTS[codec], HOST, video_port))
+            self.apply_bitrate()
+
+            bus = self.pipeline.get_bus()
+            bus.add_signal_watch()
+            bus.connect("message", self.on_message)
+            self.pipeline.set_state(Gst.State.PLAYING)
+
+    def apply_bitrate(self):
+        # Must be called with the lock held
+        name, unit = BITRATE_PROPERTIES[codec]
+        self.pipeline.get_by_name("encoder").set_property(name, self.bitrate // unit)
+
+    def rescale(self):
+        # Must be called with the lock held, the pipeline negotiates the new resolution while it runs
+        print("Scaling video to %.2f" % self.target_scale, flush=True)
+        self.scale = self.target_scale
+        self.last_rescale = time.monotonic()
+        self.pipeline.get_by_name("scale").set_property("caps", Gst.Caps.from_string(self.caps()))
+
+    def rescale_later(self):
+        with self.lock:
+            self.pending_rescale = None
+            if self.target_scale != self.scale:
+                self.rescale()
+
+    def set_target(self, bitrate, scale):
+        with self.lock:
+            if bitrate != self.bitrate:
+                print("Encoding at %d bps" % bitrate, flush=True)
+                self.bitrate = bitrate
+                self.apply_bitrate()
+
+            self.target_scale = scale
+            if scale == self.scale or self.pending_rescale is not None:
+                return
+            wait = self.last_rescale + RESCALE_INTERVAL - time.monotonic()
+            if wait <= 0:
+                self.rescale()
+                return
+            self.pending_rescale = threading.Timer(wait, self.rescale_later)
+            self.pending_rescale.daemon = True
+            self.pending_rescale.start()
+
+    def force_keyframe(self):
+        # The running encoder makes its next frame a keyframe, the provider limits how often this is requested
+        event = GstVideo.video_event_new_upstream_force_key_unit(Gst.CLOCK_TIME_NONE, True, 0)
+        with self.lock:
+            self.pipeline.get_by_name("encoder").get_static_pad("src").send_event(event)
+
+    def on_message(self, bus, msg):
+        if msg.type == Gst.MessageType.ERROR:
+            err, debug = msg.parse_error()
+            print("Pipeline failed: %s %s" % (err, debug), flush=True)
+            self.code = 1
+            self.loop.quit()
+        elif msg.type == Gst.MessageType.EOS:
+            self.loop.quit()
+
+    def wait(self):
+        # Supervisord restarts us if the pipeline fails
+        self.loop.run()
+        with self.lock:
+            self.pipeline.set_state(Gst.State.NULL)
+        return self.code
+
+
+def receive_targets(encoder):
+    while True:
+        try:
+            with socket.create_connection((HOST, control_port)) as conn:
+                for line in conn.makefile():
+                    msg = json.loads(line)
+                    if msg.get("keyframe"):
+                        encoder.force_keyframe()
+                    else:
+                        encoder.set_target(int(msg["bitrate"]), float(msg["scale"]))
+        except (OSError, ValueError, KeyError) as e:
+            print("Control connection failed: %s" % e, flush=True)
+        time.sleep(2)
+
+
+if __name__ == "__main__":
+    Gst.init(None)
+    encoder = Encoder(int(os.environ.get("videobitrate", "1500000")))
+    encoder.start()
+    threading.Thread(target=receive_targets, args=(encoder,), daemon=True).start()
+    exit(encoder.wait())
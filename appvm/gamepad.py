@@ -0,0 +1,136 @@
+#!/usr/bin/env python3
+# Creates a virtual Xbox 360 pad through uinput for each pad of the player.
+# Wine recognizes these pads and exposes them to apps through both XInput and DirectInput.
+# uinput creates device nodes in /dev of the host only, so the node of each pad is created in the container too.
+# syncinput forwards gamepad events of the provider as lines: <pad>,<event>,<index>,<value>
+# with buttons, axes and triggers following the standard mapping of the browser Gamepad API.
+
+import os
+import socket
+import stat
+import sys
+import time
+
+from evdev import AbsInfo, UInput, ecodes as e
+
+CONNECTED, DISCONNECTED, BUTTON, AXIS, TRIGGER = range(5)
+
+# Standard mapping buttons, None for triggers which are sent as such and d-pad buttons which are a hat
+BUTTONS = [
+    e.BTN_A, e.BTN_B, e.BTN_X, e.BTN_Y, e.BTN_TL, e.BTN_TR, None, None,
+    e.BTN_SELECT, e.BTN_START, e.BTN_THUMBL, e.BTN_THUMBR, None, None, None, None, e.BTN_MODE,
+]
+# D-pad buttons: hat axis and direction
+DPAD = {12: (e.ABS_HAT0Y, -1), 13: (e.ABS_HAT0Y, 1), 14: (e.ABS_HAT0X, -1), 15: (e.ABS_HAT0X, 1)}
+AXES = [e.ABS_X, e.ABS_Y, e.ABS_RX, e.ABS_RY]
+TRIGGERS = [e.ABS_Z, e.ABS_RZ]
+
+STICK = AbsInfo(value=0, min=-32768, max=32767, fuzz=16, flat=128, resolution=0)
+TRIGGER_RANGE = AbsInfo(value=0, min=0, max=255, fuzz=0, flat=0, resolution=0)
+HAT = AbsInfo(value=0, min=-1, max=1, fuzz=0, flat=0, resolution=0)
+
+CAPABILITIES = {
+    e.EV_KEY: [b for b in BUTTONS if b is not None],
+    e.EV_ABS: [(a, STICK) for a in AXES] + [(t, TRIGGER_RANGE) for t in TRIGGERS]
+    + [(e.ABS_HAT0X, HAT), (e.ABS_HAT0Y, HAT)],
+}
+
+
+SYS_INPUT = "/sys/devices/virtual/input"
+
+
+def make_node(name):
+    """Creates the /dev/input node of the virtual device named name, returns its path"""
+    # The device shows up in sysfs shortly after being created
+    for _ in range(20):
+        for input_dir in os.listdir(SYS_INPUT):
+            path = os.path.join(SYS_INPUT, input_dir)
+            try:
+                with open(os.path.join(path, "name")) as f:
+                    if f.read().strip() != name:
+                        continue
+                event = next(entry for entry in os.listdir(path) if entry.startswith("event"))
+                with open(os.path.join(path, event, "dev")) as f:
+                    major, minor = map(int, f.read().strip().split(":"))
+            except (OSError, StopIteration):
+                continue
+
+            os.makedirs("/dev/input", exist_ok=True)
+            node = os.path.join("/dev/input", event)
+            if not os.path.exists(node):
+                os.mknod(node, stat.S_IFCHR | 0o666, os.makedev(major, minor))
+            return node
+        time.sleep(0.1)
+
+    raise OSError("device %s not found in sysfs" % name)
+
+
+class Pad:
+    def __init__(self, index):
+        # Name must be unique among the VMs of the host, which share sysfs
+        name = "Microsoft X-Box 360 pad %d %s" % (index, socket.gethostname())
+        # Vendor and product of the Xbox 360 controller, which Wine maps to XInput
+        self.device = UInput(CAPABILITIES, name=name, vendor=0x045e, product=0x028e, version=0x110, bustype=e.BUS_USB)
+        self.node = make_node(name)
+        self.dpad = {}
+
+    def button(self, index, value):
+        if index in DPAD:
+            self.dpad[index] = value >= 0.5
+            axis, _ = DPAD[index]
+            direction = sum(d for i, (a, d) in DPAD.items() if a == axis and self.dpad.get(i))
+            self.device.write(e.EV_ABS, axis, direction)
+        elif index < len(BUTTONS) and BUTTONS[index] is not None:
+            self.device.write(e.EV_KEY, BUTTONS[index], 1 if value >= 0.5 else 0)
+        else:
+            return
+        self.device.syn()
+
+    def axis(self, index, value):
+        self.device.write(e.EV_ABS, AXES[index], int(value * 32767))
+        self.device.syn()
+
+    def trigger(self, index, value):
+        self.device.write(e.EV_ABS, TRIGGERS[index], int(value * 255))
+        self.device.syn()
+
+    def close(self):
+        self.device.close()
+        os.remove(self.node)
+
+
+def handle(pads, line):
+    pad, event, index, value = line.split(",")
+    pad, event, index, value = int(pad), int(event), int(index), float(value)
+
+    if event == DISCONNECTED:
+        if pad in pads:
+            pads.pop(pad).close()
+        return
+    # Pads are also created on their first event, in case the connection event was missed
+    if pad not in pads:
+        print("Connecting pad %d" % pad, flush=True)
+        pads[pad] = Pad(pad)
+    if event == BUTTON:
+        pads[pad].button(index, value)
+    elif event == AXIS:
+        pads[pad].axis(index, value)
+    elif event == TRIGGER:
+        pads[pad].trigger(index, value)
+
+
+def serve(port):
+    pads = {}
+    with socket.create_server(("127.0.0.1", port)) as server:
+        while True:
+            conn, _ = server.accept()
+            with conn:
+                for line in conn.makefile():
+                    try:
+                        handle(pads, line.strip())
+                    except (ValueError, IndexError, OSError) as err:
+                        print("Invalid gamepad event %r: %s" % (line, err), flush=True)
+
+
+if __name__ == "__main__":
+    serve(int(sys.argv[1]))
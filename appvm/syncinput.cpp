@@ -7,6 +7,7 @@
 #include <pthread.h>
 #include <ctime>
 #include <chrono>
+#include <cstdint>
 
 using namespace std;
 
@@ -20,6 +21,10 @@ char *winTitle;
 char dockerHost[20];
 string hostAddr;
 int hostPort;
+// Gamepads can't be emulated with Windows APIs, their events are forwarded to gamepad.py
+// which creates virtual Linux pads that Wine exposes through XInput and DirectInput
+int gamepadPort;
+int gamepadServer = INVALID_SOCKET;
 
 const byte MOUSE_MOVE = 0;
 const byte MOUSE_DOWN = 1;
@@ -56,6 +61,51 @@ int clientConnect()
     return server;
 }
 
+int gamepadConnect()
+{
+    SOCKADDR_IN addr;
+
+    memset(&addr, 0, sizeof(addr));
+    int conn = socket(AF_INET, SOCK_STREAM, 0);
+    addr.sin_family = AF_INET;
+    addr.sin_port = htons(gamepadPort);
+    addr.sin_addr.s_addr = inet_addr("127.0.0.1");
+
+    if (connect(conn, reinterpret_cast<SOCKADDR *>(&addr), sizeof(addr)) == SOCKET_ERROR)
+    {
+        cout << "Couldn't connect to gamepad helper" << endl;
+        closesocket(conn);
+        return INVALID_SOCKET;
+    }
+    cout << "Connected to gamepad helper" << endl;
+    return conn;
+}
+
+// sendGamepadEvent forwards "<pad>,<event>,<index>,<value>" to the gamepad helper as a line
+void sendGamepadEvent(string payload)
+{
+    if (gamepadPort == 0)
+    {
+        return;
+    }
+    if (gamepadServer == INVALID_SOCKET)
+    {
+        gamepadServer = gamepadConnect();
+        if (gamepadServer == INVALID_SOCKET)
+        {
+            return;
+        }
+    }
+
+    string line = payload + "\n";
+    if (send(gamepadServer, line.c_str(), line.length(), 0) == SOCKET_ERROR)
+    {
+        // Helper may have been restarted, reconnect on the next event
+        closesocket(gamepadServer);
+        gamepadServer = INVALID_SOCKET;
+    }
+}
+
 // get the horizontal and vertical screen sizes in pixel
 void getDesktopResolution(int &width, int &height)
 {
@@ -162,94 +212,155 @@ void MouseMove(int x, int y)
     SendInput(1, &Input, sizeof(INPUT));
 }
 
-void sendMouseDown(bool isLeft, byte state, float x, float y)
+// Mouse buttons, numbered as MouseEvent.button of browsers
+const byte LEFT_BUTTON = 0;
+const byte MIDDLE_BUTTON = 1;
+const byte RIGHT_BUTTON = 2;
+const byte BACK_BUTTON = 3;
+const byte FORWARD_BUTTON = 4;
+
+// sendMouseButton presses or releases the button wherever the cursor is
+void sendMouseButton(byte button, byte state)
+{
+    if (state != MOUSE_DOWN && state != MOUSE_UP)
+    {
+        return;
+    }
+    bool down = state == MOUSE_DOWN;
+    INPUT Input = {0};
+    ZeroMemory(&Input, sizeof(INPUT));
+    Input.type = INPUT_MOUSE;
+
+    switch (button)
+    {
+    case LEFT_BUTTON:
+        Input.mi.dwFlags = down ? MOUSEEVENTF_LEFTDOWN : MOUSEEVENTF_LEFTUP;
+        break;
+    case MIDDLE_BUTTON:
+        Input.mi.dwFlags = down ? MOUSEEVENTF_MIDDLEDOWN : MOUSEEVENTF_MIDDLEUP;
+        break;
+    case RIGHT_BUTTON:
+        Input.mi.dwFlags = down ? MOUSEEVENTF_RIGHTDOWN : MOUSEEVENTF_RIGHTUP;
+        break;
+    case BACK_BUTTON:
+        Input.mi.dwFlags = down ? MOUSEEVENTF_XDOWN : MOUSEEVENTF_XUP;
+        Input.mi.mouseData = XBUTTON1;
+        break;
+    case FORWARD_BUTTON:
+        Input.mi.dwFlags = down ? MOUSEEVENTF_XDOWN : MOUSEEVENTF_XUP;
+        Input.mi.mouseData = XBUTTON2;
+        break;
+    default:
+        return;
+    }
+
+    SendInput(1, &Input, sizeof(INPUT));
+}
+
+void sendMouseDown(byte button, byte state, float x, float y)
 {
     x *= wineScreenWidth;
     y *= wineScreenHeight;
     cout << x << ' ' << y << endl;
-    INPUT Input = {0};
-    ZeroMemory(&Input, sizeof(INPUT));
 
     MouseMove(int(x), int(y));
+    sendMouseButton(button, state);
+}
 
-    if (isLeft && state == MOUSE_DOWN)
-    {
-        Input.mi.dwFlags = MOUSEEVENTF_LEFTDOWN | MOUSEEVENTF_ABSOLUTE;
-    }
-    else if (isLeft && state == MOUSE_UP)
-    {
-        Input.mi.dwFlags = MOUSEEVENTF_LEFTUP | MOUSEEVENTF_ABSOLUTE;
-    }
-    else if (!isLeft && state == MOUSE_DOWN)
+// sendMouseWheel scrolls by deltas in units of WHEEL_DELTA, positive toward the right and away from the user
+void sendMouseWheel(int dx, int dy)
+{
+    INPUT Input = {0};
+    ZeroMemory(&Input, sizeof(INPUT));
+    Input.type = INPUT_MOUSE;
+
+    if (dy != 0)
     {
-        Input.mi.dwFlags = MOUSEEVENTF_RIGHTDOWN | MOUSEEVENTF_ABSOLUTE;
+        Input.mi.dwFlags = MOUSEEVENTF_WHEEL;
+        Input.mi.mouseData = dy;
+        SendInput(1, &Input, sizeof(INPUT));
     }
-    else if (!isLeft && state == MOUSE_UP)
+    if (dx != 0)
     {
-        Input.mi.dwFlags = MOUSEEVENTF_RIGHTUP | MOUSEEVENTF_ABSOLUTE;
+        Input.mi.dwFlags = MOUSEEVENTF_HWHEEL;
+        Input.mi.mouseData = dx;
+        SendInput(1, &Input, sizeof(INPUT));
     }
+}
 
-    // left down
+// sendRelativeMouseMove moves the cursor by pixels, games capturing the cursor only look at these movements
+void sendRelativeMouseMove(int dx, int dy)
+{
+    INPUT Input = {0};
+    ZeroMemory(&Input, sizeof(INPUT));
     Input.type = INPUT_MOUSE;
+    Input.mi.dwFlags = MOUSEEVENTF_MOVE;
+    Input.mi.dx = dx;
+    Input.mi.dy = dy;
     SendInput(1, &Input, sizeof(INPUT));
 }
 
-struct Mouse
-{
-    byte isLeft;
-    byte state;
-    float x;
-    float y;
-    float relwidth;
-    float relheight;
-};
+// Binary input protocol of the provider, see provider/pkg/inputproto.
+// A frame is a version byte, an event type byte and a fixed-size payload, a zero byte in place of a frame is a ping.
+const byte INPUT_VERSION = 1;
+const byte PING = 0;
 
-struct Key
+enum EventType
 {
-    byte key;
-    byte state;
+    EV_KEY_DOWN = 1,
+    EV_KEY_UP,
+    EV_MOUSE_MOVE,
+    EV_MOUSE_DOWN,
+    EV_MOUSE_UP,
+    EV_BUTTON_DOWN,
+    EV_BUTTON_UP,
+    EV_WHEEL,
+    EV_MOVE_RELATIVE,
+    EV_GAMEPAD_CONNECT,
+    EV_GAMEPAD_DISCONNECT,
+    EV_GAMEPAD_BUTTON,
+    EV_GAMEPAD_AXIS,
+    EV_GAMEPAD_TRIGGER,
+    EV_TOUCH_START,
+    EV_TOUCH_MOVE,
+    EV_TOUCH_END,
+    // Echoed back to the provider once the events before it are simulated, to measure latency
+    EV_MARKER,
 };
 
-// TODO: Use some proper serialization?
-Key parseKeyPayload(string stPos)
+// payloadSize returns the size of the payload of the event type, or -1 if syncinput doesn't handle it.
+// Touches are turned into mouse and key events by the provider, so they never reach syncinput.
+int payloadSize(byte type)
 {
-    stringstream ss(stPos);
-
-    string substr;
-    getline(ss, substr, ',');
-    byte key = stof(substr);
-
-    getline(ss, substr, ',');
-    byte state = stof(substr);
-
-    return Key{key, state};
+    switch (type)
+    {
+    case EV_KEY_DOWN:
+    case EV_KEY_UP:
+    case EV_MARKER:
+        return 2;
+    case EV_BUTTON_DOWN:
+    case EV_BUTTON_UP:
+        return 1;
+    case EV_WHEEL:
+    case EV_MOVE_RELATIVE:
+    case EV_GAMEPAD_CONNECT:
+    case EV_GAMEPAD_DISCONNECT:
+    case EV_GAMEPAD_BUTTON:
+    case EV_GAMEPAD_AXIS:
+    case EV_GAMEPAD_TRIGGER:
+        return 4;
+    case EV_MOUSE_MOVE:
+    case EV_MOUSE_DOWN:
+    case EV_MOUSE_UP:
+        return 5;
+    }
+    return -1;
 }
 
-// TODO: Use some proper serialization?
-Mouse parseMousePayload(string stPos)
+// Multi-byte fields are little endian
+uint16_t readUint16(const unsigned char *p)
 {
-    stringstream ss(stPos);
-
-    string substr;
-    getline(ss, substr, ',');
-    bool isLeft = stof(substr);
-
-    getline(ss, substr, ',');
-    byte state = stof(substr);
-
-    getline(ss, substr, ',');
-    float x = stof(substr);
-
-    getline(ss, substr, ',');
-    float y = stof(substr);
-
-    getline(ss, substr, ',');
-    float w = stof(substr);
-
-    getline(ss, substr, ',');
-    float h = stof(substr);
-
-    return Mouse{isLeft, state, x, y, w, h};
+    return p[0] | p[1] << 8;
 }
 
 void formatWindow(HWND hwnd)
@@ -295,24 +406,52 @@ void *thwndupdate(void *args)
     }
 }
 
-void processEvent(string ev, bool isDxGame)
+// processEvent simulates a whole frame whose type is known
+void processEvent(const unsigned char *frame, bool isDxGame)
 {
-    // Mouse payload
-    if (ev[0] == 'K')
+    byte type = frame[1];
+    const unsigned char *p = frame + 2;
+
+    switch (type)
     {
-        Key key = parseKeyPayload(ev.substr(1, ev.length() - 1));
-        cout << "Key: " << key.key << " " << key.state << endl;
-        // use key
-        sendIt(key.key, key.state, isDxGame); //notepad ID
-        cout << '\n'
-             << "Press a key to continue...";
-    }
-    else if (ev[0] == 'M')
+    case EV_KEY_DOWN:
+    case EV_KEY_UP:
+        sendIt(readUint16(p), type == EV_KEY_DOWN ? KEY_DOWN : KEY_UP, isDxGame);
+        break;
+    case EV_MOUSE_MOVE:
+    case EV_MOUSE_DOWN:
+    case EV_MOUSE_UP:
+        // Events are in the same order as MOUSE_MOVE, MOUSE_DOWN and MOUSE_UP
+        sendMouseDown(p[0], type - EV_MOUSE_MOVE, readUint16(p + 1) / 65535.0f, readUint16(p + 3) / 65535.0f);
+        break;
+    case EV_BUTTON_DOWN:
+        sendMouseButton(p[0], MOUSE_DOWN);
+        break;
+    case EV_BUTTON_UP:
+        sendMouseButton(p[0], MOUSE_UP);
+        break;
+    case EV_WHEEL:
+        sendMouseWheel(int16_t(readUint16(p)), int16_t(readUint16(p + 2)));
+        break;
+    case EV_MOVE_RELATIVE:
+        sendRelativeMouseMove(int16_t(readUint16(p)), int16_t(readUint16(p + 2)));
+        break;
+    case EV_GAMEPAD_CONNECT:
+    case EV_GAMEPAD_DISCONNECT:
+    case EV_GAMEPAD_BUTTON:
+    case EV_GAMEPAD_AXIS:
+    case EV_GAMEPAD_TRIGGER:
     {
-        Mouse mouse = parseMousePayload(ev.substr(1, ev.length() - 1));
-        float x = mouse.x;
-        float y = mouse.y;
-        sendMouseDown(mouse.isLeft, mouse.state, x, y);
+        // Gamepad events are numbered in the same order by gamepad.py
+        stringstream ss;
+        ss << int(p[0]) << ',' << type - EV_GAMEPAD_CONNECT << ',' << int(p[1]) << ','
+           << int16_t(readUint16(p + 2)) / 32767.0;
+        sendGamepadEvent(ss.str());
+        break;
+    }
+    case EV_MARKER:
+        send(server, (const char *)frame, 2 + payloadSize(type), 0);
+        break;
     }
 }
 
@@ -358,6 +497,11 @@ int main(int argc, char *argv[])
         cout << "Wine screen height " << argv[6] << endl;
         wineScreenHeight = stoi(argv[6]);
     }
+    if (argc > 7)
+    {
+        cout << "Gamepad port " << argv[7] << endl;
+        gamepadPort = stoi(argv[7]);
+    }
 
     server = clientConnect();
 
@@ -383,43 +527,53 @@ int main(int argc, char *argv[])
 
     int recv_size;
     char buf[2000];
-    istringstream iss("");
-    string ev;
+    // Received bytes which don't make a whole frame yet
+    vector<unsigned char> pending;
 
     do
     {
         //Receive a reply from the server
-        if ((recv_size = recv(server, buf, 1024, 0)) == SOCKET_ERROR)
+        if ((recv_size = recv(server, buf, sizeof(buf), 0)) == SOCKET_ERROR)
         {
             puts("recv failed");
             Sleep(1000);
             continue;
         }
+        pending.insert(pending.end(), buf, buf + recv_size);
 
-        char *buffer = new char[recv_size];
-        memcpy(buffer, buf, recv_size);
-        if (recv_size == 1)
+        size_t offset = 0;
+        while (offset < pending.size())
         {
-            if (buffer[0] == 0)
+            const unsigned char *frame = pending.data() + offset;
+            size_t available = pending.size() - offset;
+            if (frame[0] == PING)
             {
-                // Received ping
                 last_ping = chrono::system_clock::now();
-            };
-        }
-
-        try
-        {
-            stringstream ss(buffer);
+                offset++;
+                continue;
+            }
+            if (available < 2)
+            {
+                break;
+            }
 
-            while (getline(ss, ev, '|'))
+            int size = payloadSize(frame[1]);
+            if (frame[0] != INPUT_VERSION || size < 0)
             {
-                processEvent(ev, isDxGame);
+                // Frames can't be delimited anymore, drop what was received
+                cout << "Invalid input frame " << int(frame[0]) << ' ' << int(frame[1]) << endl;
+                offset = pending.size();
+                break;
             }
+            if (available < size_t(2 + size))
+            {
+                break;
+            }
+
+            processEvent(frame, isDxGame);
+            offset += 2 + size;
         }
-        catch (const std::exception &e)
-        {
-            cout << "exception" << e.what() << endl;
-        }
+        pending.erase(pending.begin(), pending.begin() + offset);
     } while (true);
     closesocket(server);
     cout << "Socket closed." << endl
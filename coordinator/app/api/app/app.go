@@ -7,6 +7,7 @@ import (
 	"net/http"
 
 	"coordinator/app/api/response"
+	"coordinator/app/storage"
 	"coordinator/utils"
 
 	"gopkg.in/yaml.v3"
@@ -18,12 +19,12 @@ type App struct {
 	Type      string   `yaml:"type" json:"type"`
 	PosterURL string   `yaml:"poster_url" json:"posterURL"`
 	Device    []string `yaml:"device" json:"device"`
+	// Price of a minute of play in cents
+	RatePerMinute int64 `yaml:"rate_per_minute" json:"ratePerMinute"`
 }
 
-var appList []*App
-
-func getAppList() ([]*App, error) {
-	ymlFile, err := ioutil.ReadFile("app/api/app/apps.yml")
+func readAppFile(path string) ([]*App, error) {
+	ymlFile, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
@@ -36,40 +37,65 @@ func getAppList() ([]*App, error) {
 	return apps, nil
 }
 
-func init() {
-	var err error
-
-	appList, err = getAppList()
+// SeedAppCatalog saves apps of the app file into the catalog, updating the ones which exist
+func SeedAppCatalog(store storage.Storage, path string) error {
+	apps, err := readAppFile(path)
 	if err != nil {
-		log.Fatalln("Couldn't read app list", err)
+		return err
+	}
+
+	for _, app := range apps {
+		err := store.SaveApp(&storage.App{
+			ID:        app.ID,
+			Name:      app.Name,
+			Type:      app.Type,
+			PosterURL: app.PosterURL,
+			Device:    app.Device,
+			// Apps without a rate in the file are billed at the default rate
+			RatePerMinute: app.RatePerMinute,
+		})
+		if err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
 
 type GetAppListResponse struct {
 	Apps []*App `json:"apps"`
 }
 
-func GetAppList(w http.ResponseWriter, r *http.Request) {
-	resp := response.Response{
-		Data: GetAppListResponse{Apps: appList},
+func GetAppList(store storage.Storage, w http.ResponseWriter, r *http.Request) {
+	storedApps, err := store.ListApps()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("Couldn't list apps", err)
+		return
 	}
 
 	deviceParams, ok := r.URL.Query()["device"]
-	if ok && len(deviceParams[0]) > 0 {
-		device := deviceParams[0]
-
-		var filteredAppList []*App
-		for _, app := range appList {
-			if utils.InStringSlice(app.Device, device) {
-				filteredAppList = append(filteredAppList, app)
-			}
-		}
+	filterDevice := ok && len(deviceParams[0]) > 0
 
-		resp = response.Response{
-			Data: GetAppListResponse{Apps: filteredAppList},
+	appList := make([]*App, 0, len(storedApps))
+	for _, app := range storedApps {
+		if filterDevice && !utils.InStringSlice(app.Device, deviceParams[0]) {
+			continue
 		}
+
+		appList = append(appList, &App{
+			ID:            app.ID,
+			Name:          app.Name,
+			Type:          app.Type,
+			PosterURL:     app.PosterURL,
+			Device:        app.Device,
+			RatePerMinute: app.RatePerMinute,
+		})
 	}
 
+	resp := response.Response{
+		Data: GetAppListResponse{Apps: appList},
+	}
 	jsonResp, err := json.Marshal(resp)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"coordinator/app/api/response"
+	"coordinator/app/auth"
+	"coordinator/app/storage"
+)
+
+type Credentials struct {
+	Name     string    `json:"name"`
+	Password string    `json:"password"`
+	Role     auth.Role `json:"role"`
+}
+
+type TokenResp struct {
+	AccountID string    `json:"accountID"`
+	Role      auth.Role `json:"role"`
+	Token     string    `json:"token"`
+}
+
+const minPasswordLen = 8
+
+func parseCredentials(r *http.Request) (*Credentials, error) {
+	var creds Credentials
+	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+		return nil, errors.New("invalid request body")
+	}
+	if creds.Name == "" || creds.Password == "" {
+		return nil, errors.New("missing name or password")
+	}
+
+	return &creds, nil
+}
+
+func writeToken(w http.ResponseWriter, account *storage.Account) {
+	role := auth.Role(account.Role)
+	token, err := auth.IssueToken(account.ID, role)
+	if err != nil {
+		response.WriteError(w, http.StatusInternalServerError, errors.New("couldn't issue token"))
+		return
+	}
+
+	response.Write(w, http.StatusOK, response.Response{
+		Data: TokenResp{
+			AccountID: account.ID,
+			Role:      role,
+			Token:     token,
+		},
+	})
+}
+
+func Register(accounts *auth.Accounts, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	creds, err := parseCredentials(r)
+	if err != nil {
+		response.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(creds.Password) < minPasswordLen {
+		response.WriteError(w, http.StatusBadRequest, errors.New("password is too short"))
+		return
+	}
+	if creds.Role == "" {
+		creds.Role = auth.PlayerRole
+	}
+
+	account, err := accounts.Register(creds.Name, creds.Password, creds.Role)
+	if err != nil {
+		if errors.Is(err, auth.ErrAccountExists) {
+			response.WriteError(w, http.StatusConflict, err)
+		} else if errors.Is(err, auth.ErrInvalidRole) {
+			response.WriteError(w, http.StatusBadRequest, err)
+		} else {
+			log.Println("Couldn't register account", err)
+			response.WriteError(w, http.StatusInternalServerError, errors.New("couldn't register account"))
+		}
+		return
+	}
+
+	writeToken(w, account)
+}
+
+func Login(accounts *auth.Accounts, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		response.WriteError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+
+	creds, err := parseCredentials(r)
+	if err != nil {
+		response.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	account, err := accounts.Authenticate(creds.Name, creds.Password)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			response.WriteError(w, http.StatusUnauthorized, err)
+		} else {
+			log.Println("Couldn't authenticate account", err)
+			response.WriteError(w, http.StatusInternalServerError, errors.New("couldn't authenticate account"))
+		}
+		return
+	}
+
+	writeToken(w, account)
+}
@@ -0,0 +1,36 @@
+package credit
+
+import (
+	"log"
+	"net/http"
+
+	"coordinator/app/api/response"
+	"coordinator/app/auth"
+	"coordinator/app/storage"
+)
+
+type GetCreditResp struct {
+	AccountID string `json:"accountID"`
+	// Remaining play minutes
+	Minutes int `json:"minutes"`
+}
+
+// GetCredit returns the play time balance of the authenticated account
+func GetCredit(store storage.Storage, w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.FromRequest(r)
+	if err != nil {
+		response.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	balance, err := store.GetCredit(claims.Subject)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("Couldn't get credit of", claims.Subject, err)
+		return
+	}
+
+	response.Write(w, http.StatusOK, response.Response{
+		Data: GetCreditResp{AccountID: claims.Subject, Minutes: balance},
+	})
+}
@@ -0,0 +1,89 @@
+package owner
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"coordinator/app/api/response"
+	"coordinator/app/auth"
+	"coordinator/app/ledger"
+)
+
+const dateLayout = "2006-01-02"
+
+// GetEarnings reports earnings of the owner of the /owners/{id}/earnings path.
+// Query params: period (day or month), from and to (inclusive dates in form of YYYY-MM-DD, UTC).
+func GetEarnings(l *ledger.Ledger, w http.ResponseWriter, r *http.Request) {
+	ownerID, ok := parseEarningsPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	claims, err := auth.FromRequest(r)
+	if err != nil {
+		response.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+	if claims.Subject != ownerID {
+		response.WriteError(w, http.StatusForbidden, errors.New("earnings of other owners can't be read"))
+		return
+	}
+
+	query := r.URL.Query()
+	period, err := ledger.ParsePeriod(query.Get("period"))
+	if err != nil {
+		response.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	from, err := parseDate(query.Get("from"))
+	if err != nil {
+		response.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	to, err := parseDate(query.Get("to"))
+	if err != nil {
+		response.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	if !to.IsZero() {
+		// Include the whole last day
+		to = to.AddDate(0, 0, 1)
+	}
+
+	report, err := l.Report(ownerID, period, from, to)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("Couldn't report earnings of", ownerID, err)
+		return
+	}
+
+	response.Write(w, http.StatusOK, response.Response{Data: report})
+}
+
+// parseEarningsPath extracts the owner ID of /owners/{id}/earnings
+func parseEarningsPath(path string) (string, bool) {
+	parts := strings.Split(strings.TrimPrefix(path, "/owners/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] != "earnings" {
+		return "", false
+	}
+
+	return parts[0], true
+}
+
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, expected YYYY-MM-DD", s)
+	}
+
+	return t, nil
+}
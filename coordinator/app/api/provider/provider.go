@@ -2,45 +2,109 @@ package provider
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 
 	"coordinator/app/api/response"
+	"coordinator/app/auth"
 	"coordinator/app/client"
+	"coordinator/app/storage"
 )
 
 type Provider struct {
-	ID         string  `json:"id"`
-	HostName   string  `json:"hostName"`
-	Platform   string  `json:"platform"`
-	CpuName    string  `json:"cpuName"`
-	CpuNum     int     `json:"cpuNum"`
-	MemSize    float64 `json:"memSize"`
-	CpuPercent float64 `json:"cpuPercent"`
-	MemPercent float64 `json:"memPercent"`
+	ID          string  `json:"id"`
+	HostName    string  `json:"hostName"`
+	Platform    string  `json:"platform"`
+	CpuName     string  `json:"cpuName"`
+	CpuNum      int     `json:"cpuNum"`
+	MemSize     float64 `json:"memSize"`
+	CpuPercent  float64 `json:"cpuPercent"`
+	MemPercent  float64 `json:"memPercent"`
+	MaxSessions int     `json:"maxSessions"`
+	FreeSlots   int     `json:"freeSlots"`
+	// Video codecs the provider can stream
+	Codecs []string `json:"codecs,omitempty"`
+	// ID the provider is registered with by its owner
+	RegisteredID string `json:"registeredID"`
+	Online       bool   `json:"online"`
 }
 
 type GetProviderListResp struct {
 	Providers []*Provider `json:"providers"`
 }
 
-func GetProviderList(hub *client.Hub, w http.ResponseWriter, r *http.Request) {
+func GetProviderList(hub *client.Hub, store storage.Storage, w http.ResponseWriter, r *http.Request) {
 	hasOwnerIDParam := r.URL.Query().Has("owner")
 	ownerID := r.URL.Query().Get("owner")
 
+	// Only owners can list their own providers
+	if hasOwnerIDParam {
+		claims, err := auth.FromRequest(r)
+		if err != nil {
+			response.WriteError(w, http.StatusUnauthorized, err)
+			return
+		}
+		if claims.Subject != ownerID {
+			response.WriteError(w, http.StatusForbidden, errors.New("providers of other owners can't be listed"))
+			return
+		}
+	}
+
+	candidates := hub.GetProviders()
+	// Only list providers which can take new sessions
+	if r.URL.Query().Get("available") == "true" {
+		candidates = hub.GetAvailableProviders()
+	}
+
 	providers := make([]*Provider, 0)
 
-	for _, p := range hub.GetProviders() {
-		if !hasOwnerIDParam || p.Provider.OwnerID == ownerID {
+	for _, p := range candidates {
+		// Providers update their info while it is listed
+		info := p.Provider.Snapshot()
+		if !hasOwnerIDParam || info.OwnerID == ownerID {
+			providers = append(providers, &Provider{
+				ID:           p.ID,
+				HostName:     info.HostName,
+				Platform:     info.Platform,
+				CpuName:      info.CpuName,
+				CpuNum:       info.CpuNum,
+				MemSize:      info.MemSize,
+				CpuPercent:   info.CpuPercent,
+				MemPercent:   info.MemPercent,
+				MaxSessions:  info.MaxSessions,
+				FreeSlots:    info.FreeSlots(),
+				Codecs:       info.Codecs,
+				RegisteredID: info.RegisteredID,
+				Online:       true,
+			})
+		}
+	}
+
+	// Owners also see their registered providers which are offline
+	if hasOwnerIDParam {
+		registered, err := store.ListProviders(ownerID)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Println("Couldn't list registered providers", err)
+			return
+		}
+
+		online := make(map[string]bool)
+		for _, p := range providers {
+			online[p.RegisteredID] = true
+		}
+		for _, p := range registered {
+			if online[p.ID] {
+				continue
+			}
 			providers = append(providers, &Provider{
-				ID:         p.ID,
-				HostName:   p.Provider.HostName,
-				Platform:   p.Provider.Platform,
-				CpuName:    p.Provider.CpuName,
-				CpuNum:     p.Provider.CpuNum,
-				MemSize:    p.Provider.MemSize,
-				CpuPercent: p.Provider.CpuPercent,
-				MemPercent: p.Provider.MemPercent,
+				HostName:     p.HostName,
+				Platform:     p.Platform,
+				CpuName:      p.CpuName,
+				CpuNum:       p.CpuNum,
+				MemSize:      p.MemSize,
+				RegisteredID: p.ID,
 			})
 		}
 	}
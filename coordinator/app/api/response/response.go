@@ -1,8 +1,33 @@
 package response
 
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
 type Response struct {
 	Error     string      `json:"error"`
 	ErrorCode int         `json:"error_code"`
 	Data      interface{} `json:"data"`
 }
 
+func Write(w http.ResponseWriter, status int, resp Response) {
+	jsonResp, err := json.Marshal(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("Couldn't marshall response to JSON", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonResp)
+}
+
+func WriteError(w http.ResponseWriter, status int, err error) {
+	Write(w, status, Response{
+		Error:     err.Error(),
+		ErrorCode: status,
+	})
+}
@@ -0,0 +1,117 @@
+package save
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"coordinator/app/api/response"
+	"coordinator/app/auth"
+	"coordinator/app/storage"
+	"coordinator/settings"
+)
+
+// GetSaves sends the latest saves of the player of the /saves/{sessionID} session for its app,
+// to the provider of the session while it's running
+func GetSaves(store storage.Storage, w http.ResponseWriter, r *http.Request) {
+	s, ok := providerSession(store, w, r)
+	if !ok {
+		return
+	}
+	if s.State.Done() {
+		response.WriteError(w, http.StatusForbidden, errors.New("session has ended"))
+		return
+	}
+
+	saves, err := store.GetSaveGame(s.PlayerID, s.AppID)
+	if errors.Is(err, storage.ErrNotFound) {
+		response.WriteError(w, http.StatusNotFound, errors.New("player has no saves for the app"))
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("Couldn't get saves of session", s.ID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Last-Modified", saves.UpdatedAt.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+	w.Write(saves.Archive)
+}
+
+// PutSaves stores the saves of the /saves/{sessionID} session as the latest saves of its player for its app.
+// The provider of the session may upload them until settings.SaveUploadWindow after the session ended.
+func PutSaves(store storage.Storage, w http.ResponseWriter, r *http.Request) {
+	s, ok := providerSession(store, w, r)
+	if !ok {
+		return
+	}
+	if s.State.Done() && time.Since(s.EndedAt) > settings.SaveUploadWindow {
+		response.WriteError(w, http.StatusForbidden, errors.New("session ended too long ago"))
+		return
+	}
+
+	archive, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, settings.MaxSaveSize))
+	if err != nil {
+		response.WriteError(w, http.StatusRequestEntityTooLarge, errors.New("saves are too large"))
+		return
+	}
+
+	// Saves of a session which started on another provider while this one was running are more recent
+	latest, err := store.GetSaveGame(s.PlayerID, s.AppID)
+	if err == nil && latest.SessionID != s.ID && latest.SessionCreatedAt.After(s.CreatedAt) {
+		response.WriteError(w, http.StatusConflict, errors.New("saves of a more recent session exist"))
+		return
+	} else if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("Couldn't get saves of session", s.ID, err)
+		return
+	}
+
+	err = store.PutSaveGame(&storage.SaveGame{
+		PlayerID:         s.PlayerID,
+		AppID:            s.AppID,
+		SessionID:        s.ID,
+		SessionCreatedAt: s.CreatedAt,
+		Archive:          archive,
+		UpdatedAt:        time.Now(),
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("Couldn't save saves of session", s.ID, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// providerSession gets the session of the /saves/{sessionID} path if the request is authenticated as the owner
+// of its provider, otherwise it writes the error response
+func providerSession(store storage.Storage, w http.ResponseWriter, r *http.Request) (*storage.Session, bool) {
+	claims, err := auth.FromRequest(r)
+	if err != nil {
+		response.WriteError(w, http.StatusUnauthorized, err)
+		return nil, false
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/saves/")
+	s, err := store.GetSession(id)
+	if errors.Is(err, storage.ErrNotFound) {
+		response.WriteError(w, http.StatusNotFound, errors.New("session not found"))
+		return nil, false
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("Couldn't get session", id, err)
+		return nil, false
+	}
+
+	if claims.Role != auth.OwnerRole || s.OwnerID != claims.Subject {
+		response.WriteError(w, http.StatusForbidden, errors.New("only the provider of the session can sync its saves"))
+		return nil, false
+	}
+
+	return s, true
+}
@@ -0,0 +1,168 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"coordinator/app/api/response"
+	"coordinator/app/auth"
+	"coordinator/app/client"
+	"coordinator/app/storage"
+)
+
+type Session struct {
+	ID         string               `json:"id"`
+	PlayerID   string               `json:"playerID"`
+	ProviderID string               `json:"providerID"`
+	AppID      string               `json:"appID"`
+	Device     string               `json:"device"`
+	State      storage.SessionState `json:"state"`
+	CreatedAt  time.Time            `json:"createdAt"`
+	StartedAt  *time.Time           `json:"startedAt,omitempty"`
+	EndedAt    *time.Time           `json:"endedAt,omitempty"`
+	// Streaming time in seconds
+	Duration float64 `json:"duration"`
+}
+
+func newSession(s *storage.Session) *Session {
+	session := &Session{
+		ID:         s.ID,
+		PlayerID:   s.PlayerID,
+		ProviderID: s.ProviderID,
+		AppID:      s.AppID,
+		Device:     s.Device,
+		State:      s.State,
+		CreatedAt:  s.CreatedAt,
+		Duration:   s.Duration().Seconds(),
+	}
+	if !s.StartedAt.IsZero() {
+		session.StartedAt = &s.StartedAt
+	}
+	if !s.EndedAt.IsZero() {
+		session.EndedAt = &s.EndedAt
+	}
+
+	return session
+}
+
+type GetSessionListResp struct {
+	Sessions []*Session `json:"sessions"`
+	// Streaming time of all listed sessions in seconds
+	TotalDuration float64 `json:"totalDuration"`
+}
+
+// GetSessionList lists sessions played by the player or hosted on providers of the owner, the most recent first.
+// They can be filtered by player, provider and app.
+func GetSessionList(store storage.Storage, w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.FromRequest(r)
+	if err != nil {
+		response.WriteError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := storage.SessionFilter{
+		PlayerID:   query.Get("player"),
+		ProviderID: query.Get("provider"),
+		AppID:      query.Get("app"),
+	}
+	if claims.Role == auth.OwnerRole {
+		filter.OwnerID = claims.Subject
+	} else {
+		if filter.PlayerID != "" && filter.PlayerID != claims.Subject {
+			response.WriteError(w, http.StatusForbidden, errors.New("sessions of other players can't be listed"))
+			return
+		}
+		filter.PlayerID = claims.Subject
+	}
+
+	stored, err := store.ListSessions(filter)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("Couldn't list sessions", err)
+		return
+	}
+
+	resp := GetSessionListResp{Sessions: make([]*Session, 0, len(stored))}
+	for _, s := range stored {
+		session := newSession(s)
+		resp.Sessions = append(resp.Sessions, session)
+		resp.TotalDuration += session.Duration
+	}
+
+	response.Write(w, http.StatusOK, response.Response{Data: resp})
+}
+
+// GetSession returns the session of the /sessions/{id} path to its player or to the owner of its provider
+func GetSession(store storage.Storage, w http.ResponseWriter, r *http.Request) {
+	s, ok := readableSession(store, strings.TrimPrefix(r.URL.Path, "/sessions/"), w, r)
+	if !ok {
+		return
+	}
+
+	response.Write(w, http.StatusOK, response.Response{Data: newSession(s)})
+}
+
+type GetSessionStatsResp struct {
+	SessionID string `json:"sessionID"`
+	// Whether the session is still running
+	Live bool `json:"live"`
+	// Latest reports of a running session, or the last report of an ended one, the oldest first
+	Samples []*client.StatsSample `json:"samples"`
+}
+
+// GetSessionStats returns stream quality reports of the session of the /sessions/{id}/stats path
+func GetSessionStats(hub *client.Hub, store storage.Storage, w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/sessions/"), "/stats")
+	s, ok := readableSession(store, id, w, r)
+	if !ok {
+		return
+	}
+
+	resp := GetSessionStatsResp{SessionID: s.ID, Samples: make([]*client.StatsSample, 0)}
+	if samples, live := hub.SessionStats(s.ID); live {
+		resp.Live = true
+		resp.Samples = samples
+	} else if len(s.Stats) > 0 {
+		var last client.StatsSample
+		if err := json.Unmarshal(s.Stats, &last); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Println("Couldn't parse stats of session", s.ID, err)
+			return
+		}
+		resp.Samples = append(resp.Samples, &last)
+	}
+
+	response.Write(w, http.StatusOK, response.Response{Data: resp})
+}
+
+// readableSession gets the session if the request is authenticated as its player or the owner of its provider,
+// otherwise it writes the error response
+func readableSession(store storage.Storage, id string, w http.ResponseWriter, r *http.Request) (*storage.Session, bool) {
+	claims, err := auth.FromRequest(r)
+	if err != nil {
+		response.WriteError(w, http.StatusUnauthorized, err)
+		return nil, false
+	}
+
+	s, err := store.GetSession(id)
+	if errors.Is(err, storage.ErrNotFound) {
+		response.WriteError(w, http.StatusNotFound, errors.New("session not found"))
+		return nil, false
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Println("Couldn't get session", id, err)
+		return nil, false
+	}
+
+	if s.PlayerID != claims.Subject && s.OwnerID != claims.Subject {
+		response.WriteError(w, http.StatusForbidden, errors.New("sessions of other accounts can't be read"))
+		return nil, false
+	}
+
+	return s, true
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"coordinator/app/storage"
+	"coordinator/settings"
+	"coordinator/utils"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type Role string
+
+const (
+	// Owners offer their computers as providers, they can also play
+	OwnerRole  Role = "owner"
+	PlayerRole Role = "player"
+)
+
+var (
+	ErrAccountExists      = errors.New("account already exists")
+	ErrInvalidCredentials = errors.New("invalid name or password")
+	ErrInvalidRole        = errors.New("invalid role")
+)
+
+// Accounts registers and authenticates accounts kept in the storage
+type Accounts struct {
+	store storage.Storage
+}
+
+func NewAccounts(store storage.Storage) *Accounts {
+	return &Accounts{store: store}
+}
+
+func (a *Accounts) Register(name, password string, role Role) (*storage.Account, error) {
+	if role != OwnerRole && role != PlayerRole {
+		return nil, ErrInvalidRole
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	account := &storage.Account{
+		ID:           utils.RandString(12),
+		Name:         name,
+		Role:         string(role),
+		PasswordHash: hash,
+		CreatedAt:    time.Now(),
+	}
+	if err := a.store.CreateAccount(account); err != nil {
+		if errors.Is(err, storage.ErrExists) {
+			return nil, ErrAccountExists
+		}
+		return nil, err
+	}
+	if _, err := a.store.AdjustCredit(account.ID, settings.SignupCredit); err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+func (a *Accounts) Authenticate(name, password string) (*storage.Account, error) {
+	account, err := a.store.GetAccountByName(name)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+	if err := bcrypt.CompareHashAndPassword(account.PasswordHash, []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return account, nil
+}
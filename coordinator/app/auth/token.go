@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"coordinator/settings"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("expired token")
+	ErrMissingToken = errors.New("missing token")
+)
+
+// Claims are the verified identity carried by a token
+type Claims struct {
+	// ID of the account
+	Subject   string `json:"sub"`
+	Role      Role   `json:"role"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// IssueToken signs claims of the account, the token is valid for settings.TokenTTL
+func IssueToken(accountID string, role Role) (string, error) {
+	claims := Claims{
+		Subject:   accountID,
+		Role:      role,
+		ExpiresAt: time.Now().Add(settings.TokenTTL).Unix(),
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+
+	return encodedPayload + "." + sign(encodedPayload), nil
+}
+
+// VerifyToken checks the signature and expiration of the token and returns its claims
+func VerifyToken(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(sign(parts[0])), []byte(parts[1])) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().Unix() > claims.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
+
+func sign(payload string) string {
+	mac := hmac.New(sha256.New, settings.AuthSecret)
+	mac.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// FromRequest verifies the token sent in the Authorization header.
+// Browsers can't set headers of websocket requests, so the token may be sent as a query param instead.
+func FromRequest(r *http.Request) (*Claims, error) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	if token == "" {
+		return nil, ErrMissingToken
+	}
+
+	return VerifyToken(token)
+}
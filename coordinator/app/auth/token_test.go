@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"coordinator/settings"
+)
+
+func TestVerifyIssuedToken(t *testing.T) {
+	token, err := IssueToken("owner1", OwnerRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := VerifyToken(token)
+	if err != nil {
+		t.Fatalf("expected valid token, got %v", err)
+	}
+	if claims.Subject != "owner1" || claims.Role != OwnerRole {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+}
+
+func TestVerifyRejectsTamperedToken(t *testing.T) {
+	token, err := IssueToken("player1", PlayerRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := IssueToken("owner1", OwnerRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Payload of one account with the signature of another
+	forged := strings.Split(other, ".")[0] + "." + strings.Split(token, ".")[1]
+	for _, tok := range []string{forged, "", "abc", token + "x"} {
+		if _, err := VerifyToken(tok); err != ErrInvalidToken {
+			t.Errorf("expected invalid token for %q, got %v", tok, err)
+		}
+	}
+}
+
+func TestVerifyRejectsExpiredToken(t *testing.T) {
+	ttl := settings.TokenTTL
+	settings.TokenTTL = -time.Minute
+	defer func() { settings.TokenTTL = ttl }()
+
+	token, err := IssueToken("player1", PlayerRole)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyToken(token); err != ErrExpiredToken {
+		t.Errorf("expected expired token, got %v", err)
+	}
+}
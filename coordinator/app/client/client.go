@@ -1,11 +1,19 @@
 package client
 
 import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"log"
+	"regexp"
 	"sync"
 	"time"
 
+	"coordinator/app/auth"
+	"coordinator/app/ledger"
+	"coordinator/app/storage"
 	"coordinator/constants"
+	"coordinator/settings"
 	"coordinator/utils"
 
 	"github.com/gorilla/websocket"
@@ -26,71 +34,284 @@ const (
 	maxMessageSize = 10240
 )
 
+var ErrInvalidIdentity = errors.New("invalid provider identity")
+
+// IDs of provider identities are chosen by providers, they must be long enough not to be guessed
+var validProviderID = regexp.MustCompile(`^[A-Za-z0-9]{16,64}$`)
+
+// ProviderIdentity lets a provider keep its client ID when it reconnects, so its sessions stay reachable
+type ProviderIdentity struct {
+	ID     string
+	Secret string
+}
+
 type ProviderInfo struct {
-	OwnerID    string
-	HostName   string
-	Platform   string
-	CpuName    string
-	CpuNum     int
-	MemSize    float64
-	CpuPercent float64
-	MemPercent float64
+	// ID the provider is registered with in the storage
+	RegisteredID string
+	OwnerID      string
+	HostName     string
+	Platform     string
+	CpuName      string
+	CpuNum       int
+	MemSize      float64
+	CpuPercent   float64
+	MemPercent   float64
+	MaxSessions  int
+	// Apps the provider can run, in form of <appID>_<device>
+	Apps []string
+	// Video codecs the provider can stream, unknown for providers of older versions
+	Codecs []string
+	// Number of running sessions, as last reported by the provider plus sessions started since then
+	activeSessions int
+	mu             sync.Mutex
+}
+
+func (p *ProviderInfo) FreeSlots() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.activeSessions >= p.MaxSessions {
+		return 0
+	}
+	return p.MaxSessions - p.activeSessions
+}
+
+// Reserve takes a free slot for a new session until the provider reports its actual number of sessions
+func (p *ProviderInfo) Reserve() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.activeSessions >= p.MaxSessions {
+		return false
+	}
+	p.activeSessions++
+
+	return true
+}
+
+// Release frees a slot reserved for a session which won't be started
+func (p *ProviderInfo) Release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.activeSessions > 0 {
+		p.activeSessions--
+	}
+}
+
+// Snapshot returns a copy of the info of the provider, which can be read while the provider keeps updating its info
+func (p *ProviderInfo) Snapshot() *ProviderInfo {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return &ProviderInfo{
+		RegisteredID:   p.RegisteredID,
+		OwnerID:        p.OwnerID,
+		HostName:       p.HostName,
+		Platform:       p.Platform,
+		CpuName:        p.CpuName,
+		CpuNum:         p.CpuNum,
+		MemSize:        p.MemSize,
+		CpuPercent:     p.CpuPercent,
+		MemPercent:     p.MemPercent,
+		MaxSessions:    p.MaxSessions,
+		Apps:           p.Apps,
+		Codecs:         p.Codecs,
+		activeSessions: p.activeSessions,
+	}
+}
+
+// join updates the info of the provider with what it reports when it joins.
+// Slots reserved for sessions it hasn't reported yet are kept when it joins again after reconnecting.
+func (p *ProviderInfo) join(joinData *JoinData) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.HostName = joinData.HostName
+	p.Platform = joinData.Platform
+	p.CpuName = joinData.CpuName
+	p.CpuNum = joinData.CpuNum
+	p.MemSize = joinData.MemSize
+	p.CpuPercent = joinData.CpuPercent
+	p.MemPercent = joinData.MemPercent
+	// Providers of older versions don't report their capacity
+	p.MaxSessions = 1
+	if joinData.MaxSessions > 0 {
+		p.MaxSessions = joinData.MaxSessions
+	}
+	p.Apps = joinData.Apps
+	p.Codecs = joinData.Codecs
+	if joinData.ActiveSessions > p.activeSessions {
+		p.activeSessions = joinData.ActiveSessions
+	}
+}
+
+func (p *ProviderInfo) register(registeredID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.RegisteredID = registeredID
+}
+
+func (p *ProviderInfo) updateStats(stats *StatsData) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.CpuPercent = stats.CpuPercent
+	p.MemPercent = stats.MemPercent
+	p.activeSessions = stats.ActiveSessions
 }
 
 type Client struct {
-	ID        string
-	role      constants.Role
-	hub       *Hub
-	conn      *websocket.Conn
-	outputBuf chan interface{}
+	ID string
+	// Verified account the client is connected as
+	AccountID   string
+	accountRole auth.Role
+	role        constants.Role
+	hub         *Hub
+	conn        *websocket.Conn
+	outputBuf   chan interface{}
+	// Secret of the identity of a provider which keeps its ID when it reconnects, empty for other clients
+	secret string
+	// Whether the provider lost its connection and may still reconnect
+	detached bool
+	// Ends sessions of the detached provider unless it reconnects in time
+	leaveTimer *time.Timer
+	// Guards the connection and the fields above
+	connMu sync.Mutex
 	// Info of provider
 	Provider *ProviderInfo
 }
 
-func NewClient(id string, conn *websocket.Conn, hub *Hub) *Client {
-	c := &Client{
-		ID:        id,
-		conn:      conn,
-		hub:       hub,
-		outputBuf: make(chan interface{}),
+func NewClient(id string, claims *auth.Claims, conn *websocket.Conn, hub *Hub) *Client {
+	c := newClient(id, claims, hub)
+	c.attach(conn)
+
+	return c
+}
+
+func newClient(id string, claims *auth.Claims, hub *Hub) *Client {
+	return &Client{
+		ID:          id,
+		AccountID:   claims.Subject,
+		accountRole: claims.Role,
+		hub:         hub,
 	}
+}
 
-	go c.readPump()
-	go c.writePump()
+// attach makes the client read and write through the connection, in place of the previous one if any
+func (c *Client) attach(conn *websocket.Conn) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
 
-	return c
+	// Previous connection of a reconnecting provider may not have timed out yet
+	if c.conn != nil {
+		close(c.outputBuf)
+		c.conn.Close()
+	}
+	if c.leaveTimer != nil {
+		c.leaveTimer.Stop()
+		c.leaveTimer = nil
+	}
+	c.conn = conn
+	c.outputBuf = make(chan interface{})
+	c.detached = false
+
+	go c.readPump(conn)
+	go c.writePump(conn, c.outputBuf)
 }
 
-func (c *Client) close() {
+// detach drops the connection, it returns false if the connection has already been replaced
+func (c *Client) detach(conn *websocket.Conn) bool {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	if c.conn != conn {
+		return false
+	}
 	close(c.outputBuf)
-	c.conn.Close()
+	conn.Close()
+	c.conn = nil
+	c.detached = true
+
+	return true
+}
+
+func (c *Client) isDetached() bool {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	return c.detached
+}
+
+func (c *Client) output() chan interface{} {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	return c.outputBuf
+}
+
+func (c *Client) close(conn *websocket.Conn) {
+	if !c.detach(conn) {
+		return
+	}
+
+	// Sessions of the provider stay reachable if it reconnects in time
+	if c.role == constants.Provider && c.secret != "" {
+		log.Printf("Provider %s disconnected, waiting %s for it to reconnect", c.ID, settings.ProviderReconnectWindow)
+		c.connMu.Lock()
+		c.leaveTimer = time.AfterFunc(settings.ProviderReconnectWindow, func() {
+			c.hub.removeDetached(c)
+		})
+		c.connMu.Unlock()
+		return
+	}
+
+	c.leave()
+}
+
+// leave ends whatever the client was taking part in
+func (c *Client) leave() {
+	c.hub.leaveQueue(c)
+	c.hub.stopSpectating(c)
+	c.hub.leaveSeat(c)
+	if c.role == constants.Provider {
+		c.hub.endProviderSessions(c)
+		c.hub.touchProvider(c.Provider.Snapshot().RegisteredID)
+	}
 	c.hub.RemoveClient(c)
 }
 
 func (c *Client) sendMsg(receiver *Client, msg interface{}) {
-	receiver.outputBuf <- msg
+	defer func() {
+		if r := recover(); r != nil {
+			// maybe receiver has disconnected
+		}
+	}()
+
+	receiver.output() <- msg
 }
 
-func (c *Client) readPump() {
+func (c *Client) readPump(conn *websocket.Conn) {
 	defer func() {
-		c.close()
+		c.close(conn)
 	}()
 
-	c.conn.SetReadLimit(maxMessageSize)
-	c.conn.SetReadDeadline(time.Now().Add(pongWait))
-	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(pongWait))
 		return nil
 	})
 
 	for {
-		_, rawMsg, err := c.conn.ReadMessage()
+		_, rawMsg, err := conn.ReadMessage()
 		if err != nil {
-			if _, ok := err.(*websocket.CloseError); ok {
-				return
-			} else {
+			// Connection can't be read anymore, e.g. when a provider stopped answering pings
+			if _, ok := err.(*websocket.CloseError); !ok {
 				log.Println("Couldn't read WS message", err)
 			}
+			return
 		}
 		msg, err := parseMsg(rawMsg)
 		if err != nil {
@@ -109,26 +330,33 @@ func (c *Client) handleJoinMsg(msg *Message) error {
 	}
 
 	if joinData.Role == constants.Provider {
-		ownerID := joinData.OwnerID
-		if ownerID == "" {
-			ownerID = utils.RandString(6)
-		}
-		c.role = constants.Provider
-		c.Provider = &ProviderInfo{
-			OwnerID:    ownerID,
-			HostName:   joinData.HostName,
-			Platform:   joinData.Platform,
-			CpuName:    joinData.CpuName,
-			CpuNum:     joinData.CpuNum,
-			MemSize:    joinData.MemSize,
-			CpuPercent: joinData.CpuPercent,
-			MemPercent: joinData.MemPercent,
+		if c.accountRole != auth.OwnerRole {
+			c.sendError(constants.CoordinatorStage, constants.ForbiddenError, "Only owners can join as providers")
+			return errors.New("player account can't join as provider")
+		}
+		// Providers always belong to the account they authenticated with
+		ownerID := c.AccountID
+		// A reconnecting provider joins again, matchmaking may be using its info in the meantime
+		if c.Provider == nil {
+			c.role = constants.Provider
+			c.Provider = &ProviderInfo{OwnerID: ownerID}
 		}
+		c.Provider.join(joinData)
+		registeredID, err := c.hub.registerProvider(c.Provider.Snapshot())
+		if err != nil {
+			log.Println("Couldn't register provider", err)
+			return err
+		}
+		c.Provider.register(registeredID)
+		// Provider may join again after losing its connection while sessions were running
+		c.hub.syncProviderSessions(c, joinData.Sessions)
 
 		c.sendMsg(c, Message{
 			Type: constants.JoinAcceptedMessage,
 			Data: ownerID,
 		})
+
+		c.hub.DispatchQueue()
 	} else {
 		c.role = constants.Player
 	}
@@ -143,8 +371,10 @@ func (c *Client) handleStatsMsg(msg *Message) error {
 	}
 
 	if c.role == constants.Provider {
-		c.Provider.CpuPercent = statsData.CpuPercent
-		c.Provider.MemPercent = statsData.MemPercent
+		c.Provider.updateStats(statsData)
+		if c.Provider.FreeSlots() > 0 {
+			c.hub.DispatchQueue()
+		}
 	}
 
 	return nil
@@ -160,38 +390,231 @@ func (c *Client) handleMsg(msg *Message) {
 		if err := c.handleStatsMsg(msg); err != nil {
 			return
 		}
+	case constants.ErrorMessage:
+		if err := c.handleErrorMsg(msg); err != nil {
+			return
+		}
+	case constants.PlayMessage:
+		if err := c.handlePlayMsg(msg); err != nil {
+			return
+		}
+	case constants.ResumeMessage:
+		if err := c.handleResumeMsg(msg); err != nil {
+			return
+		}
+	case constants.LeaveQueueMessage:
+		c.hub.leaveQueue(c)
+	case constants.InviteMessage:
+		if err := c.handleInviteMsg(msg); err != nil {
+			return
+		}
+	case constants.SpectateMessage:
+		if err := c.handleSpectateMsg(msg); err != nil {
+			return
+		}
+	case constants.StopSpectatingMessage:
+		c.hub.stopSpectating(c)
+	case constants.JoinSeatMessage:
+		if err := c.handleJoinSeatMsg(msg); err != nil {
+			return
+		}
+	case constants.SeatMessage:
+		if err := c.handleSeatMsg(msg); err != nil {
+			return
+		}
+	case constants.KickMessage:
+		if err := c.handleKickMsg(msg); err != nil {
+			return
+		}
+	case constants.LeaveSeatMessage:
+		c.hub.leaveSeat(c)
+	case constants.StreamingMessage:
+		if c.role == constants.Provider {
+			c.hub.advanceSession(msg.ReceiverID, c, storage.SessionStreaming)
+		}
+	case constants.SessionStatsMessage:
+		if err := c.handleSessionStatsMsg(msg); err != nil {
+			return
+		}
+	case constants.EndedMessage:
+		if c.role != constants.Provider {
+			return
+		}
+		// Provider stopped streaming to a spectator or guest, the session of the player goes on
+		participant := c.hub.removeSpectator(msg.ReceiverID, c)
+		if participant == nil {
+			participant = c.hub.removeGuest(msg.ReceiverID, c)
+		}
+		if participant != nil {
+			c.sendMsg(participant, Message{
+				SenderID: c.ID,
+				Type:     constants.EndedMessage,
+			})
+			return
+		}
+		c.hub.endSession(msg.ReceiverID, c, storage.SessionEnded)
 	default:
 		receiver := c.hub.GetClient(msg.ReceiverID)
-		if receiver == nil {
+		if receiver == nil || receiver.isDetached() {
+			c.sendError(constants.CoordinatorStage, constants.ReceiverNotFoundError, "The receiver is no longer connected")
 			return
 		}
 
+		if msg.Type == constants.StartMessage && receiver.role == constants.Provider {
+			playData, err := parsePlayData(msg.Data)
+			if err != nil {
+				c.sendError(constants.CoordinatorStage, constants.InvalidMessageError, "Invalid start message")
+				return
+			}
+			if !c.hasCredit() {
+				return
+			}
+			if !receiver.Provider.Reserve() {
+				c.sendError(constants.CoordinatorStage, constants.BusyError, "The provider is busy, please choose another one")
+				return
+			}
+			// Provider learns whose session it is, so players can't pretend to be another account
+			ticket := c.hub.beginSession(c, receiver, playData)
+			playData.AccountID = c.AccountID
+			playData.SessionID = ticket.SessionID
+			data, err := json.Marshal(playData)
+			if err != nil {
+				log.Println("Couldn't marshal start message", err)
+				return
+			}
+			msg.Data = string(data)
+			c.sendResumeTicket(ticket)
+		}
+		// Provider sends its offer to start the negotiation
+		if msg.Type == constants.SDPMessage && c.role == constants.Provider {
+			c.hub.advanceSession(receiver.ID, c, storage.SessionNegotiating)
+		}
+
 		msg.SenderID = c.ID
 		c.sendMsg(receiver, msg)
 	}
 }
 
-func (c *Client) writePump() {
+// handleResumeMsg moves a session of the player to this client and asks its provider to offer the streams again
+func (c *Client) handleResumeMsg(msg *Message) error {
+	if c.role == constants.Provider {
+		return nil
+	}
+	ticket, err := parseResumeTicket(msg.Data)
+	if err != nil {
+		c.sendError(constants.CoordinatorStage, constants.InvalidMessageError, "Invalid resume message")
+		return err
+	}
+
+	s, previousID, err := c.hub.resumeSession(c, ticket)
+	if err != nil {
+		log.Printf("Player %s couldn't resume session %s: %s", c.ID, ticket.SessionID, err)
+		c.sendError(constants.CoordinatorStage, constants.SessionNotFoundError, "The session has ended")
+		return err
+	}
+	log.Printf("Player %s resumed session %s of client %s", c.ID, ticket.SessionID, previousID)
+
+	matchedData, err := json.Marshal(MatchedData{ProviderID: s.provider.ID})
+	if err != nil {
+		return err
+	}
+	resumeData, err := json.Marshal(ResumeData{PreviousID: previousID})
+	if err != nil {
+		return err
+	}
+
+	// Player must know the provider before receiving its offer
+	c.sendMsg(c, Message{
+		SenderID: s.provider.ID,
+		Type:     constants.MatchedMessage,
+		Data:     string(matchedData),
+	})
+	c.sendMsg(s.provider, Message{
+		SenderID:   c.ID,
+		ReceiverID: s.provider.ID,
+		Type:       constants.ResumeMessage,
+		Data:       string(resumeData),
+	})
+
+	return nil
+}
+
+// handleErrorMsg relays errors of providers to their players
+func (c *Client) handleErrorMsg(msg *Message) error {
+	errData, err := parseErrorData(msg.Data)
+	if err != nil {
+		log.Println("Couldn't parse error message", err)
+		return err
+	}
+	log.Printf("Error from %s to %s: %s/%s %s", c.ID, msg.ReceiverID, errData.Stage, errData.Code, errData.Message)
+	if c.role == constants.Provider && c.hub.removeSpectator(msg.ReceiverID, c) == nil && c.hub.removeGuest(msg.ReceiverID, c) == nil {
+		c.hub.endSession(msg.ReceiverID, c, storage.SessionFailed)
+	}
+
+	receiver := c.hub.GetClient(msg.ReceiverID)
+	if receiver == nil {
+		return nil
+	}
+
+	msg.SenderID = c.ID
+	c.sendMsg(receiver, msg)
+
+	return nil
+}
+
+func (c *Client) handleSessionStatsMsg(msg *Message) error {
+	if c.role != constants.Provider {
+		return nil
+	}
+
+	stats, err := parseSessionStatsData(msg.Data)
+	if err != nil {
+		log.Println("Couldn't parse session stats", err)
+		return err
+	}
+	c.hub.recordSessionStats(msg.ReceiverID, c, stats)
+
+	return nil
+}
+
+func (c *Client) sendError(stage constants.ErrorStage, code constants.ErrorCode, message string) {
+	data, err := json.Marshal(ErrorData{
+		Stage:   stage,
+		Code:    code,
+		Message: message,
+	})
+	if err != nil {
+		log.Println("Couldn't marshal error data", err)
+		return
+	}
+
+	c.sendMsg(c, Message{
+		Type: constants.ErrorMessage,
+		Data: string(data),
+	})
+}
+
+func (c *Client) writePump(conn *websocket.Conn, outputBuf chan interface{}) {
 	ticker := time.NewTicker(pingPeriod)
 	defer func() {
 		ticker.Stop()
 	}()
 	for {
 		select {
-		case msg, ok := <-c.outputBuf:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+		case msg, ok := <-outputBuf:
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				// The hub closed the channel.
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
 
-			if err := c.conn.WriteJSON(msg); err != nil {
+			if err := conn.WriteJSON(msg); err != nil {
 				return
 			}
 		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
 		}
@@ -200,16 +623,67 @@ func (c *Client) writePump() {
 
 type Hub struct {
 	clients map[string]*Client
+	// Players waiting for a free provider
+	queue *Queue
+	// Sessions which haven't ended yet
+	tracker *sessionTracker
+	// Earnings of owners for the sessions
+	ledger  *ledger.Ledger
+	store   storage.Storage
 	rwMutex sync.RWMutex
 }
 
-func NewHub() *Hub {
+func NewHub(store storage.Storage) *Hub {
 	return &Hub{
 		clients: make(map[string]*Client),
+		queue:   NewQueue(),
+		tracker: newSessionTracker(),
+		ledger:  ledger.NewLedger(store),
+		store:   store,
 		rwMutex: sync.RWMutex{},
 	}
 }
 
+// registerProvider saves the provider and returns its registered ID.
+// The same computer of an owner is always registered with the same ID.
+func (h *Hub) registerProvider(info *ProviderInfo) (string, error) {
+	p, err := h.store.GetProviderByHost(info.OwnerID, info.HostName)
+	if errors.Is(err, storage.ErrNotFound) {
+		p = &storage.Provider{
+			ID:       utils.RandString(12),
+			OwnerID:  info.OwnerID,
+			HostName: info.HostName,
+		}
+	} else if err != nil {
+		return "", err
+	}
+
+	p.Platform = info.Platform
+	p.CpuName = info.CpuName
+	p.CpuNum = info.CpuNum
+	p.MemSize = info.MemSize
+	p.LastSeen = time.Now()
+	if err := h.store.SaveProvider(p); err != nil {
+		return "", err
+	}
+
+	return p.ID, nil
+}
+
+// touchProvider records that the provider has just been seen
+func (h *Hub) touchProvider(registeredID string) {
+	p, err := h.store.GetProvider(registeredID)
+	if err != nil {
+		log.Println("Couldn't get provider", registeredID, err)
+		return
+	}
+
+	p.LastSeen = time.Now()
+	if err := h.store.SaveProvider(p); err != nil {
+		log.Println("Couldn't save provider", registeredID, err)
+	}
+}
+
 func (h *Hub) AddClient(c *Client) {
 	h.rwMutex.Lock()
 	defer h.rwMutex.Unlock()
@@ -217,11 +691,60 @@ func (h *Hub) AddClient(c *Client) {
 	h.clients[c.ID] = c
 }
 
+// ConnectClient binds the connection to a new client. A provider connecting with an identity gets its ID,
+// and is bound again to the same client when it reconnects with the secret of the identity.
+func (h *Hub) ConnectClient(claims *auth.Claims, conn *websocket.Conn, identity *ProviderIdentity) (*Client, error) {
+	h.rwMutex.Lock()
+	defer h.rwMutex.Unlock()
+
+	if identity == nil {
+		c := NewClient(utils.RandString(6), claims, conn, h)
+		h.clients[c.ID] = c
+		return c, nil
+	}
+	if claims.Role != auth.OwnerRole || !validProviderID.MatchString(identity.ID) || identity.Secret == "" {
+		return nil, ErrInvalidIdentity
+	}
+
+	c, ok := h.clients[identity.ID]
+	if !ok {
+		c = newClient(identity.ID, claims, h)
+		c.secret = identity.Secret
+		c.attach(conn)
+		h.clients[c.ID] = c
+		return c, nil
+	}
+	if c.secret == "" || subtle.ConstantTimeCompare([]byte(c.secret), []byte(identity.Secret)) != 1 ||
+		c.AccountID != claims.Subject {
+		return nil, ErrInvalidIdentity
+	}
+
+	log.Printf("Provider %s reconnected", c.ID)
+	c.attach(conn)
+
+	return c, nil
+}
+
+// removeDetached removes the provider and ends its sessions unless it has reconnected in the meantime
+func (h *Hub) removeDetached(c *Client) {
+	h.rwMutex.Lock()
+	if h.clients[c.ID] != c || !c.isDetached() {
+		h.rwMutex.Unlock()
+		return
+	}
+	delete(h.clients, c.ID)
+	h.rwMutex.Unlock()
+
+	log.Printf("Provider %s didn't reconnect in time", c.ID)
+	c.leave()
+}
+
 func (h *Hub) RemoveClient(c *Client) {
 	h.rwMutex.Lock()
 	defer h.rwMutex.Unlock()
 
-	if _, ok := h.clients[c.ID]; ok {
+	// Another client may have taken the ID of a provider which didn't reconnect in time
+	if cur, ok := h.clients[c.ID]; ok && cur == c {
 		delete(h.clients, c.ID)
 	}
 }
@@ -244,7 +767,20 @@ func (h *Hub) GetProviders() []*Client {
 	var providers []*Client
 
 	for _, c := range h.clients {
-		if c.role == constants.Provider {
+		if c.role == constants.Provider && !c.isDetached() {
+			providers = append(providers, c)
+		}
+	}
+
+	return providers
+}
+
+// GetAvailableProviders returns providers which have free slots for new sessions
+func (h *Hub) GetAvailableProviders() []*Client {
+	var providers []*Client
+
+	for _, c := range h.GetProviders() {
+		if c.Provider.FreeSlots() > 0 {
 			providers = append(providers, c)
 		}
 	}
@@ -0,0 +1,212 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"coordinator/app/auth"
+	"coordinator/app/storage"
+	"coordinator/constants"
+	"coordinator/settings"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTestServer serves WS connections of the owner "owner" to the hub, the way the /ws endpoint does
+func newTestServer(t *testing.T, hub *Hub) string {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		var identity *ProviderIdentity
+		if id := r.Header.Get("X-Provider-ID"); id != "" {
+			identity = &ProviderIdentity{ID: id, Secret: r.Header.Get("X-Provider-Secret")}
+		}
+		if _, err := hub.ConnectClient(&auth.Claims{Subject: "owner", Role: auth.OwnerRole}, conn, identity); err != nil {
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()))
+			conn.Close()
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// joinAsProvider connects with the identity, joins with the running sessions and waits until the join is accepted
+func joinAsProvider(t *testing.T, url string, identity *ProviderIdentity, sessions string) *websocket.Conn {
+	header := http.Header{}
+	header.Set("X-Provider-ID", identity.ID)
+	header.Set("X-Provider-Secret", identity.Secret)
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	err = conn.WriteJSON(Message{
+		Type: constants.JoinMessage,
+		Data: `{"role":"provider","hostName":"pc","maxSessions":1,"sessions":` + sessions + `}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var msg Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type == constants.JoinAcceptedMessage {
+			return conn
+		}
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSessionsFailWhenProviderDoesNotReconnect(t *testing.T) {
+	window := settings.ProviderReconnectWindow
+	settings.ProviderReconnectWindow = 50 * time.Millisecond
+	defer func() { settings.ProviderReconnectWindow = window }()
+
+	hub := newTestHub(t)
+	url := newTestServer(t, hub)
+	identity := &ProviderIdentity{ID: "provider0123456789", Secret: "secret"}
+
+	conn := joinAsProvider(t, url, identity, `[]`)
+	provider := hub.GetClient(identity.ID)
+	player := &Client{ID: "player", AccountID: "alice", role: constants.Player, hub: hub}
+	hub.beginSession(player, provider, &PlayData{AppID: "tarzan", Device: "pc"})
+
+	conn.Close()
+	waitFor(t, "session to fail", func() bool {
+		return onlySession(t, hub).State == storage.SessionFailed
+	})
+	if hub.GetClient(identity.ID) != nil {
+		t.Fatal("expected provider to be removed")
+	}
+}
+
+func TestProviderKeepsSessionsWhenItReconnects(t *testing.T) {
+	hub := newTestHub(t)
+	url := newTestServer(t, hub)
+	identity := &ProviderIdentity{ID: "provider0123456789", Secret: "secret"}
+
+	conn := joinAsProvider(t, url, identity, `[]`)
+	provider := hub.GetClient(identity.ID)
+	if provider == nil || provider.role != constants.Provider {
+		t.Fatalf("expected provider to join with its ID, got %+v", provider)
+	}
+	player := &Client{ID: "player", AccountID: "alice", role: constants.Player, hub: hub}
+	ticket := hub.beginSession(player, provider, &PlayData{AppID: "tarzan", Device: "pc"})
+
+	conn.Close()
+	waitFor(t, "provider to be detached", provider.isDetached)
+	if len(hub.GetProviders()) != 0 {
+		t.Fatal("detached provider can still be matched")
+	}
+
+	// Another provider can't take over the identity
+	forged, _, err := websocket.DefaultDialer.Dial(url, http.Header{
+		"X-Provider-Id":     {identity.ID},
+		"X-Provider-Secret": {"forged"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := forged.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Fatalf("expected forged identity to be rejected, got %v", err)
+	}
+	forged.Close()
+
+	joinAsProvider(t, url, identity, `[{"playerID":"player","sessionID":"`+ticket.SessionID+`"}]`)
+	if hub.GetClient(identity.ID) != provider || provider.isDetached() {
+		t.Fatal("expected provider to be bound again to its client")
+	}
+	if s := onlySession(t, hub); s.State != storage.SessionRequested {
+		t.Fatalf("expected session to keep running, got %s", s.State)
+	}
+
+	// Session which ended while the provider was disconnected
+	joinAsProvider(t, url, identity, `[]`)
+	if s := onlySession(t, hub); s.State != storage.SessionFailed {
+		t.Fatalf("expected failed session, got %s", s.State)
+	}
+}
+
+func TestProviderKeepsItsInfoWhenItRejoins(t *testing.T) {
+	hub := newTestHub(t)
+	url := newTestServer(t, hub)
+	identity := &ProviderIdentity{ID: "provider9876543210", Secret: "secret"}
+
+	conn := joinAsProvider(t, url, identity, `[]`)
+	provider := hub.GetClient(identity.ID)
+	info := provider.Provider
+	// Slot is reserved for the session until the provider reports it
+	if !info.Reserve() {
+		t.Fatal("expected a free slot")
+	}
+	player := &Client{ID: "player", AccountID: "alice", role: constants.Player, hub: hub}
+	ticket := hub.beginSession(player, provider, &PlayData{AppID: "tarzan", Device: "pc"})
+
+	// Matchmaking keeps reading the info while the provider reconnects
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				for _, p := range hub.GetAvailableProviders() {
+					p.Provider.Snapshot()
+				}
+				hub.PickProvider("tarzan_pc", nil)
+			}
+		}
+	}()
+
+	conn.Close()
+	waitFor(t, "provider to be detached", provider.isDetached)
+	joinAsProvider(t, url, identity, `[{"playerID":"player","sessionID":"`+ticket.SessionID+`"}]`)
+
+	if provider.Provider != info {
+		t.Fatal("expected info of the provider to be updated in place")
+	}
+	if free := info.FreeSlots(); free != 0 {
+		t.Fatalf("expected the reserved slot to be kept, got %d free slots", free)
+	}
+	if s := onlySession(t, hub); s.State != storage.SessionRequested || s.ProviderID != info.Snapshot().RegisteredID {
+		t.Fatalf("expected session to keep running on the provider, got %+v", s)
+	}
+}
+
+func TestProviderSnapshotKeepsInfoOfTheMoment(t *testing.T) {
+	info := &ProviderInfo{OwnerID: "owner"}
+	info.join(&JoinData{HostName: "host", CpuPercent: 10, MaxSessions: 2, Codecs: []string{"vp8"}})
+	info.register("provider1")
+
+	snapshot := info.Snapshot()
+	info.updateStats(&StatsData{CpuPercent: 90, ActiveSessions: 2})
+
+	if snapshot.RegisteredID != "provider1" || snapshot.OwnerID != "owner" || snapshot.HostName != "host" ||
+		snapshot.MaxSessions != 2 || len(snapshot.Codecs) != 1 {
+		t.Fatalf("expected the info of the provider, got %+v", snapshot)
+	}
+	if snapshot.CpuPercent != 10 || snapshot.FreeSlots() != 2 {
+		t.Fatalf("expected stats before the update, got %+v", snapshot)
+	}
+}
@@ -0,0 +1,133 @@
+package client
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"coordinator/app/ledger"
+	"coordinator/constants"
+	"coordinator/settings"
+)
+
+type CreditData struct {
+	// Remaining play minutes
+	Minutes int `json:"minutes"`
+}
+
+// hasCredit tells whether the player has play time left, the player is told otherwise
+func (c *Client) hasCredit() bool {
+	balance, err := c.hub.store.GetCredit(c.AccountID)
+	if err != nil {
+		log.Println("Couldn't get credit of", c.AccountID, err)
+		c.sendError(constants.CoordinatorStage, constants.NoCreditError, "Couldn't check your play time, please try again")
+		return false
+	}
+	if balance <= 0 {
+		c.sendError(constants.CoordinatorStage, constants.NoCreditError, "You have no play time left")
+		return false
+	}
+
+	return true
+}
+
+// MeterCredits debits play time of streaming sessions every interval until the hub is no longer used
+func (h *Hub) MeterCredits(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		h.meterCredits()
+	}
+}
+
+func (h *Hub) meterCredits() {
+	h.tracker.mu.Lock()
+	sessions := make([]*activeSession, 0, len(h.tracker.sessions))
+	for _, s := range h.tracker.sessions {
+		sessions = append(sessions, s)
+	}
+	h.tracker.mu.Unlock()
+
+	for _, s := range sessions {
+		balance, ok := h.debitSession(s)
+		if !ok {
+			continue
+		}
+
+		if balance == 0 {
+			h.stopForCredit(s)
+		} else if balance <= settings.CreditWarning {
+			h.warnCredit(s, balance)
+		}
+	}
+}
+
+// debitSession debits play minutes of the session which haven't been debited yet and returns the remaining balance.
+// It returns false if the session isn't streaming or the balance couldn't be updated.
+func (h *Hub) debitSession(s *activeSession) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return h.debitLocked(s)
+}
+
+func (h *Hub) debitLocked(s *activeSession) (int, bool) {
+	if s.record.StartedAt.IsZero() {
+		return 0, false
+	}
+
+	due := ledger.BillableMinutes(s.record.Duration()) - s.debited
+	balance, err := h.store.AdjustCredit(s.record.PlayerID, -due)
+	if err != nil {
+		log.Println("Couldn't debit credit of", s.record.PlayerID, err)
+		return 0, false
+	}
+	s.debited += due
+
+	return balance, true
+}
+
+// warnCredit tells the player once that the play time is about to run out
+func (h *Hub) warnCredit(s *activeSession, balance int) {
+	s.mu.Lock()
+	warned := s.warned
+	s.warned = true
+	s.mu.Unlock()
+	if warned {
+		return
+	}
+
+	data, err := json.Marshal(CreditData{Minutes: balance})
+	if err != nil {
+		log.Println("Couldn't marshal credit data", err)
+		return
+	}
+
+	player := s.currentPlayer()
+	player.sendMsg(player, Message{
+		Type: constants.CreditMessage,
+		Data: string(data),
+	})
+}
+
+// stopForCredit asks the provider to end the session of a player who ran out of play time
+func (h *Hub) stopForCredit(s *activeSession) {
+	s.mu.Lock()
+	stopping := s.stopping
+	s.stopping = true
+	s.mu.Unlock()
+	if stopping {
+		return
+	}
+
+	player := s.currentPlayer()
+	log.Printf("Player %s ran out of play time, ending session %s", player.ID, s.record.ID)
+
+	player.sendError(constants.CoordinatorStage, constants.NoCreditError, "You have no play time left")
+	s.provider.sendMsg(s.provider, Message{
+		SenderID:   player.ID,
+		ReceiverID: s.provider.ID,
+		Type:       constants.EndMessage,
+	})
+}
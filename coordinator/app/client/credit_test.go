@@ -0,0 +1,62 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"coordinator/app/storage"
+	"coordinator/constants"
+)
+
+func nextMsg(t *testing.T, c *Client) Message {
+	select {
+	case msg := <-c.outputBuf:
+		return msg.(Message)
+	default:
+		t.Fatalf("no message was sent to %s", c.ID)
+	}
+
+	return Message{}
+}
+
+func TestMeterCreditsWarnsThenEndsSession(t *testing.T) {
+	hub := newTestHub(t)
+	provider := newTestProvider(hub, "provider", &ProviderInfo{RegisteredID: "pc", MaxSessions: 1})
+	provider.outputBuf = make(chan interface{}, 10)
+	player := &Client{ID: "player", AccountID: "alice", role: constants.Player, hub: hub, outputBuf: make(chan interface{}, 10)}
+	if _, err := hub.store.AdjustCredit("alice", 3); err != nil {
+		t.Fatal(err)
+	}
+
+	hub.beginSession(player, provider, &PlayData{AppID: "tarzan", Device: "pc"})
+	hub.advanceSession("player", provider, storage.SessionStreaming)
+	hub.tracker.sessions["player"].record.StartedAt = time.Now().Add(-90 * time.Second)
+
+	hub.meterCredits()
+	if balance, _ := hub.store.GetCredit("alice"); balance != 1 {
+		t.Fatalf("expected 1 minute left, got %d", balance)
+	}
+	if msg := nextMsg(t, player); msg.Type != constants.CreditMessage || msg.Data != `{"minutes":1}` {
+		t.Fatalf("expected credit warning, got %+v", msg)
+	}
+
+	hub.tracker.sessions["player"].record.StartedAt = time.Now().Add(-150 * time.Second)
+	hub.meterCredits()
+	hub.meterCredits()
+	if msg := nextMsg(t, player); msg.Type != constants.ErrorMessage {
+		t.Fatalf("expected no-credit error, got %+v", msg)
+	}
+	if msg := nextMsg(t, provider); msg.Type != constants.EndMessage || msg.SenderID != "player" {
+		t.Fatalf("expected end message, got %+v", msg)
+	}
+	// Provider is asked only once
+	if len(provider.outputBuf) != 0 || len(player.outputBuf) != 0 {
+		t.Fatal("unexpected messages after the session was stopped")
+	}
+
+	// Player isn't debited twice when the session ends
+	hub.endSession("player", provider, storage.SessionEnded)
+	if balance, _ := hub.store.GetCredit("alice"); balance != 0 {
+		t.Fatalf("expected no minute left, got %d", balance)
+	}
+}
@@ -0,0 +1,150 @@
+package client
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+
+	"coordinator/constants"
+	"coordinator/utils"
+)
+
+// load tells how busy the provider is, from 0 to 100
+func (p *ProviderInfo) load() float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	load := 100 * float64(p.activeSessions) / float64(p.MaxSessions)
+	if p.CpuPercent > load {
+		load = p.CpuPercent
+	}
+	if p.MemPercent > load {
+		load = p.MemPercent
+	}
+
+	return load
+}
+
+func (p *ProviderInfo) canRun(appName string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return utils.InStringSlice(p.Apps, appName)
+}
+
+// Codec players decode when they don't tell which codecs they support
+const defaultCodec = "vp8"
+
+// canStream tells whether the provider can stream with one of the codecs the player decodes
+func (p *ProviderInfo) canStream(codecs []string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.Codecs) == 0 {
+		return true
+	}
+	if len(codecs) == 0 {
+		codecs = []string{defaultCodec}
+	}
+
+	for _, c := range codecs {
+		// Players list MIME types such as video/VP8
+		if utils.InStringSlice(p.Codecs, strings.TrimPrefix(strings.ToLower(c), "video/")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PickProvider selects the least loaded provider which can run the app with one of the codecs and reserves a slot on it.
+// It returns nil if no provider is available.
+func (h *Hub) PickProvider(appName string, codecs []string) *Client {
+	var candidates []*Client
+	for _, c := range h.GetAvailableProviders() {
+		if c.Provider.canRun(appName) && c.Provider.canStream(codecs) {
+			candidates = append(candidates, c)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Provider.load() < candidates[j].Provider.load()
+	})
+
+	// Another player may take the last slot of a provider in the meantime
+	for _, c := range candidates {
+		if c.Provider.Reserve() {
+			return c
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) handlePlayMsg(msg *Message) error {
+	playData, err := parsePlayData(msg.Data)
+	if err != nil {
+		c.sendError(constants.CoordinatorStage, constants.InvalidMessageError, "Invalid play message")
+		return err
+	}
+	if !c.hasCredit() {
+		return nil
+	}
+
+	provider := c.hub.PickProvider(playData.appName(), playData.Codecs)
+	if provider == nil {
+		// Player will be dispatched as soon as a provider is available
+		c.hub.enqueue(c, playData, DefaultPriority)
+		return nil
+	}
+
+	log.Printf("Matched player %s with provider %s to play %s", c.ID, provider.ID, playData.appName())
+
+	return c.startSession(provider, playData)
+}
+
+// startSession tells the player which provider was chosen and asks the provider to start the session on behalf of the player
+func (c *Client) startSession(provider *Client, playData *PlayData) error {
+	matchedData, err := json.Marshal(MatchedData{ProviderID: provider.ID})
+	if err != nil {
+		return err
+	}
+	ticket := c.hub.beginSession(c, provider, playData)
+	playData.AccountID = c.AccountID
+	playData.SessionID = ticket.SessionID
+	startData, err := json.Marshal(playData)
+	if err != nil {
+		return err
+	}
+
+	// Player must know the provider before receiving its offer
+	c.sendMsg(c, Message{
+		SenderID: provider.ID,
+		Type:     constants.MatchedMessage,
+		Data:     string(matchedData),
+	})
+	c.sendResumeTicket(ticket)
+	c.sendMsg(provider, Message{
+		SenderID:   c.ID,
+		ReceiverID: provider.ID,
+		Type:       constants.StartMessage,
+		Data:       string(startData),
+	})
+
+	return nil
+}
+
+// sendResumeTicket lets the player resume the session if the connection to the provider drops
+func (c *Client) sendResumeTicket(ticket ResumeTicket) {
+	data, err := json.Marshal(ticket)
+	if err != nil {
+		log.Println("Couldn't marshal resume ticket", err)
+		return
+	}
+
+	c.sendMsg(c, Message{
+		Type: constants.SessionMessage,
+		Data: string(data),
+	})
+}
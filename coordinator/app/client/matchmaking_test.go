@@ -0,0 +1,64 @@
+package client
+
+import (
+	"testing"
+
+	"coordinator/constants"
+)
+
+func newTestProvider(hub *Hub, id string, info *ProviderInfo) *Client {
+	c := &Client{
+		ID:       id,
+		role:     constants.Provider,
+		hub:      hub,
+		Provider: info,
+	}
+	hub.AddClient(c)
+
+	return c
+}
+
+func TestPickProviderChoosesLeastLoaded(t *testing.T) {
+	hub := NewHub(nil)
+	newTestProvider(hub, "busy", &ProviderInfo{MaxSessions: 2, Apps: []string{"tarzan_pc"}, CpuPercent: 80})
+	newTestProvider(hub, "idle", &ProviderInfo{MaxSessions: 2, Apps: []string{"tarzan_pc"}, CpuPercent: 10, MemPercent: 20})
+	newTestProvider(hub, "other-app", &ProviderInfo{MaxSessions: 2, Apps: []string{"hercules_pc"}})
+
+	p := hub.PickProvider("tarzan_pc", nil)
+	if p == nil || p.ID != "idle" {
+		t.Fatalf("expected provider idle, got %v", p)
+	}
+	if free := p.Provider.FreeSlots(); free != 1 {
+		t.Errorf("expected 1 free slot after reservation, got %d", free)
+	}
+}
+
+func TestPickProviderSkipsFullProviders(t *testing.T) {
+	hub := NewHub(nil)
+	newTestProvider(hub, "full", &ProviderInfo{MaxSessions: 1, Apps: []string{"tarzan_pc"}, activeSessions: 1})
+	single := newTestProvider(hub, "single", &ProviderInfo{MaxSessions: 1, Apps: []string{"tarzan_pc"}, CpuPercent: 90})
+
+	if p := hub.PickProvider("tarzan_pc", nil); p != single {
+		t.Fatalf("expected provider single, got %v", p)
+	}
+	if p := hub.PickProvider("tarzan_pc", nil); p != nil {
+		t.Fatalf("expected no provider, got %s", p.ID)
+	}
+	if p := hub.PickProvider("hercules_pc", nil); p != nil {
+		t.Fatalf("expected no provider, got %s", p.ID)
+	}
+}
+
+func TestPickProviderMatchesCodecs(t *testing.T) {
+	hub := NewHub(nil)
+	newTestProvider(hub, "vp9", &ProviderInfo{MaxSessions: 1, Apps: []string{"tarzan_pc"}, Codecs: []string{"vp9"}})
+	legacy := newTestProvider(hub, "legacy", &ProviderInfo{MaxSessions: 1, Apps: []string{"tarzan_pc"}, CpuPercent: 50})
+
+	if p := hub.PickProvider("tarzan_pc", []string{"video/VP9"}); p == nil || p.ID != "vp9" {
+		t.Fatalf("expected provider vp9, got %v", p)
+	}
+	// Providers which don't advertise codecs are assumed to stream any
+	if p := hub.PickProvider("tarzan_pc", []string{"video/H264"}); p != legacy {
+		t.Fatalf("expected provider legacy, got %v", p)
+	}
+}
@@ -2,6 +2,8 @@ package client
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 
 	"coordinator/constants"
 )
@@ -25,7 +27,6 @@ func parseMsg(raw []byte) (*Message, error) {
 
 type JoinData struct {
 	Role       constants.Role `json:"role"`
-	OwnerID    string         `json:"ownerID"`
 	HostName   string         `json:"hostName"`
 	Platform   string         `json:"platform"`
 	CpuName    string         `json:"cpuName"`
@@ -33,6 +34,21 @@ type JoinData struct {
 	MemSize    float64        `json:"memSize"`
 	CpuPercent float64        `json:"cpuPercent"`
 	MemPercent float64        `json:"memPercent"`
+	// Session capacity of provider
+	MaxSessions    int `json:"maxSessions"`
+	ActiveSessions int `json:"activeSessions"`
+	// Apps provider can run
+	Apps []string `json:"apps"`
+	// Video codecs provider can stream, in its order of preference
+	Codecs []string `json:"codecs"`
+	// Sessions still running when provider joins again after losing its connection
+	Sessions []RunningSessionData `json:"sessions"`
+}
+
+type RunningSessionData struct {
+	// Client ID of the player the session streams to
+	PlayerID  string `json:"playerID"`
+	SessionID string `json:"sessionID"`
 }
 
 func parseJoinData(raw string) (*JoinData, error) {
@@ -46,8 +62,9 @@ func parseJoinData(raw string) (*JoinData, error) {
 }
 
 type StatsData struct {
-	CpuPercent float64 `json:"cpuPercent"`
-	MemPercent float64 `json:"memPercent"`
+	CpuPercent     float64 `json:"cpuPercent"`
+	MemPercent     float64 `json:"memPercent"`
+	ActiveSessions int     `json:"activeSessions"`
 }
 
 func parseStatsData(raw string) (*StatsData, error) {
@@ -59,3 +76,183 @@ func parseStatsData(raw string) (*StatsData, error) {
 
 	return &stats, nil
 }
+
+type ErrorData struct {
+	Stage   constants.ErrorStage `json:"stage"`
+	Code    constants.ErrorCode  `json:"code"`
+	Message string               `json:"message"`
+}
+
+func parseErrorData(raw string) (*ErrorData, error) {
+	var e ErrorData
+
+	if err := json.Unmarshal([]byte(raw), &e); err != nil {
+		return nil, err
+	}
+
+	return &e, nil
+}
+
+type PlayData struct {
+	AppID  string `json:"appID"`
+	Device string `json:"device"`
+	// Video codecs the browser of the player can decode, as MIME types
+	Codecs []string `json:"codecs,omitempty"`
+	// Set by the coordinator for the provider, which keeps saves of the player per account
+	AccountID string `json:"accountID,omitempty"`
+	SessionID string `json:"sessionID,omitempty"`
+}
+
+func parsePlayData(raw string) (*PlayData, error) {
+	var play PlayData
+
+	if err := json.Unmarshal([]byte(raw), &play); err != nil {
+		return nil, err
+	}
+	if play.AppID == "" || play.Device == "" {
+		return nil, errors.New("missing app ID or device")
+	}
+
+	return &play, nil
+}
+
+// appName is the name of the app on providers
+func (p *PlayData) appName() string {
+	return fmt.Sprintf("%s_%s", p.AppID, p.Device)
+}
+
+// ResumeTicket lets the player resume the session from another connection, e.g. after reloading the page
+type ResumeTicket struct {
+	SessionID string `json:"sessionID"`
+	Token     string `json:"token"`
+}
+
+func parseResumeTicket(raw string) (*ResumeTicket, error) {
+	var ticket ResumeTicket
+
+	if err := json.Unmarshal([]byte(raw), &ticket); err != nil {
+		return nil, err
+	}
+	if ticket.SessionID == "" || ticket.Token == "" {
+		return nil, errors.New("missing session ID or token")
+	}
+
+	return &ticket, nil
+}
+
+// ResumeData tells the provider which client of the player the session was running for
+type ResumeData struct {
+	PreviousID string `json:"previousID"`
+}
+
+// InviteData is the code a player gives to friends so they can watch the session, or play it in another seat
+type InviteData struct {
+	Code string `json:"code"`
+	Seat bool   `json:"seat,omitempty"`
+}
+
+func parseInviteData(raw string) (*InviteData, error) {
+	var invite InviteData
+
+	if err := json.Unmarshal([]byte(raw), &invite); err != nil {
+		return nil, err
+	}
+	if invite.Code == "" {
+		return nil, errors.New("missing invite code")
+	}
+
+	return &invite, nil
+}
+
+// SpectateData tells the provider whose session the spectator watches
+type SpectateData struct {
+	// Client ID the player streams to
+	HostID string `json:"hostID"`
+}
+
+// SeatData tells the provider which seat of the session the guest plays
+type SeatData struct {
+	// Client ID the player streams to
+	HostID string `json:"hostID"`
+	Seat   int    `json:"seat"`
+}
+
+// GuestSeat is the seat of a guest, which the player may change
+type GuestSeat struct {
+	GuestID string `json:"guestID"`
+	Seat    int    `json:"seat"`
+}
+
+func parseGuestSeat(raw string) (*GuestSeat, error) {
+	var seat GuestSeat
+
+	if err := json.Unmarshal([]byte(raw), &seat); err != nil {
+		return nil, err
+	}
+	if seat.GuestID == "" {
+		return nil, errors.New("missing guest")
+	}
+
+	return &seat, nil
+}
+
+// SeatsData tells the player who plays in the other seats of the session
+type SeatsData struct {
+	Seats []GuestSeat `json:"seats"`
+}
+
+type MatchedData struct {
+	ProviderID string `json:"providerID"`
+}
+
+// SessionStatsData is the stream quality of a session reported by its provider
+type SessionStatsData struct {
+	// Round trip time in seconds
+	RTT         float64 `json:"rtt"`
+	PacketsSent uint64  `json:"packetsSent"`
+	BytesSent   uint64  `json:"bytesSent"`
+	PacketLoss  float64 `json:"packetLoss"`
+	PacketsLost int32   `json:"packetsLost"`
+	// Jitter in seconds
+	Jitter     float64        `json:"jitter"`
+	FramesSent uint64         `json:"framesSent"`
+	FrameRate  float64        `json:"frameRate"`
+	Relay      RelayStatsData `json:"relay"`
+	Latency    LatencyData    `json:"latency"`
+}
+
+type RelayStatsData struct {
+	VideoPacketsIn uint64 `json:"videoPacketsIn"`
+	VideoDropped   uint64 `json:"videoDropped"`
+	VideoBacklog   int    `json:"videoBacklog"`
+	AudioPacketsIn uint64 `json:"audioPacketsIn"`
+	AudioDropped   uint64 `json:"audioDropped"`
+	AudioBacklog   int    `json:"audioBacklog"`
+}
+
+// LatencyData are percentiles of the input latency of a session in milliseconds
+type LatencyData struct {
+	Samples int `json:"samples"`
+	// From the receipt of an input by the provider to its simulation in the VM
+	Input LatencyPercentiles `json:"input"`
+	// From the receipt of an input to the first frame captured after its simulation
+	InputToFrame LatencyPercentiles `json:"inputToFrame"`
+	// From the player's input to the frame reaching the player
+	EndToEnd LatencyPercentiles `json:"endToEnd"`
+}
+
+type LatencyPercentiles struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+}
+
+func parseSessionStatsData(raw string) (*SessionStatsData, error) {
+	var stats SessionStatsData
+
+	if err := json.Unmarshal([]byte(raw), &stats); err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
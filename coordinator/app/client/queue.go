@@ -0,0 +1,214 @@
+package client
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+
+	"coordinator/constants"
+)
+
+// Priority of players who don't have any privilege
+const DefaultPriority = 0
+
+type queueEntry struct {
+	player   *Client
+	playData *PlayData
+	priority int
+	// Arrival order of the player
+	seq uint64
+	// Last position the player was told about
+	notifiedPos int
+}
+
+type match struct {
+	player   *Client
+	provider *Client
+	playData *PlayData
+}
+
+type positionUpdate struct {
+	player   *Client
+	position int
+	size     int
+}
+
+// Queue holds players waiting for a provider.
+// Players with higher priority are served first, players with the same priority in FIFO order.
+type Queue struct {
+	entries []*queueEntry
+	nextSeq uint64
+	mu      sync.Mutex
+}
+
+func NewQueue() *Queue {
+	return &Queue{}
+}
+
+// Push adds the player to the queue. A player who is already waiting is moved to the end of its priority.
+func (q *Queue) Push(player *Client, playData *PlayData, priority int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.remove(player)
+
+	q.nextSeq++
+	e := &queueEntry{
+		player:   player,
+		playData: playData,
+		priority: priority,
+		seq:      q.nextSeq,
+	}
+
+	i := sort.Search(len(q.entries), func(i int) bool {
+		return q.entries[i].priority < e.priority
+	})
+	q.entries = append(q.entries, nil)
+	copy(q.entries[i+1:], q.entries[i:])
+	q.entries[i] = e
+}
+
+func (q *Queue) Remove(player *Client) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.remove(player)
+}
+
+func (q *Queue) remove(player *Client) bool {
+	for i, e := range q.entries {
+		if e.player == player {
+			q.entries = append(q.entries[:i], q.entries[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Position returns the 1-based position of the player, or 0 if the player isn't waiting
+func (q *Queue) Position(player *Client) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, e := range q.entries {
+		if e.player == player {
+			return i + 1
+		}
+	}
+
+	return 0
+}
+
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.entries)
+}
+
+// dispatch removes players for whom pick finds a provider, in queue order.
+// Players waiting for an app no provider can run don't block the players behind them.
+func (q *Queue) dispatch(pick func(playData *PlayData) *Client) []match {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var matches []match
+	remaining := q.entries[:0]
+	for _, e := range q.entries {
+		if provider := pick(e.playData); provider != nil {
+			matches = append(matches, match{player: e.player, provider: provider, playData: e.playData})
+			continue
+		}
+		remaining = append(remaining, e)
+	}
+	for i := len(remaining); i < len(q.entries); i++ {
+		q.entries[i] = nil
+	}
+	q.entries = remaining
+
+	return matches
+}
+
+// positionUpdates returns players whose position changed since the last call
+func (q *Queue) positionUpdates() []positionUpdate {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var updates []positionUpdate
+	for i, e := range q.entries {
+		if e.notifiedPos != i+1 {
+			e.notifiedPos = i + 1
+			updates = append(updates, positionUpdate{player: e.player, position: i + 1, size: len(q.entries)})
+		}
+	}
+
+	return updates
+}
+
+type QueueData struct {
+	Position int `json:"position"`
+	Size     int `json:"size"`
+}
+
+// enqueue makes the player wait for a provider
+func (h *Hub) enqueue(player *Client, playData *PlayData, priority int) {
+	h.queue.Push(player, playData, priority)
+	log.Printf("Player %s is waiting to play %s, %d players in queue", player.ID, playData.appName(), h.queue.Len())
+
+	h.notifyQueue()
+}
+
+func (h *Hub) leaveQueue(player *Client) {
+	if h.queue.Remove(player) {
+		h.notifyQueue()
+	}
+}
+
+// DispatchQueue starts sessions for waiting players as long as providers have free slots.
+// It must be called whenever a provider joins or frees a slot.
+func (h *Hub) DispatchQueue() {
+	matches := h.queue.dispatch(func(playData *PlayData) *Client {
+		return h.PickProvider(playData.appName(), playData.Codecs)
+	})
+
+	released := false
+	for _, m := range matches {
+		// Player may have run out of play time while waiting
+		if !m.player.hasCredit() {
+			log.Printf("Waiting player %s has no credit left, leaving the queue", m.player.ID)
+			m.provider.Provider.Release()
+			released = true
+			continue
+		}
+		log.Printf("Matched waiting player %s with provider %s to play %s", m.player.ID, m.provider.ID, m.playData.appName())
+		if err := m.player.startSession(m.provider, m.playData); err != nil {
+			log.Println("Couldn't start session of waiting player", err)
+		}
+	}
+
+	if len(matches) > 0 {
+		h.notifyQueue()
+	}
+	// Slots of players who left are offered to the players behind them
+	if released {
+		h.DispatchQueue()
+	}
+}
+
+// notifyQueue tells waiting players their new position
+func (h *Hub) notifyQueue() {
+	for _, u := range h.queue.positionUpdates() {
+		data, err := json.Marshal(QueueData{Position: u.position, Size: u.size})
+		if err != nil {
+			log.Println("Couldn't marshal queue data", err)
+			continue
+		}
+
+		u.player.sendMsg(u.player, Message{
+			Type: constants.QueueMessage,
+			Data: string(data),
+		})
+	}
+}
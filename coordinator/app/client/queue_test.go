@@ -0,0 +1,118 @@
+package client
+
+import (
+	"testing"
+
+	"coordinator/constants"
+)
+
+func TestQueueOrdersByPriorityThenArrival(t *testing.T) {
+	q := NewQueue()
+	a, b, c, d := &Client{ID: "a"}, &Client{ID: "b"}, &Client{ID: "c"}, &Client{ID: "d"}
+
+	q.Push(a, &PlayData{AppID: "tarzan", Device: "pc"}, DefaultPriority)
+	q.Push(b, &PlayData{AppID: "tarzan", Device: "pc"}, DefaultPriority)
+	q.Push(c, &PlayData{AppID: "tarzan", Device: "pc"}, DefaultPriority+1)
+	q.Push(d, &PlayData{AppID: "tarzan", Device: "pc"}, DefaultPriority)
+
+	for want, player := range []*Client{c, a, b, d} {
+		if pos := q.Position(player); pos != want+1 {
+			t.Errorf("expected %s at position %d, got %d", player.ID, want+1, pos)
+		}
+	}
+
+	q.Remove(a)
+	if pos := q.Position(a); pos != 0 {
+		t.Errorf("expected removed player to have no position, got %d", pos)
+	}
+	if pos := q.Position(d); pos != 3 {
+		t.Errorf("expected d at position 3, got %d", pos)
+	}
+}
+
+func TestQueueDispatchSkipsPlayersWithoutProvider(t *testing.T) {
+	q := NewQueue()
+	a, b, c := &Client{ID: "a"}, &Client{ID: "b"}, &Client{ID: "c"}
+	provider := &Client{ID: "provider"}
+
+	q.Push(a, &PlayData{AppID: "hercules", Device: "pc"}, DefaultPriority)
+	q.Push(b, &PlayData{AppID: "tarzan", Device: "pc"}, DefaultPriority)
+	q.Push(c, &PlayData{AppID: "tarzan", Device: "pc"}, DefaultPriority)
+
+	// Only one slot for tarzan is available
+	slots := 1
+	matches := q.dispatch(func(playData *PlayData) *Client {
+		if playData.appName() != "tarzan_pc" || slots == 0 {
+			return nil
+		}
+		slots--
+		return provider
+	})
+
+	if len(matches) != 1 || matches[0].player != b || matches[0].provider != provider {
+		t.Fatalf("expected b to be matched, got %v", matches)
+	}
+	if q.Len() != 2 || q.Position(a) != 1 || q.Position(c) != 2 {
+		t.Errorf("expected a and c to keep waiting in order, got a=%d c=%d", q.Position(a), q.Position(c))
+	}
+}
+
+func TestQueuePositionUpdatesOnlyChangedPositions(t *testing.T) {
+	q := NewQueue()
+	a, b := &Client{ID: "a"}, &Client{ID: "b"}
+
+	q.Push(a, &PlayData{AppID: "tarzan", Device: "pc"}, DefaultPriority)
+	q.Push(b, &PlayData{AppID: "tarzan", Device: "pc"}, DefaultPriority)
+	if updates := q.positionUpdates(); len(updates) != 2 {
+		t.Fatalf("expected 2 updates, got %d", len(updates))
+	}
+	if updates := q.positionUpdates(); len(updates) != 0 {
+		t.Fatalf("expected no updates, got %d", len(updates))
+	}
+
+	q.Remove(a)
+	updates := q.positionUpdates()
+	if len(updates) != 1 || updates[0].player != b || updates[0].position != 1 || updates[0].size != 1 {
+		t.Fatalf("expected b to move to position 1, got %v", updates)
+	}
+}
+
+// nextNonQueueMsg skips queue position updates sent to the client
+func nextNonQueueMsg(t *testing.T, c *Client) Message {
+	for {
+		if msg := nextMsg(t, c); msg.Type != constants.QueueMessage {
+			return msg
+		}
+	}
+}
+
+func TestDispatchQueueSkipsPlayersWithoutCredit(t *testing.T) {
+	hub := newTestHub(t)
+	provider := newTestProvider(hub, "provider", &ProviderInfo{RegisteredID: "pc", MaxSessions: 1, Apps: []string{"tarzan_pc"}})
+	provider.outputBuf = make(chan interface{}, 10)
+	broke := &Client{ID: "broke", AccountID: "bob", role: constants.Player, hub: hub, outputBuf: make(chan interface{}, 10)}
+	player := &Client{ID: "player", AccountID: "alice", role: constants.Player, hub: hub, outputBuf: make(chan interface{}, 10)}
+	if _, err := hub.store.AdjustCredit("alice", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// Both players wait while the only slot is taken
+	provider.Provider.Reserve()
+	hub.enqueue(broke, &PlayData{AppID: "tarzan", Device: "pc"}, DefaultPriority)
+	hub.enqueue(player, &PlayData{AppID: "tarzan", Device: "pc"}, DefaultPriority)
+
+	provider.Provider.Release()
+	hub.DispatchQueue()
+
+	msg := nextNonQueueMsg(t, broke)
+	errData, _ := parseErrorData(msg.Data)
+	if msg.Type != constants.ErrorMessage || errData == nil || errData.Code != constants.NoCreditError {
+		t.Fatalf("expected no credit error, got %+v", msg)
+	}
+	if msg := nextNonQueueMsg(t, player); msg.Type != constants.MatchedMessage || msg.SenderID != "provider" {
+		t.Fatalf("expected player with credit to get the slot, got %+v", msg)
+	}
+	if hub.queue.Len() != 0 || provider.Provider.FreeSlots() != 0 {
+		t.Fatalf("expected empty queue and one session, got %d waiting and %d free slots", hub.queue.Len(), provider.Provider.FreeSlots())
+	}
+}
@@ -0,0 +1,308 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+
+	"coordinator/constants"
+	"coordinator/settings"
+)
+
+var (
+	errSeatsFull   = errors.New("all seats are taken")
+	errInvalidSeat = errors.New("invalid seat")
+	errNoGuest     = errors.New("guest not found")
+)
+
+// seatedGuest plays the session of another player in one of the seats after the first one, which is the player's
+type seatedGuest struct {
+	client *Client
+	seat   int
+}
+
+// addGuest seats the client in the first free seat of the session of the code, up to settings.MaxSeats players
+func (h *Hub) addGuest(guest *Client, code string) (*activeSession, int, error) {
+	h.tracker.mu.Lock()
+	defer h.tracker.mu.Unlock()
+
+	for _, s := range h.tracker.sessions {
+		s.mu.Lock()
+		if s.seatCode != code {
+			s.mu.Unlock()
+			continue
+		}
+		defer s.mu.Unlock()
+
+		if s.player == guest || s.stopping || s.provider.isDetached() {
+			return nil, 0, errNoSession
+		}
+		if g, ok := s.guests[guest.ID]; ok {
+			return s, g.seat, nil
+		}
+		for seat := 1; seat < settings.MaxSeats; seat++ {
+			if s.guestInSeat(seat) == nil {
+				s.guests[guest.ID] = &seatedGuest{client: guest, seat: seat}
+				return s, seat, nil
+			}
+		}
+
+		return nil, 0, errSeatsFull
+	}
+
+	return nil, 0, errNoSession
+}
+
+// guestInSeat returns the guest playing in the seat, it must be called with s.mu held
+func (s *activeSession) guestInSeat(seat int) *seatedGuest {
+	for _, g := range s.guests {
+		if g.seat == seat {
+			return g
+		}
+	}
+	return nil
+}
+
+// seats lists the guests of the session by seat, it must be called with s.mu held
+func (s *activeSession) seats() []GuestSeat {
+	seats := make([]GuestSeat, 0, len(s.guests))
+	for id, g := range s.guests {
+		seats = append(seats, GuestSeat{GuestID: id, Seat: g.seat})
+	}
+	sort.Slice(seats, func(i, j int) bool { return seats[i].Seat < seats[j].Seat })
+
+	return seats
+}
+
+// hostedSession returns the session the player started, where the player controls seats
+func (h *Hub) hostedSession(host *Client) (*activeSession, error) {
+	h.tracker.mu.Lock()
+	defer h.tracker.mu.Unlock()
+
+	s, ok := h.tracker.sessions[host.ID]
+	if !ok {
+		return nil, errNoSession
+	}
+
+	return s, nil
+}
+
+// moveGuest seats the guest of the player's session in another seat, swapping seats with the guest sitting there.
+// It returns the guests who changed seats.
+func (h *Hub) moveGuest(host *Client, guestID string, seat int) (*activeSession, []GuestSeat, error) {
+	s, err := h.hostedSession(host)
+	if err != nil {
+		return nil, nil, err
+	}
+	if seat < 1 || seat >= settings.MaxSeats {
+		return nil, nil, errInvalidSeat
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g, ok := s.guests[guestID]
+	if !ok {
+		return nil, nil, errNoGuest
+	}
+	if g.seat == seat {
+		return s, nil, nil
+	}
+	moved := []GuestSeat{{GuestID: guestID, Seat: seat}}
+	if other := s.guestInSeat(seat); other != nil {
+		other.seat = g.seat
+		moved = append(moved, GuestSeat{GuestID: other.client.ID, Seat: other.seat})
+	}
+	g.seat = seat
+
+	return s, moved, nil
+}
+
+// kickGuest removes the guest from the session of the player
+func (h *Hub) kickGuest(host *Client, guestID string) (*activeSession, error) {
+	s, err := h.hostedSession(host)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.guests[guestID]; !ok {
+		return nil, errNoGuest
+	}
+	delete(s.guests, guestID)
+
+	return s, nil
+}
+
+// removeGuest stops tracking the guest of the provider's sessions and returns it, nil if it wasn't playing
+func (h *Hub) removeGuest(guestID string, provider *Client) *Client {
+	for _, s := range h.providerSessions(provider) {
+		s.mu.Lock()
+		g, ok := s.guests[guestID]
+		delete(s.guests, guestID)
+		s.mu.Unlock()
+		if ok {
+			h.sendSeats(s)
+			return g.client
+		}
+	}
+
+	return nil
+}
+
+// leaveSeat stops tracking the client as a guest and tells providers and players of the sessions it was playing
+func (h *Hub) leaveSeat(guest *Client) {
+	h.tracker.mu.Lock()
+	var played []*activeSession
+	for _, s := range h.tracker.sessions {
+		s.mu.Lock()
+		if _, ok := s.guests[guest.ID]; ok {
+			delete(s.guests, guest.ID)
+			played = append(played, s)
+		}
+		s.mu.Unlock()
+	}
+	h.tracker.mu.Unlock()
+
+	for _, s := range played {
+		s.provider.sendMsg(s.provider, Message{
+			SenderID:   guest.ID,
+			ReceiverID: s.provider.ID,
+			Type:       constants.LeaveSeatMessage,
+		})
+		h.sendSeats(s)
+	}
+}
+
+// sendSeats tells the player of the session who plays in the other seats
+func (h *Hub) sendSeats(s *activeSession) {
+	s.mu.Lock()
+	player := s.player
+	data, err := json.Marshal(SeatsData{Seats: s.seats()})
+	s.mu.Unlock()
+	if err != nil {
+		log.Println("Couldn't marshal seats", err)
+		return
+	}
+
+	player.sendMsg(player, Message{
+		Type: constants.SeatsMessage,
+		Data: string(data),
+	})
+}
+
+// sendSeat tells the provider of the session which seat the guest plays
+func (s *activeSession) sendSeat(seat GuestSeat) {
+	data, err := json.Marshal(SeatData{HostID: s.currentPlayer().ID, Seat: seat.Seat})
+	if err != nil {
+		log.Println("Couldn't marshal seat", err)
+		return
+	}
+
+	s.provider.sendMsg(s.provider, Message{
+		SenderID:   seat.GuestID,
+		ReceiverID: s.provider.ID,
+		Type:       constants.SeatMessage,
+		Data:       string(data),
+	})
+}
+
+// handleJoinSeatMsg asks the provider of the session of the invite code to let the client play in a free seat
+func (c *Client) handleJoinSeatMsg(msg *Message) error {
+	if c.role == constants.Provider {
+		return nil
+	}
+	invite, err := parseInviteData(msg.Data)
+	if err != nil {
+		c.sendError(constants.CoordinatorStage, constants.InvalidMessageError, "Invalid join message")
+		return err
+	}
+
+	s, seat, err := c.hub.addGuest(c, invite.Code)
+	if errors.Is(err, errSeatsFull) {
+		c.sendError(constants.CoordinatorStage, constants.SeatsFullError, "All seats are taken")
+		return err
+	} else if err != nil {
+		c.sendError(constants.CoordinatorStage, constants.SessionNotFoundError, "The game has ended")
+		return err
+	}
+	log.Printf("Client %s plays session %s in seat %d", c.ID, s.record.ID, seat)
+
+	matchedData, err := json.Marshal(MatchedData{ProviderID: s.provider.ID})
+	if err != nil {
+		return err
+	}
+
+	// Guest must know the provider before receiving its offer
+	c.sendMsg(c, Message{
+		SenderID: s.provider.ID,
+		Type:     constants.MatchedMessage,
+		Data:     string(matchedData),
+	})
+	s.sendSeat(GuestSeat{GuestID: c.ID, Seat: seat})
+	c.hub.sendSeats(s)
+
+	return nil
+}
+
+// handleSeatMsg moves a guest of the player's session to another seat
+func (c *Client) handleSeatMsg(msg *Message) error {
+	if c.role == constants.Provider {
+		return nil
+	}
+	seat, err := parseGuestSeat(msg.Data)
+	if err != nil {
+		c.sendError(constants.CoordinatorStage, constants.InvalidMessageError, "Invalid seat message")
+		return err
+	}
+
+	s, moved, err := c.hub.moveGuest(c, seat.GuestID, seat.Seat)
+	if err != nil {
+		c.sendError(constants.CoordinatorStage, constants.InvalidMessageError, "Couldn't change seats")
+		return err
+	}
+	for _, m := range moved {
+		s.sendSeat(m)
+	}
+	c.hub.sendSeats(s)
+
+	return nil
+}
+
+// handleKickMsg disconnects a guest from the player's session
+func (c *Client) handleKickMsg(msg *Message) error {
+	if c.role == constants.Provider {
+		return nil
+	}
+	seat, err := parseGuestSeat(msg.Data)
+	if err != nil {
+		c.sendError(constants.CoordinatorStage, constants.InvalidMessageError, "Invalid kick message")
+		return err
+	}
+
+	s, err := c.hub.kickGuest(c, seat.GuestID)
+	if err != nil {
+		c.sendError(constants.CoordinatorStage, constants.InvalidMessageError, "Couldn't remove the player")
+		return err
+	}
+	log.Printf("Player %s removed guest %s from session %s", c.ID, seat.GuestID, s.record.ID)
+
+	// Guest is told right away, the provider's ended message comes once it has disconnected the guest
+	c.sendMsg(s.provider, Message{
+		SenderID:   seat.GuestID,
+		ReceiverID: s.provider.ID,
+		Type:       constants.LeaveSeatMessage,
+	})
+	if guest := c.hub.GetClient(seat.GuestID); guest != nil {
+		c.sendMsg(guest, Message{
+			SenderID: s.provider.ID,
+			Type:     constants.EndedMessage,
+		})
+	}
+	c.hub.sendSeats(s)
+
+	return nil
+}
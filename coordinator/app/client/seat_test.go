@@ -0,0 +1,115 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"coordinator/app/storage"
+	"coordinator/constants"
+	"coordinator/settings"
+)
+
+func nextSeats(t *testing.T, c *Client) []GuestSeat {
+	msg := nextMsg(t, c)
+	var data SeatsData
+	if msg.Type != constants.SeatsMessage || json.Unmarshal([]byte(msg.Data), &data) != nil {
+		t.Fatalf("expected seats message, got %+v", msg)
+	}
+
+	return data.Seats
+}
+
+func TestPlayerControlsSeatsOfGuests(t *testing.T) {
+	seats := settings.MaxSeats
+	settings.MaxSeats = 3
+	defer func() { settings.MaxSeats = seats }()
+
+	hub := newTestHub(t)
+	provider := newTestProvider(hub, "provider", &ProviderInfo{RegisteredID: "pc", OwnerID: "owner", MaxSessions: 1})
+	provider.outputBuf = make(chan interface{}, 10)
+	player := &Client{ID: "player", AccountID: "alice", role: constants.Player, hub: hub, outputBuf: make(chan interface{}, 10)}
+	hub.AddClient(player)
+	guests := make([]*Client, 3)
+	for i, id := range []string{"bob", "carol", "dave"} {
+		guests[i] = &Client{ID: id, AccountID: id, role: constants.Player, hub: hub, outputBuf: make(chan interface{}, 10)}
+		hub.AddClient(guests[i])
+	}
+
+	hub.beginSession(player, provider, &PlayData{AppID: "tarzan", Device: "pc"})
+	hub.advanceSession("player", provider, storage.SessionStreaming)
+
+	// Codes to watch the session don't seat guests
+	player.handleMsg(&Message{Type: constants.InviteMessage})
+	watchInvite := nextMsg(t, player).Data
+	guests[0].handleMsg(&Message{Type: constants.JoinSeatMessage, Data: watchInvite})
+	if msg := nextMsg(t, guests[0]); msg.Type != constants.ErrorMessage {
+		t.Fatalf("expected session not found error, got %+v", msg)
+	}
+
+	player.handleMsg(&Message{Type: constants.InviteMessage, Data: `{"seat":true}`})
+	msg := nextMsg(t, player)
+	invite, err := parseInviteData(msg.Data)
+	if msg.Type != constants.InviteMessage || err != nil || !invite.Seat || strings.Contains(watchInvite, invite.Code) {
+		t.Fatalf("expected seat code, got %+v", msg)
+	}
+	data, _ := json.Marshal(InviteData{Code: invite.Code})
+
+	// Guests get the free seats after the player's
+	for i, guest := range guests[:2] {
+		guest.handleMsg(&Message{Type: constants.JoinSeatMessage, Data: string(data)})
+		if msg := nextMsg(t, guest); msg.Type != constants.MatchedMessage || msg.SenderID != "provider" {
+			t.Fatalf("expected matched message, got %+v", msg)
+		}
+		want := fmt.Sprintf(`{"hostID":"player","seat":%d}`, i+1)
+		if msg := nextMsg(t, provider); msg.Type != constants.SeatMessage || msg.SenderID != guest.ID || msg.Data != want {
+			t.Fatalf("expected seat message, got %+v", msg)
+		}
+		if seats := nextSeats(t, player); len(seats) != i+1 {
+			t.Fatalf("expected %d guests, got %+v", i+1, seats)
+		}
+	}
+	guests[2].handleMsg(&Message{Type: constants.JoinSeatMessage, Data: string(data)})
+	msg = nextMsg(t, guests[2])
+	errData, _ := parseErrorData(msg.Data)
+	if msg.Type != constants.ErrorMessage || errData == nil || errData.Code != constants.SeatsFullError {
+		t.Fatalf("expected seats full error, got %+v", msg)
+	}
+
+	// Only the player moves guests, who swap seats
+	guests[0].handleMsg(&Message{Type: constants.SeatMessage, Data: `{"guestID":"carol","seat":1}`})
+	if msg := nextMsg(t, guests[0]); msg.Type != constants.ErrorMessage {
+		t.Fatalf("expected guest not to control seats, got %+v", msg)
+	}
+	player.handleMsg(&Message{Type: constants.SeatMessage, Data: `{"guestID":"carol","seat":1}`})
+	for _, want := range []string{`{"hostID":"player","seat":1}`, `{"hostID":"player","seat":2}`} {
+		if msg := nextMsg(t, provider); msg.Type != constants.SeatMessage || msg.Data != want {
+			t.Fatalf("expected seat message %s, got %+v", want, msg)
+		}
+	}
+	if seats := nextSeats(t, player); len(seats) != 2 || seats[0].GuestID != "carol" || seats[1].GuestID != "bob" {
+		t.Fatalf("expected swapped seats, got %+v", seats)
+	}
+
+	// Kicked guests leave, the player keeps playing
+	player.handleMsg(&Message{Type: constants.KickMessage, Data: `{"guestID":"bob"}`})
+	if msg := nextMsg(t, provider); msg.Type != constants.LeaveSeatMessage || msg.SenderID != "bob" {
+		t.Fatalf("expected leave seat message, got %+v", msg)
+	}
+	if msg := nextMsg(t, guests[0]); msg.Type != constants.EndedMessage {
+		t.Fatalf("expected ended message, got %+v", msg)
+	}
+	if seats := nextSeats(t, player); len(seats) != 1 || seats[0].GuestID != "carol" {
+		t.Fatalf("expected one guest left, got %+v", seats)
+	}
+	if s := onlySession(t, hub); s.State != storage.SessionStreaming {
+		t.Fatalf("expected streaming session, got %s", s.State)
+	}
+
+	// Guests see the session end
+	hub.endSession("player", provider, storage.SessionEnded)
+	if msg := nextMsg(t, guests[1]); msg.Type != constants.EndedMessage {
+		t.Fatalf("expected ended message, got %+v", msg)
+	}
+}
@@ -0,0 +1,363 @@
+package client
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"coordinator/app/storage"
+	"coordinator/constants"
+	"coordinator/utils"
+)
+
+// activeSession links a session record to the clients taking part in it
+type activeSession struct {
+	record   *storage.Session
+	player   *Client
+	provider *Client
+	// Secret of the player's resume ticket
+	resumeToken string
+	// Play minutes already debited from the player
+	debited int
+	// Whether the player has been warned about running out of play time
+	warned bool
+	// Whether the provider has been asked to end the session
+	stopping bool
+	// Latest stream quality reports, the oldest first
+	stats []*StatsSample
+	// Code to watch the session, empty until the player invites spectators
+	inviteCode string
+	// Clients watching the session by their ID
+	spectators map[string]*Client
+	// Code to play the session in another seat, empty until the player invites guests
+	seatCode string
+	// Guests playing the session in other seats than the player, by their client ID
+	guests map[string]*seatedGuest
+	// Guards all fields above but the provider and the resume token
+	mu sync.Mutex
+}
+
+// currentPlayer is the client the player streams to, it changes when the player resumes the session
+func (s *activeSession) currentPlayer() *Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.player
+}
+
+// Number of stream quality reports kept per session, i.e. a few minutes
+const maxStatsSamples = 60
+
+// StatsSample is a stream quality report received at a time
+type StatsSample struct {
+	At time.Time `json:"at"`
+	SessionStatsData
+}
+
+// sessionTracker follows sessions from the start request until they end.
+// A player has at most one active session, so sessions are looked up by the player's client ID.
+type sessionTracker struct {
+	sessions map[string]*activeSession
+	mu       sync.Mutex
+}
+
+func newSessionTracker() *sessionTracker {
+	return &sessionTracker{
+		sessions: make(map[string]*activeSession),
+	}
+}
+
+// beginSession records that the player asked the provider to start a session and returns the ticket to resume it
+func (h *Hub) beginSession(player, provider *Client, playData *PlayData) ResumeTicket {
+	info := provider.Provider.Snapshot()
+	record := &storage.Session{
+		ID:         utils.RandString(16),
+		PlayerID:   player.AccountID,
+		ProviderID: info.RegisteredID,
+		OwnerID:    info.OwnerID,
+		AppID:      playData.AppID,
+		Device:     playData.Device,
+		State:      storage.SessionRequested,
+		CreatedAt:  time.Now(),
+	}
+	token := utils.SecretString(32)
+
+	h.tracker.mu.Lock()
+	previous := h.tracker.sessions[player.ID]
+	h.tracker.sessions[player.ID] = &activeSession{
+		record:      record,
+		player:      player,
+		provider:    provider,
+		resumeToken: token,
+		spectators:  make(map[string]*Client),
+		guests:      make(map[string]*seatedGuest),
+	}
+	h.tracker.mu.Unlock()
+
+	// Provider replaces the previous session of the player, another provider must be asked to end it
+	if previous != nil {
+		h.finishSession(previous, storage.SessionEnded)
+		if previous.provider != provider {
+			previous.provider.sendMsg(previous.provider, Message{
+				SenderID:   previous.currentPlayer().ID,
+				ReceiverID: previous.provider.ID,
+				Type:       constants.EndMessage,
+			})
+		}
+	}
+
+	log.Printf("Session %s of player %s on provider %s is %s", record.ID, player.ID, provider.ID, record.State)
+	h.saveSession(record)
+
+	return ResumeTicket{SessionID: record.ID, Token: token}
+}
+
+// resumeSession moves the session of the ticket to the client the player resumes it from and returns the ID
+// of the previous client. Only the account which started the session can resume it while its provider is connected.
+func (h *Hub) resumeSession(player *Client, ticket *ResumeTicket) (*activeSession, string, error) {
+	h.tracker.mu.Lock()
+	defer h.tracker.mu.Unlock()
+
+	var (
+		s          *activeSession
+		previousID string
+	)
+	for playerID, cur := range h.tracker.sessions {
+		if cur.record.ID == ticket.SessionID {
+			s, previousID = cur, playerID
+			break
+		}
+	}
+	if s == nil || subtle.ConstantTimeCompare([]byte(s.resumeToken), []byte(ticket.Token)) != 1 ||
+		s.record.PlayerID != player.AccountID {
+		return nil, "", errors.New("session not found")
+	}
+	if h.GetClient(s.provider.ID) != s.provider || s.provider.isDetached() {
+		return nil, "", errors.New("provider of the session is no longer connected")
+	}
+	if cur, ok := h.tracker.sessions[player.ID]; ok && cur != s {
+		return nil, "", errors.New("player has another session")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopping {
+		return nil, "", errors.New("session is ending")
+	}
+
+	delete(h.tracker.sessions, previousID)
+	h.tracker.sessions[player.ID] = s
+	s.player = player
+
+	return s, previousID, nil
+}
+
+// advanceSession moves the session of the player on the provider to the state.
+// Sessions never go back to a previous state.
+func (h *Hub) advanceSession(playerID string, provider *Client, state storage.SessionState) {
+	h.tracker.mu.Lock()
+	s, ok := h.tracker.sessions[playerID]
+	h.tracker.mu.Unlock()
+	if !ok || s.provider != provider {
+		return
+	}
+
+	s.mu.Lock()
+	if !stateAfter(state, s.record.State) {
+		s.mu.Unlock()
+		return
+	}
+	s.record.State = state
+	if state == storage.SessionStreaming {
+		s.record.StartedAt = time.Now()
+	}
+	record := *s.record
+	s.mu.Unlock()
+
+	log.Printf("Session %s of player %s on provider %s is %s", record.ID, playerID, provider.ID, record.State)
+	h.saveSession(&record)
+}
+
+// endSession ends the session of the player on the provider with the state
+func (h *Hub) endSession(playerID string, provider *Client, state storage.SessionState) {
+	h.tracker.mu.Lock()
+	s, ok := h.tracker.sessions[playerID]
+	if !ok || s.provider != provider {
+		h.tracker.mu.Unlock()
+		return
+	}
+	delete(h.tracker.sessions, playerID)
+	h.tracker.mu.Unlock()
+
+	h.finishSession(s, state)
+}
+
+// endProviderSessions ends all sessions of a provider which has disconnected
+func (h *Hub) endProviderSessions(provider *Client) {
+	var ended []*activeSession
+
+	h.tracker.mu.Lock()
+	for playerID, s := range h.tracker.sessions {
+		if s.provider == provider {
+			ended = append(ended, s)
+			delete(h.tracker.sessions, playerID)
+		}
+	}
+	h.tracker.mu.Unlock()
+
+	for _, s := range ended {
+		h.finishSession(s, storage.SessionFailed)
+	}
+}
+
+// syncProviderSessions reconciles the sessions of a provider which joins with the sessions it announces.
+// Sessions which ended while it was disconnected are ended, and it's asked to end sessions which are no longer tracked.
+func (h *Hub) syncProviderSessions(provider *Client, running []RunningSessionData) {
+	untracked := make(map[string]string, len(running))
+	for _, r := range running {
+		untracked[r.PlayerID] = r.SessionID
+	}
+
+	var ended, stopping []*activeSession
+	h.tracker.mu.Lock()
+	for playerID, s := range h.tracker.sessions {
+		if s.provider != provider {
+			continue
+		}
+		// Provider doesn't know the ID of a session it hasn't started yet
+		if sessionID, ok := untracked[playerID]; ok && (sessionID == "" || sessionID == s.record.ID) {
+			delete(untracked, playerID)
+			stopping = append(stopping, s)
+			continue
+		}
+		ended = append(ended, s)
+		delete(h.tracker.sessions, playerID)
+	}
+	h.tracker.mu.Unlock()
+
+	for _, s := range ended {
+		h.finishSession(s, storage.SessionFailed)
+	}
+	for playerID := range untracked {
+		log.Printf("Session of player %s on provider %s is no longer tracked, ending it", playerID, provider.ID)
+		provider.sendMsg(provider, Message{
+			SenderID:   playerID,
+			ReceiverID: provider.ID,
+			Type:       constants.EndMessage,
+		})
+	}
+	// Provider may have missed that sessions had to end
+	for _, s := range stopping {
+		s.mu.Lock()
+		ending := s.stopping
+		s.mu.Unlock()
+		if ending {
+			provider.sendMsg(provider, Message{
+				SenderID:   s.currentPlayer().ID,
+				ReceiverID: provider.ID,
+				Type:       constants.EndMessage,
+			})
+		}
+	}
+}
+
+func (h *Hub) finishSession(s *activeSession, state storage.SessionState) {
+	s.mu.Lock()
+	spectators := s.spectators
+	s.spectators = nil
+	guests := s.guests
+	s.guests = nil
+	s.mu.Unlock()
+
+	for _, spectator := range spectators {
+		spectator.sendMsg(spectator, Message{
+			SenderID: s.provider.ID,
+			Type:     constants.EndedMessage,
+		})
+	}
+	for _, guest := range guests {
+		guest.client.sendMsg(guest.client, Message{
+			SenderID: s.provider.ID,
+			Type:     constants.EndedMessage,
+		})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.record.State = state
+	s.record.EndedAt = time.Now()
+	if len(s.stats) > 0 {
+		if stats, err := json.Marshal(s.stats[len(s.stats)-1]); err == nil {
+			s.record.Stats = stats
+		}
+	}
+	h.debitLocked(s)
+
+	log.Printf("Session %s on provider %s is %s after %s", s.record.ID, s.provider.ID, state, s.record.Duration())
+	h.saveSession(s.record)
+
+	if err := h.ledger.Record(s.record); err != nil {
+		log.Println("Couldn't record earning of session", s.record.ID, err)
+	}
+}
+
+// recordSessionStats keeps a stream quality report of the session of the player on the provider
+func (h *Hub) recordSessionStats(playerID string, provider *Client, stats *SessionStatsData) {
+	h.tracker.mu.Lock()
+	s, ok := h.tracker.sessions[playerID]
+	h.tracker.mu.Unlock()
+	if !ok || s.provider != provider {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stats = append(s.stats, &StatsSample{At: time.Now(), SessionStatsData: *stats})
+	if len(s.stats) > maxStatsSamples {
+		s.stats = s.stats[len(s.stats)-maxStatsSamples:]
+	}
+}
+
+// SessionStats returns the latest stream quality reports of a session which hasn't ended yet
+func (h *Hub) SessionStats(sessionID string) ([]*StatsSample, bool) {
+	h.tracker.mu.Lock()
+	sessions := make([]*activeSession, 0, len(h.tracker.sessions))
+	for _, s := range h.tracker.sessions {
+		sessions = append(sessions, s)
+	}
+	h.tracker.mu.Unlock()
+
+	for _, s := range sessions {
+		s.mu.Lock()
+		if s.record.ID == sessionID {
+			stats := make([]*StatsSample, len(s.stats))
+			copy(stats, s.stats)
+			s.mu.Unlock()
+			return stats, true
+		}
+		s.mu.Unlock()
+	}
+
+	return nil, false
+}
+
+func (h *Hub) saveSession(record *storage.Session) {
+	if err := h.store.SaveSession(record); err != nil {
+		log.Println("Couldn't save session", record.ID, err)
+	}
+}
+
+var sessionStateOrder = map[storage.SessionState]int{
+	storage.SessionRequested:   0,
+	storage.SessionNegotiating: 1,
+	storage.SessionStreaming:   2,
+}
+
+func stateAfter(state, current storage.SessionState) bool {
+	return sessionStateOrder[state] > sessionStateOrder[current]
+}
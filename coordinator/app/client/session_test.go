@@ -0,0 +1,230 @@
+package client
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"coordinator/app/storage"
+	"coordinator/constants"
+)
+
+func newTestHub(t *testing.T) *Hub {
+	store, err := storage.NewBoltStorage(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return NewHub(store)
+}
+
+func onlySession(t *testing.T, hub *Hub) *storage.Session {
+	sessions, err := hub.store.ListSessions(storage.SessionFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("expected 1 session, got %d", len(sessions))
+	}
+
+	return sessions[0]
+}
+
+func TestSessionLifecycle(t *testing.T) {
+	hub := newTestHub(t)
+	provider := newTestProvider(hub, "provider", &ProviderInfo{RegisteredID: "pc", OwnerID: "owner", MaxSessions: 1})
+	player := &Client{ID: "player", AccountID: "alice", role: constants.Player, hub: hub}
+
+	hub.beginSession(player, provider, &PlayData{AppID: "tarzan", Device: "pc"})
+	s := onlySession(t, hub)
+	if s.State != storage.SessionRequested || s.PlayerID != "alice" || s.ProviderID != "pc" || s.OwnerID != "owner" {
+		t.Fatalf("unexpected requested session %+v", s)
+	}
+
+	hub.advanceSession("player", provider, storage.SessionStreaming)
+	// Late offers don't move the session back
+	hub.advanceSession("player", provider, storage.SessionNegotiating)
+	if s := onlySession(t, hub); s.State != storage.SessionStreaming || s.StartedAt.IsZero() {
+		t.Fatalf("expected streaming session, got %+v", s)
+	}
+
+	hub.endSession("player", provider, storage.SessionEnded)
+	s = onlySession(t, hub)
+	if s.State != storage.SessionEnded || s.EndedAt.IsZero() || s.EndedAt.Before(s.StartedAt) {
+		t.Fatalf("expected ended session, got %+v", s)
+	}
+
+	// Messages of ended sessions are ignored
+	hub.endSession("player", provider, storage.SessionFailed)
+	if s := onlySession(t, hub); s.State != storage.SessionEnded {
+		t.Fatalf("expected ended session, got %s", s.State)
+	}
+}
+
+func TestSessionsFailWhenProviderDisconnects(t *testing.T) {
+	hub := newTestHub(t)
+	provider := newTestProvider(hub, "provider", &ProviderInfo{RegisteredID: "pc", MaxSessions: 1})
+	other := newTestProvider(hub, "other", &ProviderInfo{RegisteredID: "laptop", MaxSessions: 1})
+	player := &Client{ID: "player", role: constants.Player, hub: hub}
+
+	hub.beginSession(player, provider, &PlayData{AppID: "tarzan", Device: "pc"})
+	hub.advanceSession("player", provider, storage.SessionNegotiating)
+	hub.endProviderSessions(other)
+	if s := onlySession(t, hub); s.State != storage.SessionNegotiating {
+		t.Fatalf("expected negotiating session, got %s", s.State)
+	}
+
+	hub.endProviderSessions(provider)
+	if s := onlySession(t, hub); s.State != storage.SessionFailed {
+		t.Fatalf("expected failed session, got %s", s.State)
+	}
+}
+
+func TestSessionStatsAreKeptUntilSessionEnds(t *testing.T) {
+	hub := newTestHub(t)
+	provider := newTestProvider(hub, "provider", &ProviderInfo{RegisteredID: "pc", MaxSessions: 1})
+	player := &Client{ID: "player", role: constants.Player, hub: hub}
+
+	hub.beginSession(player, provider, &PlayData{AppID: "tarzan", Device: "pc"})
+	id := onlySession(t, hub).ID
+	for i := 0; i < maxStatsSamples+5; i++ {
+		hub.recordSessionStats("player", provider, &SessionStatsData{FramesSent: uint64(i)})
+	}
+	// Only the provider of the session can report its stats
+	hub.recordSessionStats("player", &Client{ID: "other"}, &SessionStatsData{FramesSent: 1000})
+
+	samples, live := hub.SessionStats(id)
+	if !live || len(samples) != maxStatsSamples {
+		t.Fatalf("expected %d live samples, got %d", maxStatsSamples, len(samples))
+	}
+	if last := samples[len(samples)-1]; last.FramesSent != maxStatsSamples+4 {
+		t.Errorf("unexpected last sample %+v", last)
+	}
+
+	hub.endSession("player", provider, storage.SessionEnded)
+	if _, live := hub.SessionStats(id); live {
+		t.Error("stats of ended session must not be live")
+	}
+	if s := onlySession(t, hub); len(s.Stats) == 0 {
+		t.Error("last stats were not saved")
+	}
+}
+
+func TestStartMessageTellsProviderWhoseSessionItIs(t *testing.T) {
+	hub := newTestHub(t)
+	provider := newTestProvider(hub, "provider", &ProviderInfo{RegisteredID: "pc", OwnerID: "owner", MaxSessions: 1})
+	provider.outputBuf = make(chan interface{}, 10)
+	player := &Client{ID: "player", AccountID: "alice", role: constants.Player, hub: hub, outputBuf: make(chan interface{}, 10)}
+	if _, err := hub.store.AdjustCredit("alice", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// Players can't pretend to be another account
+	player.handleMsg(&Message{
+		ReceiverID: "provider",
+		Type:       constants.StartMessage,
+		Data:       `{"appID":"tarzan","device":"pc","accountID":"bob"}`,
+	})
+	// Messages of players are relayed as received
+	msg := (<-provider.outputBuf).(*Message)
+	startData, err := parsePlayData(msg.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.SenderID != "player" || startData.AccountID != "alice" || startData.SessionID != onlySession(t, hub).ID {
+		t.Fatalf("unexpected start message %+v", msg)
+	}
+	if msg := nextMsg(t, player); msg.Type != constants.SessionMessage {
+		t.Fatalf("expected resume ticket, got %+v", msg)
+	}
+
+	player.handleMsg(&Message{ReceiverID: "provider", Type: constants.StartMessage, Data: `{"device":"pc"}`})
+	if msg := nextMsg(t, player); msg.Type != constants.ErrorMessage {
+		t.Fatalf("expected invalid message error, got %+v", msg)
+	}
+	if len(provider.outputBuf) != 0 {
+		t.Fatal("invalid start message was relayed")
+	}
+}
+
+func TestSessionIsResumedFromAnotherClientOfPlayer(t *testing.T) {
+	hub := newTestHub(t)
+	provider := newTestProvider(hub, "provider", &ProviderInfo{RegisteredID: "pc", OwnerID: "owner", MaxSessions: 1})
+	provider.outputBuf = make(chan interface{}, 10)
+	player := &Client{ID: "player", AccountID: "alice", role: constants.Player, hub: hub}
+	reloaded := &Client{ID: "reloaded", AccountID: "alice", role: constants.Player, hub: hub, outputBuf: make(chan interface{}, 10)}
+	other := &Client{ID: "other", AccountID: "bob", role: constants.Player, hub: hub, outputBuf: make(chan interface{}, 10)}
+
+	ticket := hub.beginSession(player, provider, &PlayData{AppID: "tarzan", Device: "pc"})
+	hub.advanceSession("player", provider, storage.SessionStreaming)
+
+	// Tickets only work with their token and for the account which started the session
+	forged := ticket
+	forged.Token = "forged"
+	for _, c := range []struct {
+		client *Client
+		ticket ResumeTicket
+	}{{reloaded, forged}, {other, ticket}} {
+		data, _ := json.Marshal(c.ticket)
+		c.client.handleMsg(&Message{Type: constants.ResumeMessage, Data: string(data)})
+		if msg := nextMsg(t, c.client); msg.Type != constants.ErrorMessage {
+			t.Fatalf("expected session not found error, got %+v", msg)
+		}
+	}
+	if len(provider.outputBuf) != 0 {
+		t.Fatal("provider was asked to resume with an invalid ticket")
+	}
+
+	data, _ := json.Marshal(ticket)
+	reloaded.handleMsg(&Message{Type: constants.ResumeMessage, Data: string(data)})
+	if msg := nextMsg(t, reloaded); msg.Type != constants.MatchedMessage || msg.SenderID != "provider" {
+		t.Fatalf("expected matched message, got %+v", msg)
+	}
+	if msg := nextMsg(t, provider); msg.Type != constants.ResumeMessage || msg.SenderID != "reloaded" || msg.Data != `{"previousID":"player"}` {
+		t.Fatalf("expected resume message, got %+v", msg)
+	}
+
+	// Messages of the provider about the session now refer to the new client
+	hub.endSession("player", provider, storage.SessionEnded)
+	if s := onlySession(t, hub); s.State != storage.SessionStreaming {
+		t.Fatalf("expected streaming session, got %s", s.State)
+	}
+	hub.endSession("reloaded", provider, storage.SessionEnded)
+	if s := onlySession(t, hub); s.State != storage.SessionEnded {
+		t.Fatalf("expected ended session, got %s", s.State)
+	}
+}
+
+func TestNewSessionOnAnotherProviderEndsThePreviousOne(t *testing.T) {
+	hub := newTestHub(t)
+	first := newTestProvider(hub, "first", &ProviderInfo{RegisteredID: "a", MaxSessions: 1})
+	first.outputBuf = make(chan interface{}, 10)
+	second := newTestProvider(hub, "second", &ProviderInfo{RegisteredID: "b", MaxSessions: 1})
+	second.outputBuf = make(chan interface{}, 10)
+	player := &Client{ID: "player", AccountID: "alice", role: constants.Player, hub: hub}
+
+	hub.beginSession(player, first, &PlayData{AppID: "tarzan", Device: "pc"})
+	hub.beginSession(player, second, &PlayData{AppID: "tarzan", Device: "pc"})
+
+	msg := nextMsg(t, first)
+	if msg.Type != constants.EndMessage || msg.SenderID != "player" {
+		t.Fatalf("expected first provider to be asked to end the session, got %+v", msg)
+	}
+	if len(second.outputBuf) != 0 {
+		t.Fatal("expected the new session to keep running")
+	}
+
+	// Ended message of the first provider doesn't end the new session
+	first.handleMsg(&Message{Type: constants.EndedMessage, ReceiverID: "player"})
+	sessions, err := hub.store.ListSessions(storage.SessionFilter{ProviderID: "b"})
+	if err != nil || len(sessions) != 1 || sessions[0].State != storage.SessionRequested {
+		t.Fatalf("expected new session to be requested, got %+v %v", sessions, err)
+	}
+
+	// A provider replaces the previous session of the player by itself
+	hub.beginSession(player, second, &PlayData{AppID: "hercules", Device: "pc"})
+	if len(second.outputBuf) != 0 {
+		t.Fatal("expected no end message to the same provider")
+	}
+}
@@ -0,0 +1,195 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+
+	"coordinator/constants"
+	"coordinator/settings"
+	"coordinator/utils"
+)
+
+var (
+	errNoSession      = errors.New("player has no session")
+	errSpectatorLimit = errors.New("maximum number of spectators reached")
+)
+
+// inviteCode returns the code to watch the session of the player, or to play it in another seat if seat is true.
+// Codes are the same for the whole session.
+func (h *Hub) inviteCode(player *Client, seat bool) (string, error) {
+	h.tracker.mu.Lock()
+	s, ok := h.tracker.sessions[player.ID]
+	h.tracker.mu.Unlock()
+	if !ok {
+		return "", errNoSession
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if seat {
+		if s.seatCode == "" {
+			s.seatCode = utils.SecretString(12)
+		}
+		return s.seatCode, nil
+	}
+	if s.inviteCode == "" {
+		s.inviteCode = utils.SecretString(12)
+	}
+
+	return s.inviteCode, nil
+}
+
+// addSpectator lets the client watch the session of the invite code, up to settings.MaxSpectators
+func (h *Hub) addSpectator(spectator *Client, code string) (*activeSession, error) {
+	h.tracker.mu.Lock()
+	defer h.tracker.mu.Unlock()
+
+	for _, s := range h.tracker.sessions {
+		s.mu.Lock()
+		if s.inviteCode != code {
+			s.mu.Unlock()
+			continue
+		}
+		defer s.mu.Unlock()
+
+		if s.player == spectator || s.stopping || s.provider.isDetached() {
+			return nil, errNoSession
+		}
+		if _, ok := s.spectators[spectator.ID]; !ok && len(s.spectators) >= settings.MaxSpectators {
+			return nil, errSpectatorLimit
+		}
+		s.spectators[spectator.ID] = spectator
+
+		return s, nil
+	}
+
+	return nil, errNoSession
+}
+
+// removeSpectator stops tracking the spectator of the provider's sessions and returns it, nil if it wasn't watching
+func (h *Hub) removeSpectator(spectatorID string, provider *Client) *Client {
+	for _, s := range h.providerSessions(provider) {
+		s.mu.Lock()
+		spectator, ok := s.spectators[spectatorID]
+		delete(s.spectators, spectatorID)
+		s.mu.Unlock()
+		if ok {
+			return spectator
+		}
+	}
+
+	return nil
+}
+
+// stopSpectating stops tracking the client as a spectator and tells providers of the sessions it was watching
+func (h *Hub) stopSpectating(spectator *Client) {
+	h.tracker.mu.Lock()
+	var watched []*activeSession
+	for _, s := range h.tracker.sessions {
+		s.mu.Lock()
+		if _, ok := s.spectators[spectator.ID]; ok {
+			delete(s.spectators, spectator.ID)
+			watched = append(watched, s)
+		}
+		s.mu.Unlock()
+	}
+	h.tracker.mu.Unlock()
+
+	for _, s := range watched {
+		s.provider.sendMsg(s.provider, Message{
+			SenderID:   spectator.ID,
+			ReceiverID: s.provider.ID,
+			Type:       constants.StopSpectatingMessage,
+		})
+	}
+}
+
+func (h *Hub) providerSessions(provider *Client) []*activeSession {
+	h.tracker.mu.Lock()
+	defer h.tracker.mu.Unlock()
+
+	var sessions []*activeSession
+	for _, s := range h.tracker.sessions {
+		if s.provider == provider {
+			sessions = append(sessions, s)
+		}
+	}
+
+	return sessions
+}
+
+// handleInviteMsg sends the player the code to invite spectators, or guests if asked, to the session
+func (c *Client) handleInviteMsg(msg *Message) error {
+	var invite InviteData
+	if msg.Data != "" {
+		if err := json.Unmarshal([]byte(msg.Data), &invite); err != nil {
+			c.sendError(constants.CoordinatorStage, constants.InvalidMessageError, "Invalid invite message")
+			return err
+		}
+	}
+
+	code, err := c.hub.inviteCode(c, invite.Seat)
+	if err != nil {
+		c.sendError(constants.CoordinatorStage, constants.SessionNotFoundError, "You have no game to share")
+		return err
+	}
+
+	data, err := json.Marshal(InviteData{Code: code, Seat: invite.Seat})
+	if err != nil {
+		return err
+	}
+	c.sendMsg(c, Message{
+		Type: constants.InviteMessage,
+		Data: string(data),
+	})
+
+	return nil
+}
+
+// handleSpectateMsg asks the provider of the session of the invite code to stream it to the client
+func (c *Client) handleSpectateMsg(msg *Message) error {
+	if c.role == constants.Provider {
+		return nil
+	}
+	invite, err := parseInviteData(msg.Data)
+	if err != nil {
+		c.sendError(constants.CoordinatorStage, constants.InvalidMessageError, "Invalid spectate message")
+		return err
+	}
+
+	s, err := c.hub.addSpectator(c, invite.Code)
+	if errors.Is(err, errSpectatorLimit) {
+		c.sendError(constants.CoordinatorStage, constants.SpectatorLimitError, "Too many people are already watching")
+		return err
+	} else if err != nil {
+		c.sendError(constants.CoordinatorStage, constants.SessionNotFoundError, "The game has ended")
+		return err
+	}
+	log.Printf("Client %s watches session %s", c.ID, s.record.ID)
+
+	matchedData, err := json.Marshal(MatchedData{ProviderID: s.provider.ID})
+	if err != nil {
+		return err
+	}
+	spectateData, err := json.Marshal(SpectateData{HostID: s.currentPlayer().ID})
+	if err != nil {
+		return err
+	}
+
+	// Spectator must know the provider before receiving its offer
+	c.sendMsg(c, Message{
+		SenderID: s.provider.ID,
+		Type:     constants.MatchedMessage,
+		Data:     string(matchedData),
+	})
+	c.sendMsg(s.provider, Message{
+		SenderID:   c.ID,
+		ReceiverID: s.provider.ID,
+		Type:       constants.SpectateMessage,
+		Data:       string(spectateData),
+	})
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"encoding/json"
+	"testing"
+
+	"coordinator/app/storage"
+	"coordinator/constants"
+	"coordinator/settings"
+)
+
+func TestSpectatorsWatchWithInviteCode(t *testing.T) {
+	limit := settings.MaxSpectators
+	settings.MaxSpectators = 1
+	defer func() { settings.MaxSpectators = limit }()
+
+	hub := newTestHub(t)
+	provider := newTestProvider(hub, "provider", &ProviderInfo{RegisteredID: "pc", OwnerID: "owner", MaxSessions: 1})
+	provider.outputBuf = make(chan interface{}, 10)
+	player := &Client{ID: "player", AccountID: "alice", role: constants.Player, hub: hub, outputBuf: make(chan interface{}, 10)}
+	friend := &Client{ID: "friend", AccountID: "bob", role: constants.Player, hub: hub, outputBuf: make(chan interface{}, 10)}
+	late := &Client{ID: "late", AccountID: "carol", role: constants.Player, hub: hub, outputBuf: make(chan interface{}, 10)}
+
+	hub.beginSession(player, provider, &PlayData{AppID: "tarzan", Device: "pc"})
+	hub.advanceSession("player", provider, storage.SessionStreaming)
+
+	player.handleMsg(&Message{Type: constants.InviteMessage})
+	msg := nextMsg(t, player)
+	invite, err := parseInviteData(msg.Data)
+	if msg.Type != constants.InviteMessage || err != nil {
+		t.Fatalf("expected invite code, got %+v", msg)
+	}
+
+	// Codes of other sessions don't work
+	friend.handleMsg(&Message{Type: constants.SpectateMessage, Data: `{"code":"unknown"}`})
+	if msg := nextMsg(t, friend); msg.Type != constants.ErrorMessage {
+		t.Fatalf("expected session not found error, got %+v", msg)
+	}
+
+	data, _ := json.Marshal(invite)
+	friend.handleMsg(&Message{Type: constants.SpectateMessage, Data: string(data)})
+	if msg := nextMsg(t, friend); msg.Type != constants.MatchedMessage || msg.SenderID != "provider" {
+		t.Fatalf("expected matched message, got %+v", msg)
+	}
+	if msg := nextMsg(t, provider); msg.Type != constants.SpectateMessage || msg.SenderID != "friend" || msg.Data != `{"hostID":"player"}` {
+		t.Fatalf("expected spectate message, got %+v", msg)
+	}
+
+	late.handleMsg(&Message{Type: constants.SpectateMessage, Data: string(data)})
+	msg = nextMsg(t, late)
+	errData, _ := parseErrorData(msg.Data)
+	if msg.Type != constants.ErrorMessage || errData == nil || errData.Code != constants.SpectatorLimitError {
+		t.Fatalf("expected spectator limit error, got %+v", msg)
+	}
+
+	// Provider stopping the stream of the spectator doesn't end the session
+	provider.handleMsg(&Message{ReceiverID: "friend", Type: constants.EndedMessage})
+	if msg := nextMsg(t, friend); msg.Type != constants.EndedMessage {
+		t.Fatalf("expected ended message, got %+v", msg)
+	}
+	if s := onlySession(t, hub); s.State != storage.SessionStreaming {
+		t.Fatalf("expected streaming session, got %s", s.State)
+	}
+
+	// Spectators leaving tell the provider, and see the session end
+	late.handleMsg(&Message{Type: constants.SpectateMessage, Data: string(data)})
+	nextMsg(t, late)
+	nextMsg(t, provider)
+	late.handleMsg(&Message{Type: constants.StopSpectatingMessage})
+	if msg := nextMsg(t, provider); msg.Type != constants.StopSpectatingMessage || msg.SenderID != "late" {
+		t.Fatalf("expected stop spectating message, got %+v", msg)
+	}
+	friend.handleMsg(&Message{Type: constants.SpectateMessage, Data: string(data)})
+	nextMsg(t, friend)
+	nextMsg(t, provider)
+	hub.endSession("player", provider, storage.SessionEnded)
+	if msg := nextMsg(t, friend); msg.Type != constants.EndedMessage {
+		t.Fatalf("expected ended message, got %+v", msg)
+	}
+}
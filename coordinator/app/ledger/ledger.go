@@ -0,0 +1,160 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"coordinator/app/storage"
+	"coordinator/settings"
+)
+
+// Period by which earnings are aggregated
+type Period string
+
+const (
+	Daily   Period = "day"
+	Monthly Period = "month"
+)
+
+func ParsePeriod(s string) (Period, error) {
+	switch Period(s) {
+	case Daily, Monthly:
+		return Period(s), nil
+	case "":
+		return Daily, nil
+	}
+
+	return "", fmt.Errorf("unknown period %q", s)
+}
+
+// key returns the name of the period which t belongs to
+func (p Period) key(t time.Time) string {
+	if p == Monthly {
+		return t.UTC().Format("2006-01")
+	}
+	return t.UTC().Format("2006-01-02")
+}
+
+// BillableMinutes rounds the streaming time up to whole minutes
+func BillableMinutes(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+
+	return int((d + time.Minute - 1) / time.Minute)
+}
+
+// Ledger records what owners earn for sessions hosted on their providers
+type Ledger struct {
+	store storage.Storage
+}
+
+func NewLedger(store storage.Storage) *Ledger {
+	return &Ledger{store: store}
+}
+
+// Record bills the streaming time of an ended session to the owner of its provider.
+// Sessions which never streamed are not billed.
+func (l *Ledger) Record(s *storage.Session) error {
+	minutes := BillableMinutes(s.Duration())
+	if minutes == 0 {
+		return nil
+	}
+
+	rate := settings.DefaultRatePerMinute
+	app, err := l.store.GetApp(s.AppID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	if app != nil && app.RatePerMinute > 0 {
+		rate = app.RatePerMinute
+	}
+
+	return l.store.SaveLedgerEntry(&storage.LedgerEntry{
+		SessionID:     s.ID,
+		OwnerID:       s.OwnerID,
+		ProviderID:    s.ProviderID,
+		PlayerID:      s.PlayerID,
+		AppID:         s.AppID,
+		Minutes:       minutes,
+		RatePerMinute: rate,
+		Amount:        int64(minutes) * rate,
+		CreatedAt:     s.EndedAt,
+	})
+}
+
+type Total struct {
+	Minutes int `json:"minutes"`
+	// Amount in cents
+	Amount int64 `json:"amount"`
+}
+
+func (t *Total) add(e *storage.LedgerEntry) {
+	t.Minutes += e.Minutes
+	t.Amount += e.Amount
+}
+
+// Earnings of a single day or month
+type Bucket struct {
+	Period string `json:"period"`
+	Total
+	Apps map[string]*Total `json:"apps"`
+}
+
+type Report struct {
+	OwnerID  string `json:"ownerID"`
+	Period   Period `json:"period"`
+	Currency string `json:"currency"`
+	Total
+	Apps map[string]*Total `json:"apps"`
+	// Buckets are ordered from the oldest
+	Buckets []*Bucket `json:"buckets"`
+}
+
+// Report aggregates earnings of the owner in [from, to) by period and by app
+func (l *Ledger) Report(ownerID string, period Period, from, to time.Time) (*Report, error) {
+	entries, err := l.store.ListLedgerEntries(storage.LedgerFilter{OwnerID: ownerID, From: from, To: to})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		OwnerID:  ownerID,
+		Period:   period,
+		Currency: settings.Currency,
+		Apps:     make(map[string]*Total),
+		Buckets:  make([]*Bucket, 0),
+	}
+	buckets := make(map[string]*Bucket)
+	for _, e := range entries {
+		report.add(e)
+		addToApp(report.Apps, e)
+
+		key := period.key(e.CreatedAt)
+		b, ok := buckets[key]
+		if !ok {
+			b = &Bucket{Period: key, Apps: make(map[string]*Total)}
+			buckets[key] = b
+			report.Buckets = append(report.Buckets, b)
+		}
+		b.add(e)
+		addToApp(b.Apps, e)
+	}
+
+	sort.Slice(report.Buckets, func(i, j int) bool {
+		return report.Buckets[i].Period < report.Buckets[j].Period
+	})
+
+	return report, nil
+}
+
+func addToApp(apps map[string]*Total, e *storage.LedgerEntry) {
+	t, ok := apps[e.AppID]
+	if !ok {
+		t = &Total{}
+		apps[e.AppID] = t
+	}
+	t.add(e)
+}
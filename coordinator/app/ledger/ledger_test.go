@@ -0,0 +1,98 @@
+package ledger
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"coordinator/app/storage"
+	"coordinator/settings"
+)
+
+func TestBillableMinutes(t *testing.T) {
+	cases := map[time.Duration]int{
+		0:                          0,
+		time.Second:                1,
+		time.Minute:                1,
+		time.Minute + time.Second:  2,
+		90*time.Minute - time.Hour: 30,
+	}
+	for d, want := range cases {
+		if got := BillableMinutes(d); got != want {
+			t.Errorf("BillableMinutes(%s) = %d, want %d", d, got, want)
+		}
+	}
+}
+
+func session(id, ownerID, appID string, start time.Time, d time.Duration) *storage.Session {
+	return &storage.Session{
+		ID:        id,
+		OwnerID:   ownerID,
+		AppID:     appID,
+		State:     storage.SessionEnded,
+		StartedAt: start,
+		EndedAt:   start.Add(d),
+	}
+}
+
+func TestReport(t *testing.T) {
+	store, err := storage.NewBoltStorage(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.SaveApp(&storage.App{ID: "hercules", RatePerMinute: 5}); err != nil {
+		t.Fatal(err)
+	}
+
+	l := NewLedger(store)
+	day := time.Date(2026, 1, 31, 10, 0, 0, 0, time.UTC)
+	sessions := []*storage.Session{
+		session("s1", "owner", "hercules", day, 10*time.Minute),
+		session("s2", "owner", "tarzan", day, 90*time.Second),
+		session("s3", "owner", "tarzan", day.AddDate(0, 0, 1), time.Minute),
+		session("s4", "other", "tarzan", day, time.Hour),
+		// Never streamed
+		{ID: "s5", OwnerID: "owner", AppID: "tarzan", State: storage.SessionFailed, EndedAt: day},
+	}
+	for _, s := range sessions {
+		if err := l.Record(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Recording twice must not bill twice
+	if err := l.Record(sessions[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := l.Report("owner", Daily, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarzanRate := settings.DefaultRatePerMinute
+	if report.Minutes != 13 || report.Amount != 50+3*tarzanRate {
+		t.Errorf("unexpected total %+v", report.Total)
+	}
+	if got := report.Apps["tarzan"]; got.Minutes != 3 {
+		t.Errorf("expected 3 minutes of tarzan, got %d", got.Minutes)
+	}
+	if len(report.Buckets) != 2 || report.Buckets[0].Period != "2026-01-31" || report.Buckets[0].Minutes != 12 {
+		t.Errorf("unexpected daily buckets %+v", report.Buckets)
+	}
+
+	report, err = l.Report("owner", Monthly, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Buckets) != 2 || report.Buckets[1].Period != "2026-02" || report.Buckets[1].Minutes != 1 {
+		t.Errorf("unexpected monthly buckets %+v", report.Buckets)
+	}
+
+	report, err = l.Report("owner", Daily, day.AddDate(0, 0, 1), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Minutes != 1 {
+		t.Errorf("expected 1 minute since the second day, got %d", report.Minutes)
+	}
+}
@@ -0,0 +1,412 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	metaBucket          = []byte("meta")
+	accountsBucket      = []byte("accounts")
+	accountNamesBucket  = []byte("account_names")
+	providersBucket     = []byte("providers")
+	providerHostsBucket = []byte("provider_hosts")
+	appsBucket          = []byte("apps")
+	sessionsBucket      = []byte("sessions")
+	ledgerBucket        = []byte("ledger")
+	creditsBucket       = []byte("credits")
+	savesBucket         = []byte("saves")
+
+	versionKey = []byte("version")
+)
+
+type migration func(tx *bolt.Tx) error
+
+// migrations are applied in order, each one exactly once.
+// Never change a released migration, append a new one instead.
+var migrations = []migration{
+	// 1: initial schema
+	func(tx *bolt.Tx) error {
+		buckets := [][]byte{
+			accountsBucket, accountNamesBucket,
+			providersBucket, providerHostsBucket,
+			appsBucket, sessionsBucket,
+		}
+		for _, b := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 2: earnings ledger
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(ledgerBucket)
+		return err
+	},
+	// 3: player credits
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(creditsBucket)
+		return err
+	},
+	// 4: save games
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(savesBucket)
+		return err
+	},
+}
+
+// BoltStorage stores everything in a single bbolt file, values are JSON encoded
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+func NewBoltStorage(path string) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &BoltStorage{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *BoltStorage) migrate() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(metaBucket)
+		if err != nil {
+			return err
+		}
+
+		var version uint64
+		if v := meta.Get(versionKey); v != nil {
+			version = binary.BigEndian.Uint64(v)
+		}
+		if version > uint64(len(migrations)) {
+			return fmt.Errorf("database version %d is newer than supported version %d", version, len(migrations))
+		}
+
+		for ; version < uint64(len(migrations)); version++ {
+			if err := migrations[version](tx); err != nil {
+				return fmt.Errorf("migration %d failed: %w", version+1, err)
+			}
+		}
+
+		v := make([]byte, 8)
+		binary.BigEndian.PutUint64(v, version)
+		return meta.Put(versionKey, v)
+	})
+}
+
+func (s *BoltStorage) Close() error {
+	return s.db.Close()
+}
+
+func put(b *bolt.Bucket, key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return b.Put([]byte(key), data)
+}
+
+func get(b *bolt.Bucket, key string, v interface{}) error {
+	data := b.Get([]byte(key))
+	if data == nil {
+		return ErrNotFound
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+func (s *BoltStorage) CreateAccount(a *Account) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		names := tx.Bucket(accountNamesBucket)
+		if names.Get([]byte(a.Name)) != nil {
+			return ErrExists
+		}
+		if err := names.Put([]byte(a.Name), []byte(a.ID)); err != nil {
+			return err
+		}
+
+		return put(tx.Bucket(accountsBucket), a.ID, a)
+	})
+}
+
+func (s *BoltStorage) GetAccount(id string) (*Account, error) {
+	var a Account
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return get(tx.Bucket(accountsBucket), id, &a)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+func (s *BoltStorage) GetAccountByName(name string) (*Account, error) {
+	var a Account
+	err := s.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(accountNamesBucket).Get([]byte(name))
+		if id == nil {
+			return ErrNotFound
+		}
+		return get(tx.Bucket(accountsBucket), string(id), &a)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+func providerHostKey(ownerID, hostName string) []byte {
+	return []byte(ownerID + "\x00" + hostName)
+}
+
+func (s *BoltStorage) SaveProvider(p *Provider) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		hosts := tx.Bucket(providerHostsBucket)
+
+		var old Provider
+		err := get(tx.Bucket(providersBucket), p.ID, &old)
+		if err == nil && (old.OwnerID != p.OwnerID || old.HostName != p.HostName) {
+			if err := hosts.Delete(providerHostKey(old.OwnerID, old.HostName)); err != nil {
+				return err
+			}
+		} else if err != nil && err != ErrNotFound {
+			return err
+		}
+
+		if err := hosts.Put(providerHostKey(p.OwnerID, p.HostName), []byte(p.ID)); err != nil {
+			return err
+		}
+
+		return put(tx.Bucket(providersBucket), p.ID, p)
+	})
+}
+
+func (s *BoltStorage) GetProvider(id string) (*Provider, error) {
+	var p Provider
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return get(tx.Bucket(providersBucket), id, &p)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+func (s *BoltStorage) GetProviderByHost(ownerID, hostName string) (*Provider, error) {
+	var p Provider
+	err := s.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(providerHostsBucket).Get(providerHostKey(ownerID, hostName))
+		if id == nil {
+			return ErrNotFound
+		}
+		return get(tx.Bucket(providersBucket), string(id), &p)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+func (s *BoltStorage) ListProviders(ownerID string) ([]*Provider, error) {
+	providers := make([]*Provider, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(providersBucket).ForEach(func(_, v []byte) error {
+			var p Provider
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+			if ownerID == "" || p.OwnerID == ownerID {
+				providers = append(providers, &p)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return providers, nil
+}
+
+func (s *BoltStorage) SaveApp(a *App) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return put(tx.Bucket(appsBucket), a.ID, a)
+	})
+}
+
+func (s *BoltStorage) GetApp(id string) (*App, error) {
+	var a App
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return get(tx.Bucket(appsBucket), id, &a)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+func (s *BoltStorage) ListApps() ([]*App, error) {
+	apps := make([]*App, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(appsBucket).ForEach(func(_, v []byte) error {
+			var a App
+			if err := json.Unmarshal(v, &a); err != nil {
+				return err
+			}
+			apps = append(apps, &a)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return apps, nil
+}
+
+func (s *BoltStorage) SaveSession(session *Session) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return put(tx.Bucket(sessionsBucket), session.ID, session)
+	})
+}
+
+func (s *BoltStorage) GetSession(id string) (*Session, error) {
+	var session Session
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return get(tx.Bucket(sessionsBucket), id, &session)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+func (s *BoltStorage) ListSessions(filter SessionFilter) ([]*Session, error) {
+	sessions := make([]*Session, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(sessionsBucket).ForEach(func(_, v []byte) error {
+			var session Session
+			if err := json.Unmarshal(v, &session); err != nil {
+				return err
+			}
+			if filter.match(&session) {
+				sessions = append(sessions, &session)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+	})
+
+	return sessions, nil
+}
+
+func (s *BoltStorage) GetCredit(accountID string) (int, error) {
+	var balance int
+	err := s.db.View(func(tx *bolt.Tx) error {
+		err := get(tx.Bucket(creditsBucket), accountID, &balance)
+		if err == ErrNotFound {
+			return nil
+		}
+		return err
+	})
+
+	return balance, err
+}
+
+func (s *BoltStorage) AdjustCredit(accountID string, delta int) (int, error) {
+	var balance int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		credits := tx.Bucket(creditsBucket)
+		if err := get(credits, accountID, &balance); err != nil && err != ErrNotFound {
+			return err
+		}
+
+		balance += delta
+		if balance < 0 {
+			balance = 0
+		}
+		return put(credits, accountID, balance)
+	})
+
+	return balance, err
+}
+
+func (s *BoltStorage) SaveLedgerEntry(e *LedgerEntry) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return put(tx.Bucket(ledgerBucket), e.SessionID, e)
+	})
+}
+
+func (s *BoltStorage) ListLedgerEntries(filter LedgerFilter) ([]*LedgerEntry, error) {
+	entries := make([]*LedgerEntry, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(ledgerBucket).ForEach(func(_, v []byte) error {
+			var e LedgerEntry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if filter.match(&e) {
+				entries = append(entries, &e)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+
+	return entries, nil
+}
+
+func saveGameKey(playerID, appID string) string {
+	return playerID + "\x00" + appID
+}
+
+func (s *BoltStorage) PutSaveGame(g *SaveGame) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return put(tx.Bucket(savesBucket), saveGameKey(g.PlayerID, g.AppID), g)
+	})
+}
+
+func (s *BoltStorage) GetSaveGame(playerID, appID string) (*SaveGame, error) {
+	var g SaveGame
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return get(tx.Bucket(savesBucket), saveGameKey(playerID, appID), &g)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &g, nil
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestStorage(t *testing.T) (*BoltStorage, string) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := NewBoltStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	return s, path
+}
+
+func TestDataSurvivesReopen(t *testing.T) {
+	s, path := newTestStorage(t)
+	if err := s.CreateAccount(&Account{ID: "a1", Name: "alice", Role: "owner"}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	// Migrations must not be applied twice
+	s, err := NewBoltStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	a, err := s.GetAccountByName("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.ID != "a1" || a.Role != "owner" {
+		t.Errorf("unexpected account %+v", a)
+	}
+}
+
+func TestAccountNamesAreUnique(t *testing.T) {
+	s, _ := newTestStorage(t)
+
+	if err := s.CreateAccount(&Account{ID: "a1", Name: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateAccount(&Account{ID: "a2", Name: "alice"}); err != ErrExists {
+		t.Errorf("expected ErrExists, got %v", err)
+	}
+	if _, err := s.GetAccount("a2"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestProviderIsFoundByHost(t *testing.T) {
+	s, _ := newTestStorage(t)
+
+	if err := s.SaveProvider(&Provider{ID: "p1", OwnerID: "a1", HostName: "box"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveProvider(&Provider{ID: "p2", OwnerID: "a2", HostName: "box"}); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := s.GetProviderByHost("a1", "box")
+	if err != nil || p.ID != "p1" {
+		t.Fatalf("expected provider p1, got %v %v", p, err)
+	}
+
+	// Renamed host must not be found by its old name
+	if err := s.SaveProvider(&Provider{ID: "p1", OwnerID: "a1", HostName: "new-box"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetProviderByHost("a1", "box"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	providers, err := s.ListProviders("a1")
+	if err != nil || len(providers) != 1 || providers[0].HostName != "new-box" {
+		t.Errorf("unexpected providers of a1: %v %v", providers, err)
+	}
+}
+
+func TestListSessionsFiltersAndSorts(t *testing.T) {
+	s, _ := newTestStorage(t)
+	now := time.Now()
+
+	sessions := []*Session{
+		{ID: "s1", PlayerID: "u1", AppID: "tarzan", CreatedAt: now.Add(-2 * time.Hour)},
+		{ID: "s2", PlayerID: "u2", AppID: "tarzan", CreatedAt: now.Add(-time.Hour)},
+		{ID: "s3", PlayerID: "u1", AppID: "hercules", CreatedAt: now},
+	}
+	for _, session := range sessions {
+		if err := s.SaveSession(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := s.ListSessions(SessionFilter{PlayerID: "u1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].ID != "s3" || got[1].ID != "s1" {
+		t.Errorf("unexpected sessions of u1: %v", got)
+	}
+
+	got, err = s.ListSessions(SessionFilter{AppID: "tarzan", PlayerID: "u2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].ID != "s2" {
+		t.Errorf("unexpected sessions: %v", got)
+	}
+}
+
+func TestSaveGamesArePerPlayerAndApp(t *testing.T) {
+	s, _ := newTestStorage(t)
+
+	if err := s.PutSaveGame(&SaveGame{PlayerID: "u1", AppID: "tarzan", SessionID: "s1", Archive: []byte("old")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutSaveGame(&SaveGame{PlayerID: "u1", AppID: "tarzan", SessionID: "s2", Archive: []byte("new")}); err != nil {
+		t.Fatal(err)
+	}
+
+	g, err := s.GetSaveGame("u1", "tarzan")
+	if err != nil || g.SessionID != "s2" || string(g.Archive) != "new" {
+		t.Errorf("unexpected saves %+v %v", g, err)
+	}
+	if _, err := s.GetSaveGame("u1", "hercules"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := s.GetSaveGame("u2", "tarzan"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	ErrExists   = errors.New("already exists")
+)
+
+type Account struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Role         string    `json:"role"`
+	PasswordHash []byte    `json:"passwordHash"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// Provider is a computer registered by an owner
+type Provider struct {
+	ID       string    `json:"id"`
+	OwnerID  string    `json:"ownerID"`
+	HostName string    `json:"hostName"`
+	Platform string    `json:"platform"`
+	CpuName  string    `json:"cpuName"`
+	CpuNum   int       `json:"cpuNum"`
+	MemSize  float64   `json:"memSize"`
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+type App struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	PosterURL string   `json:"posterURL"`
+	Device    []string `json:"device"`
+	// Price of a minute of play in cents, 0 means the default rate
+	RatePerMinute int64 `json:"ratePerMinute"`
+}
+
+type SessionState string
+
+const (
+	// Player asked a provider to start the session
+	SessionRequested SessionState = "requested"
+	// Provider offered a stream to the player
+	SessionNegotiating SessionState = "negotiating"
+	// Player is receiving the stream
+	SessionStreaming SessionState = "streaming"
+	SessionEnded     SessionState = "ended"
+	SessionFailed    SessionState = "failed"
+)
+
+// Done tells whether the session can't change anymore
+func (s SessionState) Done() bool {
+	return s == SessionEnded || s == SessionFailed
+}
+
+// Session is a record of a player playing an app on a provider
+type Session struct {
+	ID         string       `json:"id"`
+	PlayerID   string       `json:"playerID"`
+	ProviderID string       `json:"providerID"`
+	OwnerID    string       `json:"ownerID"`
+	AppID      string       `json:"appID"`
+	Device     string       `json:"device"`
+	State      SessionState `json:"state"`
+	CreatedAt  time.Time    `json:"createdAt"`
+	StartedAt  time.Time    `json:"startedAt"`
+	EndedAt    time.Time    `json:"endedAt"`
+	// Last stream quality reported by the provider
+	Stats json.RawMessage `json:"stats,omitempty"`
+}
+
+// Duration is how long the player has been streaming, up to now if the session is still going on
+func (s *Session) Duration() time.Duration {
+	if s.StartedAt.IsZero() {
+		return 0
+	}
+	if s.EndedAt.IsZero() {
+		return time.Since(s.StartedAt)
+	}
+
+	return s.EndedAt.Sub(s.StartedAt)
+}
+
+// SessionFilter selects sessions by the non-empty fields
+type SessionFilter struct {
+	PlayerID   string
+	ProviderID string
+	OwnerID    string
+	AppID      string
+}
+
+func (f *SessionFilter) match(s *Session) bool {
+	return (f.PlayerID == "" || s.PlayerID == f.PlayerID) &&
+		(f.ProviderID == "" || s.ProviderID == f.ProviderID) &&
+		(f.OwnerID == "" || s.OwnerID == f.OwnerID) &&
+		(f.AppID == "" || s.AppID == f.AppID)
+}
+
+// LedgerEntry is what the owner of a provider earned for a session
+type LedgerEntry struct {
+	SessionID  string `json:"sessionID"`
+	OwnerID    string `json:"ownerID"`
+	ProviderID string `json:"providerID"`
+	PlayerID   string `json:"playerID"`
+	AppID      string `json:"appID"`
+	Minutes    int    `json:"minutes"`
+	// Amounts are in cents
+	RatePerMinute int64     `json:"ratePerMinute"`
+	Amount        int64     `json:"amount"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// LedgerFilter selects entries of an owner created in [From, To), zero times are unbounded
+type LedgerFilter struct {
+	OwnerID string
+	From    time.Time
+	To      time.Time
+}
+
+func (f *LedgerFilter) match(e *LedgerEntry) bool {
+	return (f.OwnerID == "" || e.OwnerID == f.OwnerID) &&
+		(f.From.IsZero() || !e.CreatedAt.Before(f.From)) &&
+		(f.To.IsZero() || e.CreatedAt.Before(f.To))
+}
+
+// SaveGame is the latest saves of a player for an app, uploaded by the provider of a session
+type SaveGame struct {
+	PlayerID  string `json:"playerID"`
+	AppID     string `json:"appID"`
+	SessionID string `json:"sessionID"`
+	// Creation time of the session the saves come from
+	SessionCreatedAt time.Time `json:"sessionCreatedAt"`
+	// Gzipped tar of the save directories of the app
+	Archive   []byte    `json:"archive"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Storage persists the state of the coordinator which must survive restarts
+type Storage interface {
+	// CreateAccount fails with ErrExists if an account with the same name exists
+	CreateAccount(a *Account) error
+	GetAccount(id string) (*Account, error)
+	GetAccountByName(name string) (*Account, error)
+
+	// SaveProvider creates or updates the provider
+	SaveProvider(p *Provider) error
+	GetProvider(id string) (*Provider, error)
+	// GetProviderByHost finds the provider an owner registered for a computer
+	GetProviderByHost(ownerID, hostName string) (*Provider, error)
+	ListProviders(ownerID string) ([]*Provider, error)
+
+	// SaveApp creates or updates the app
+	SaveApp(a *App) error
+	GetApp(id string) (*App, error)
+	ListApps() ([]*App, error)
+
+	// SaveSession creates or updates the session
+	SaveSession(s *Session) error
+	GetSession(id string) (*Session, error)
+	// ListSessions returns matching sessions, the most recent first
+	ListSessions(filter SessionFilter) ([]*Session, error)
+
+	// GetCredit returns the prepaid play minutes of the account, 0 if it never had any
+	GetCredit(accountID string) (int, error)
+	// AdjustCredit adds delta minutes to the balance of the account and returns the new balance.
+	// The balance never goes below zero.
+	AdjustCredit(accountID string, delta int) (int, error)
+
+	// SaveLedgerEntry creates or replaces the entry of the session
+	SaveLedgerEntry(e *LedgerEntry) error
+	// ListLedgerEntries returns matching entries, the oldest first
+	ListLedgerEntries(filter LedgerFilter) ([]*LedgerEntry, error)
+
+	// PutSaveGame creates or replaces the saves of the player for the app
+	PutSaveGame(g *SaveGame) error
+	GetSaveGame(playerID, appID string) (*SaveGame, error)
+
+	Close() error
+}
@@ -4,9 +4,9 @@ import (
 	"log"
 	"net/http"
 
+	"coordinator/app/auth"
 	"coordinator/app/client"
 	"coordinator/settings"
-	"coordinator/utils"
 
 	"github.com/gorilla/websocket"
 )
@@ -15,6 +15,11 @@ var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
 	CheckOrigin: func(r *http.Request) bool {
+		// Providers are not browsers and don't send any origin
+		if r.Header.Get("Origin") == "" {
+			return true
+		}
+
 		for _, origin := range settings.AllowedWSOrigins {
 			if r.Header.Get("Origin") == origin || origin == "*" {
 				return true
@@ -26,14 +31,27 @@ var upgrader = websocket.Upgrader{
 }
 
 func ServeWs(hub *client.Hub, w http.ResponseWriter, r *http.Request) {
+	claims, err := auth.FromRequest(r)
+	if err != nil {
+		log.Println("Rejected connection from", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("Failed to upgrade connection from", r.RemoteAddr, err)
 		return
 	}
 
-	randID := utils.RandString(6)
-	c := client.NewClient(randID, conn, hub)
-
-	hub.AddClient(c)
+	// Providers reconnecting with their identity are bound again to their client
+	var identity *client.ProviderIdentity
+	if id := r.Header.Get("X-Provider-ID"); id != "" {
+		identity = &client.ProviderIdentity{ID: id, Secret: r.Header.Get("X-Provider-Secret")}
+	}
+	if _, err := hub.ConnectClient(claims, conn, identity); err != nil {
+		log.Println("Rejected connection from", r.RemoteAddr, err)
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()))
+		conn.Close()
+	}
 }
@@ -4,11 +4,49 @@ type MessageType string
 
 type Role string
 
+type ErrorStage string
+
+type ErrorCode string
+
 const (
 	Provider Role = "provider"
 	Player   Role = "player"
 
-	JoinMessage         MessageType = "join"
-	JoinAcceptedMessage MessageType = "accepted"
-	StatsMessage        MessageType = "stats"
+	JoinMessage           MessageType = "join"
+	JoinAcceptedMessage   MessageType = "accepted"
+	StatsMessage          MessageType = "stats"
+	StartMessage          MessageType = "start"
+	ErrorMessage          MessageType = "error"
+	PlayMessage           MessageType = "play"
+	MatchedMessage        MessageType = "matched"
+	QueueMessage          MessageType = "queue"
+	LeaveQueueMessage     MessageType = "leave-queue"
+	SDPMessage            MessageType = "sdp"
+	StreamingMessage      MessageType = "streaming"
+	EndedMessage          MessageType = "ended"
+	CreditMessage         MessageType = "credit"
+	EndMessage            MessageType = "end"
+	SessionStatsMessage   MessageType = "session-stats"
+	SessionMessage        MessageType = "session"
+	ResumeMessage         MessageType = "resume"
+	InviteMessage         MessageType = "invite"
+	SpectateMessage       MessageType = "spectate"
+	StopSpectatingMessage MessageType = "stop-spectating"
+	JoinSeatMessage       MessageType = "join-seat"
+	SeatMessage           MessageType = "seat"
+	SeatsMessage          MessageType = "seats"
+	KickMessage           MessageType = "kick"
+	LeaveSeatMessage      MessageType = "leave-seat"
+
+	// Stage of errors raised by the coordinator itself
+	CoordinatorStage ErrorStage = "coordinator"
+
+	ReceiverNotFoundError ErrorCode = "receiver-not-found"
+	BusyError             ErrorCode = "busy"
+	InvalidMessageError   ErrorCode = "invalid-message"
+	ForbiddenError        ErrorCode = "forbidden"
+	NoCreditError         ErrorCode = "no-credit"
+	SessionNotFoundError  ErrorCode = "session-not-found"
+	SpectatorLimitError   ErrorCode = "spectator-limit"
+	SeatsFullError        ErrorCode = "seats-full"
 )
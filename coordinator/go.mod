@@ -5,5 +5,12 @@ go 1.17
 require (
 	github.com/gorilla/websocket v1.5.0
 	github.com/rs/cors v1.8.2
-	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
+	gopkg.in/yaml.v3 v3.0.1
 )
+
+require (
+	go.etcd.io/bbolt v1.3.7
+	golang.org/x/crypto v0.1.0
+)
+
+require golang.org/x/sys v0.4.0 // indirect
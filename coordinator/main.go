@@ -5,27 +5,86 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	"coordinator/app/api/app"
+	apiauth "coordinator/app/api/auth"
+	"coordinator/app/api/credit"
+	"coordinator/app/api/owner"
 	"coordinator/app/api/provider"
+	"coordinator/app/api/save"
+	"coordinator/app/api/session"
+	"coordinator/app/auth"
 	"coordinator/app/client"
+	"coordinator/app/ledger"
+	"coordinator/app/storage"
 	"coordinator/app/ws"
 	"coordinator/settings"
 
 	"github.com/rs/cors"
 )
 
-var port = flag.Int("port", 8080, "port")
+var (
+	port   = flag.Int("port", 8080, "port")
+	dbPath = flag.String("db", "coordinator.db", "path of the database file")
+)
 
 func main() {
 	flag.Parse()
 
-	hub := client.NewHub()
+	store, err := storage.NewBoltStorage(*dbPath)
+	if err != nil {
+		log.Fatalln("Couldn't open database", err)
+	}
+	defer store.Close()
+
+	if err := app.SeedAppCatalog(store, settings.AppCatalogPath); err != nil {
+		log.Fatalln("Couldn't seed app catalog", err)
+	}
+
+	hub := client.NewHub(store)
+	go hub.MeterCredits(settings.CreditMeterInterval)
+	accounts := auth.NewAccounts(store)
+	earnings := ledger.NewLedger(store)
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/apps", app.GetAppList)
+	mux.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
+		apiauth.Register(accounts, w, r)
+	})
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		apiauth.Login(accounts, w, r)
+	})
+	mux.HandleFunc("/apps", func(w http.ResponseWriter, r *http.Request) {
+		app.GetAppList(store, w, r)
+	})
 	mux.HandleFunc("/providers", func(w http.ResponseWriter, r *http.Request) {
-		provider.GetProviderList(hub, w, r)
+		provider.GetProviderList(hub, store, w, r)
+	})
+	mux.HandleFunc("/sessions", func(w http.ResponseWriter, r *http.Request) {
+		session.GetSessionList(store, w, r)
+	})
+	mux.HandleFunc("/sessions/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/stats") {
+			session.GetSessionStats(hub, store, w, r)
+			return
+		}
+		session.GetSession(store, w, r)
+	})
+	mux.HandleFunc("/credits", func(w http.ResponseWriter, r *http.Request) {
+		credit.GetCredit(store, w, r)
+	})
+	mux.HandleFunc("/owners/", func(w http.ResponseWriter, r *http.Request) {
+		owner.GetEarnings(earnings, w, r)
+	})
+	mux.HandleFunc("/saves/", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			save.GetSaves(store, w, r)
+		case http.MethodPut:
+			save.PutSaves(store, w, r)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
 	})
 	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		ws.ServeWs(hub, w, r)
@@ -33,6 +92,7 @@ func main() {
 
 	c := cors.New(cors.Options{
 		AllowedOrigins: settings.AllowedOrigins,
+		AllowedHeaders: []string{"Authorization", "Content-Type"},
 	})
 	handler := c.Handler(mux)
 
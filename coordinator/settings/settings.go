@@ -1,11 +1,76 @@
 package settings
 
+import (
+	"crypto/rand"
+	"log"
+	"os"
+	"time"
+)
+
 var (
 	AllowedOrigins   []string
 	AllowedWSOrigins []string
+
+	// Key to sign auth tokens with
+	AuthSecret []byte
+	TokenTTL   time.Duration
+
+	// Apps which are added to the catalog on start
+	AppCatalogPath string
+
+	// Price of a minute of play in cents for apps without their own rate
+	DefaultRatePerMinute int64
+	Currency             string
+
+	// Free play minutes of new accounts
+	SignupCredit int
+	// How often play time of running sessions is debited
+	CreditMeterInterval time.Duration
+	// Players are warned when less play minutes remain
+	CreditWarning int
+
+	// Maximum size of the compressed saves of a player for an app, in bytes
+	MaxSaveSize int64
+	// Providers may upload saves of a session until this long after it ended
+	SaveUploadWindow time.Duration
+
+	// Sessions of a provider which lost its connection keep running this long for it to reconnect
+	ProviderReconnectWindow time.Duration
+
+	// Maximum number of spectators watching a session besides its player
+	MaxSpectators int
+	// Maximum number of players sharing a session including the player who started it, one per gamepad of the VM
+	MaxSeats int
 )
 
 func init() {
 	AllowedOrigins = []string{"http://localhost:3000"}
-	AllowedWSOrigins = []string{"*"}
+	AllowedWSOrigins = []string{"http://localhost:3000"}
+
+	AuthSecret = []byte(os.Getenv("COPE_AUTH_SECRET"))
+	if len(AuthSecret) == 0 {
+		log.Println("COPE_AUTH_SECRET is not set, tokens will be invalidated on restart")
+		AuthSecret = make([]byte, 32)
+		if _, err := rand.Read(AuthSecret); err != nil {
+			log.Fatalln("Couldn't generate auth secret", err)
+		}
+	}
+	TokenTTL = 30 * 24 * time.Hour
+
+	AppCatalogPath = "app/api/app/apps.yml"
+
+	DefaultRatePerMinute = 1
+	Currency = "USD"
+
+	SignupCredit = 30
+	CreditMeterInterval = 15 * time.Second
+	CreditWarning = 5
+
+	MaxSaveSize = 16 << 20
+	SaveUploadWindow = 10 * time.Minute
+
+	ProviderReconnectWindow = time.Minute
+
+	MaxSpectators = 4
+	MaxSeats = 4
 }
@@ -1,6 +1,8 @@
 package utils
 
 import (
+	cryptorand "crypto/rand"
+	"encoding/hex"
 	"math/rand"
 	"time"
 )
@@ -20,3 +22,14 @@ func RandString(n int) string {
 
 	return string(b)
 }
+
+// SecretString returns n bytes of crypto/rand encoded in hex, for tokens and codes which mustn't be guessed
+func SecretString(n int) string {
+	b := make([]byte, n)
+	if _, err := cryptorand.Read(b); err != nil {
+		// Random source of the system is broken, no secret can be made safely
+		panic(err)
+	}
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,168 @@
+package bitrate
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+const (
+	// Loss fractions of the loss based controller, as in Google Congestion Control
+	highLoss = 0.10
+	lowLoss  = 0.02
+	// Bitrate increase per report while the link has no loss
+	increaseFactor = 1.05
+	// Targets which differ less than that from the last one are not worth re-configuring the encoder
+	minChange = 0.10
+)
+
+// Target is what the encoder of the VM should produce
+type Target struct {
+	// Bits per second
+	Bitrate int `json:"bitrate"`
+	// Frames are scaled down by this factor on weak links
+	Scale float64 `json:"scale"`
+}
+
+// scaleFor lowers the resolution when the bitrate is too low to keep frames sharp
+func scaleFor(bitrate int) float64 {
+	switch {
+	case bitrate >= 1000000:
+		return 1
+	case bitrate >= 500000:
+		return 0.75
+	}
+	return 0.5
+}
+
+// Estimator estimates the bandwidth of the link to the player from RTCP feedback.
+// The estimate is the minimum of a loss based estimate, fed by receiver reports and TWCC feedback,
+// and the latest REMB of the player.
+type Estimator struct {
+	min       float64
+	max       float64
+	lossBased float64
+	// Latest REMB, 0 until the player sends one
+	remb float64
+	// Minimum time between two targets
+	interval   time.Duration
+	last       Target
+	lastUpdate time.Time
+	mu         sync.Mutex
+}
+
+func NewEstimator(initial, min, max int, interval time.Duration) *Estimator {
+	return &Estimator{
+		min:       float64(min),
+		max:       float64(max),
+		lossBased: float64(initial),
+		interval:  interval,
+		last:      Target{Bitrate: initial, Scale: scaleFor(initial)},
+	}
+}
+
+// Feed updates the estimate with RTCP packets sent by the player.
+// It returns a new target if the encoder should change its bitrate or resolution.
+func (e *Estimator) Feed(packets []rtcp.Packet, now time.Time) (Target, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, p := range packets {
+		switch p := p.(type) {
+		case *rtcp.ReceiverEstimatedMaximumBitrate:
+			e.remb = float64(p.Bitrate)
+		case *rtcp.ReceiverReport:
+			for _, r := range p.Reports {
+				e.onLoss(float64(r.FractionLost) / 256)
+			}
+		case *rtcp.TransportLayerCC:
+			if loss, ok := twccLoss(p); ok {
+				e.onLoss(loss)
+			}
+		}
+	}
+
+	if now.Sub(e.lastUpdate) < e.interval {
+		return Target{}, false
+	}
+
+	bitrate := e.estimate()
+	target := Target{Bitrate: bitrate, Scale: scaleFor(bitrate)}
+	change := math.Abs(float64(target.Bitrate-e.last.Bitrate)) / float64(e.last.Bitrate)
+	if change < minChange && target.Scale == e.last.Scale {
+		return Target{}, false
+	}
+
+	e.last = target
+	e.lastUpdate = now
+
+	return target, true
+}
+
+// Target returns the last target
+func (e *Estimator) Target() Target {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.last
+}
+
+func (e *Estimator) onLoss(loss float64) {
+	switch {
+	case loss > highLoss:
+		e.lossBased *= 1 - 0.5*loss
+	case loss < lowLoss:
+		e.lossBased *= increaseFactor
+	}
+	e.lossBased = e.clamp(e.lossBased)
+}
+
+func (e *Estimator) estimate() int {
+	estimate := e.lossBased
+	if e.remb > 0 && e.remb < estimate {
+		estimate = e.remb
+	}
+
+	return int(e.clamp(estimate))
+}
+
+func (e *Estimator) clamp(bitrate float64) float64 {
+	return math.Max(e.min, math.Min(e.max, bitrate))
+}
+
+// twccLoss returns the fraction of packets which the TWCC feedback reports as not received
+func twccLoss(p *rtcp.TransportLayerCC) (float64, bool) {
+	if p.PacketStatusCount == 0 {
+		return 0, false
+	}
+
+	var count, lost int
+	for _, chunk := range p.PacketChunks {
+		switch c := chunk.(type) {
+		case *rtcp.RunLengthChunk:
+			n := int(c.RunLength)
+			count += n
+			if c.PacketStatusSymbol == rtcp.TypeTCCPacketNotReceived {
+				lost += n
+			}
+		case *rtcp.StatusVectorChunk:
+			for _, symbol := range c.SymbolList {
+				// The last chunk may be padded with symbols of packets which don't exist
+				if count+1 > int(p.PacketStatusCount) {
+					break
+				}
+				count++
+				if symbol == rtcp.TypeTCCPacketNotReceived {
+					lost++
+				}
+			}
+		}
+	}
+	if lost > int(p.PacketStatusCount) {
+		lost = int(p.PacketStatusCount)
+	}
+
+	return float64(lost) / float64(p.PacketStatusCount), true
+}
@@ -0,0 +1,78 @@
+package bitrate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func lossReport(loss float64) *rtcp.ReceiverReport {
+	return &rtcp.ReceiverReport{Reports: []rtcp.ReceptionReport{{FractionLost: uint8(loss * 256)}}}
+}
+
+func TestEstimatorBacksOffOnLoss(t *testing.T) {
+	e := NewEstimator(1000000, 100000, 4000000, time.Second)
+	now := time.Now()
+
+	target, ok := e.Feed([]rtcp.Packet{lossReport(0.5)}, now)
+	require.True(t, ok)
+	assert.Equal(t, 750000, target.Bitrate)
+	assert.Equal(t, 0.75, target.Scale)
+
+	// Targets are rate limited
+	_, ok = e.Feed([]rtcp.Packet{lossReport(0.5)}, now.Add(100*time.Millisecond))
+	assert.False(t, ok)
+
+	// Reports received in the meantime still count
+	target, ok = e.Feed([]rtcp.Packet{lossReport(0.5)}, now.Add(2*time.Second))
+	require.True(t, ok)
+	assert.Equal(t, 421875, target.Bitrate)
+	assert.Equal(t, 0.5, target.Scale)
+}
+
+func TestEstimatorIsCappedByREMB(t *testing.T) {
+	e := NewEstimator(1000000, 100000, 4000000, time.Second)
+	now := time.Now()
+
+	target, ok := e.Feed([]rtcp.Packet{&rtcp.ReceiverEstimatedMaximumBitrate{Bitrate: 300000}, lossReport(0)}, now)
+	require.True(t, ok)
+	assert.Equal(t, 300000, target.Bitrate)
+
+	target, ok = e.Feed([]rtcp.Packet{&rtcp.ReceiverEstimatedMaximumBitrate{Bitrate: 50000}}, now.Add(2*time.Second))
+	require.True(t, ok)
+	assert.Equal(t, 100000, target.Bitrate, "target must not go below the minimum")
+}
+
+func TestEstimatorIgnoresSmallChanges(t *testing.T) {
+	e := NewEstimator(1000000, 100000, 4000000, time.Second)
+
+	_, ok := e.Feed([]rtcp.Packet{lossReport(0)}, time.Now())
+	assert.False(t, ok)
+	assert.Equal(t, 1000000, e.Target().Bitrate)
+}
+
+func TestTWCCLoss(t *testing.T) {
+	p := &rtcp.TransportLayerCC{
+		PacketStatusCount: 20,
+		PacketChunks: []rtcp.PacketStatusChunk{
+			&rtcp.RunLengthChunk{PacketStatusSymbol: rtcp.TypeTCCPacketReceivedSmallDelta, RunLength: 10},
+			&rtcp.RunLengthChunk{PacketStatusSymbol: rtcp.TypeTCCPacketNotReceived, RunLength: 3},
+			&rtcp.StatusVectorChunk{
+				SymbolSize: rtcp.TypeTCCSymbolSizeTwoBit,
+				SymbolList: []uint16{
+					rtcp.TypeTCCPacketNotReceived, rtcp.TypeTCCPacketReceivedSmallDelta,
+					rtcp.TypeTCCPacketReceivedSmallDelta, rtcp.TypeTCCPacketReceivedSmallDelta,
+					rtcp.TypeTCCPacketReceivedSmallDelta, rtcp.TypeTCCPacketReceivedSmallDelta,
+					rtcp.TypeTCCPacketReceivedSmallDelta,
+				},
+			},
+		},
+	}
+
+	loss, ok := twccLoss(p)
+	require.True(t, ok)
+	assert.InDelta(t, 0.2, loss, 0.001)
+}
@@ -0,0 +1,129 @@
+// Package codec negotiates the video codec of a session with the browser of the player.
+// AV1 isn't supported, the VM image has no GStreamer element to encode it, so players decoding it get another codec.
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pion/webrtc/v3"
+)
+
+type Codec string
+
+const (
+	H264 Codec = "h264"
+	VP8  Codec = "vp8"
+	VP9  Codec = "vp9"
+)
+
+// Default is used with players which don't tell which codecs they support, every browser can decode it
+const Default = VP8
+
+var ErrNoCommonCodec = errors.New("no common video codec")
+
+var rtcpFeedback = []webrtc.RTCPFeedback{
+	{Type: "goog-remb"},
+	{Type: "ccm", Parameter: "fir"},
+	{Type: "nack"},
+	{Type: "nack", Parameter: "pli"},
+}
+
+// Parameters of codecs registered in the media engine, payload types are the ones browsers commonly use
+var parameters = map[Codec]webrtc.RTPCodecParameters{
+	H264: {
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:    webrtc.MimeTypeH264,
+			ClockRate:   90000,
+			SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f",
+		},
+		PayloadType: 102,
+	},
+	VP8: {
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:  webrtc.MimeTypeVP8,
+			ClockRate: 90000,
+		},
+		PayloadType: 96,
+	},
+	VP9: {
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:    webrtc.MimeTypeVP9,
+			ClockRate:   90000,
+			SDPFmtpLine: "profile-id=0",
+		},
+		PayloadType: 98,
+	},
+}
+
+// Parse reads a codec name, either as named by the provider ("vp8") or as a MIME type of browsers ("video/VP8")
+func Parse(name string) (Codec, error) {
+	c := Codec(strings.TrimPrefix(strings.ToLower(name), "video/"))
+	if _, ok := parameters[c]; !ok {
+		return "", fmt.Errorf("unknown video codec %q", name)
+	}
+
+	return c, nil
+}
+
+// ParseList reads a comma separated list of codecs
+func ParseList(names string) ([]Codec, error) {
+	var codecs []Codec
+	for _, name := range strings.Split(names, ",") {
+		c, err := Parse(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		codecs = append(codecs, c)
+	}
+
+	return codecs, nil
+}
+
+// Negotiate picks the first codec the provider can encode, in its order of preference, which the player can decode.
+// Codecs unknown to the provider are ignored, the default codec is picked if the player doesn't list any codec.
+func Negotiate(encodable []Codec, decodable []string) (Codec, error) {
+	if len(decodable) == 0 {
+		decodable = []string{string(Default)}
+	}
+
+	supported := make(map[Codec]bool)
+	for _, name := range decodable {
+		if c, err := Parse(name); err == nil {
+			supported[c] = true
+		}
+	}
+	for _, c := range encodable {
+		if supported[c] {
+			return c, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: the provider encodes %s, the player decodes %s", ErrNoCommonCodec, Join(encodable), strings.Join(decodable, ", "))
+}
+
+// Join lists codecs separated by commas
+func Join(codecs []Codec) string {
+	names := make([]string, len(codecs))
+	for i, c := range codecs {
+		names[i] = string(c)
+	}
+
+	return strings.Join(names, ", ")
+}
+
+func (c Codec) MimeType() string {
+	return parameters[c].MimeType
+}
+
+// Register registers the codec, and only this one, for video in the media engine
+func (c Codec) Register(m *webrtc.MediaEngine) error {
+	params, ok := parameters[c]
+	if !ok {
+		return fmt.Errorf("unknown video codec %q", c)
+	}
+	params.RTCPFeedback = rtcpFeedback
+
+	return m.RegisterCodec(params, webrtc.RTPCodecTypeVideo)
+}
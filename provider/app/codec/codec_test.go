@@ -0,0 +1,36 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiatePicksPreferredCodecOfProvider(t *testing.T) {
+	c, err := Negotiate([]Codec{VP9, H264, VP8}, []string{"video/VP8", "video/H264", "video/AV1", "video/rtx"})
+	require.NoError(t, err)
+	assert.Equal(t, H264, c)
+}
+
+func TestNegotiateDefaultsForOlderPlayers(t *testing.T) {
+	c, err := Negotiate([]Codec{H264, VP8}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, Default, c)
+}
+
+func TestNegotiateFailsWithoutCommonCodec(t *testing.T) {
+	_, err := Negotiate([]Codec{H264}, []string{"video/VP8", "video/VP9"})
+	assert.ErrorIs(t, err, ErrNoCommonCodec)
+}
+
+func TestParseList(t *testing.T) {
+	codecs, err := ParseList("vp9, H264,video/VP8")
+	require.NoError(t, err)
+	assert.Equal(t, []Codec{VP9, H264, VP8}, codecs)
+
+	_, err = ParseList("vp9,hevc")
+	assert.Error(t, err)
+	_, err = ParseList("vp9,av1")
+	assert.Error(t, err)
+}
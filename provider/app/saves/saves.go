@@ -0,0 +1,266 @@
+// Package saves keeps the save games of players across sessions.
+//
+// Saves of a player for an app live in <settings.SavesDir>/<accountID>/<appID>. Its current directory
+// has a subdirectory for each save path of the app, which is mounted over the save path in the VM.
+// When a session ends, the current directory is archived as a snapshot and the latest snapshots are kept.
+package saves
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"provider/settings"
+)
+
+// Saves are extracted up to this size, so a small archive can't fill the disk
+const maxExtractedSize = 512 << 20
+
+const snapshotTimeFormat = "20060102T150405.000000000"
+
+var (
+	ErrInvalidID      = errors.New("invalid account or app ID")
+	ErrInvalidArchive = errors.New("invalid saves archive")
+	ErrTooLarge       = errors.New("saves are too large")
+)
+
+// IDs become directory names, so they must not be able to escape the saves directory
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Volume is the saves of a player for an app on this provider
+type Volume struct {
+	dir string
+}
+
+func NewVolume(accountID, appID string) (*Volume, error) {
+	if !validID.MatchString(accountID) || !validID.MatchString(appID) {
+		return nil, ErrInvalidID
+	}
+
+	dir, err := filepath.Abs(filepath.Join(settings.SavesDir, accountID, appID))
+	if err != nil {
+		return nil, err
+	}
+	v := &Volume{dir: dir}
+	if err := os.MkdirAll(v.Current(), 0755); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// Current is the directory the VM writes saves to
+func (v *Volume) Current() string {
+	return filepath.Join(v.dir, "current")
+}
+
+func (v *Volume) snapshotDir() string {
+	return filepath.Join(v.dir, "snapshots")
+}
+
+// Snapshot archives the current saves and returns the archive, nil if there are no saves
+func (v *Volume) Snapshot(now time.Time) ([]byte, error) {
+	archive, files, err := Archive(v.Current())
+	if err != nil || files == 0 {
+		return nil, err
+	}
+	if int64(len(archive)) > settings.MaxSaveSize {
+		return nil, ErrTooLarge
+	}
+
+	if err := os.MkdirAll(v.snapshotDir(), 0755); err != nil {
+		return nil, err
+	}
+	name := now.UTC().Format(snapshotTimeFormat) + ".tar.gz"
+	if err := ioutil.WriteFile(filepath.Join(v.snapshotDir(), name), archive, 0644); err != nil {
+		return nil, err
+	}
+
+	return archive, v.prune()
+}
+
+// Snapshots returns paths of the snapshots, the oldest first
+func (v *Volume) Snapshots() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(v.snapshotDir(), "*.tar.gz"))
+	if err != nil {
+		return nil, err
+	}
+	// Names are timestamps which sort chronologically
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+// prune removes the oldest snapshots beyond settings.SaveSnapshots
+func (v *Volume) prune() error {
+	paths, err := v.Snapshots()
+	if err != nil {
+		return err
+	}
+
+	for len(paths) > settings.SaveSnapshots {
+		if err := os.Remove(paths[0]); err != nil {
+			return err
+		}
+		paths = paths[1:]
+	}
+
+	return nil
+}
+
+// Restore replaces the current saves with the archive.
+// Current saves are kept if the archive can't be extracted.
+func (v *Volume) Restore(archive []byte) error {
+	tmp, err := ioutil.TempDir(v.dir, "restore")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := Extract(archive, tmp); err != nil {
+		return err
+	}
+
+	old := v.Current() + ".old"
+	if err := os.RemoveAll(old); err != nil {
+		return err
+	}
+	if err := os.Rename(v.Current(), old); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, v.Current()); err != nil {
+		_ = os.Rename(old, v.Current())
+		return err
+	}
+
+	return os.RemoveAll(old)
+}
+
+// Archive writes directories and regular files under dir into a gzipped tar and returns the number of files
+func Archive(dir string) ([]byte, int, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	files := 0
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir || !(info.IsDir() || info.Mode().IsRegular()) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		files++
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, 0, err
+	}
+
+	return buf.Bytes(), files, nil
+}
+
+// Extract writes directories and regular files of the archive into dir.
+// Entries which would end up outside of dir are rejected.
+func Extract(archive []byte, dir string) error {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidArchive, err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	var extracted int64
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidArchive, err)
+		}
+
+		name := filepath.Clean(filepath.FromSlash(header.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("%w: entry %s is outside of the saves", ErrInvalidArchive, header.Name)
+		}
+		path := filepath.Join(dir, name)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			extracted += header.Size
+			if extracted > maxExtractedSize {
+				return ErrTooLarge
+			}
+			if err := extractFile(tr, path, header); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func extractFile(r io.Reader, path string, header *tar.Header) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(f, r, header.Size); err != nil {
+		f.Close()
+		return fmt.Errorf("%w: %s", ErrInvalidArchive, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Chtimes(path, header.ModTime, header.ModTime)
+}
@@ -0,0 +1,172 @@
+package saves
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"provider/settings"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func useSavesDir(t *testing.T) {
+	dir := settings.SavesDir
+	settings.SavesDir = t.TempDir()
+	t.Cleanup(func() { settings.SavesDir = dir })
+}
+
+func writeSave(t *testing.T, dir, name, content string) {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+}
+
+func readSave(t *testing.T, dir, name string) string {
+	content, err := ioutil.ReadFile(filepath.Join(dir, name))
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestSnapshotIsRestored(t *testing.T) {
+	useSavesDir(t)
+	v, err := NewVolume("alice", "hercules")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(settings.SavesDir, "alice", "hercules", "current"), v.Current())
+
+	// Nothing to keep before the game saves
+	archive, err := v.Snapshot(time.Now())
+	require.NoError(t, err)
+	assert.Nil(t, archive)
+
+	writeSave(t, v.Current(), "0/GAME1.SAV", "level 3")
+	writeSave(t, v.Current(), "1/options/keys.cfg", "jump=space")
+	archive, err = v.Snapshot(time.Now())
+	require.NoError(t, err)
+	require.NotNil(t, archive)
+
+	// Another provider restores the saves
+	useSavesDir(t)
+	other, err := NewVolume("alice", "hercules")
+	require.NoError(t, err)
+	writeSave(t, other.Current(), "0/GAME1.SAV", "level 1")
+	writeSave(t, other.Current(), "0/GAME2.SAV", "level 2")
+	require.NoError(t, other.Restore(archive))
+
+	assert.Equal(t, "level 3", readSave(t, other.Current(), "0/GAME1.SAV"))
+	assert.Equal(t, "jump=space", readSave(t, other.Current(), "1/options/keys.cfg"))
+	assert.NoFileExists(t, filepath.Join(other.Current(), "0", "GAME2.SAV"))
+}
+
+func TestOldSnapshotsArePruned(t *testing.T) {
+	useSavesDir(t)
+	snapshots := settings.SaveSnapshots
+	settings.SaveSnapshots = 2
+	defer func() { settings.SaveSnapshots = snapshots }()
+
+	v, err := NewVolume("alice", "tarzan")
+	require.NoError(t, err)
+	start := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		writeSave(t, v.Current(), "0/TARZAN.SAV", strings.Repeat("x", i+1))
+		_, err := v.Snapshot(start.Add(time.Duration(i) * time.Minute))
+		require.NoError(t, err)
+	}
+
+	paths, err := v.Snapshots()
+	require.NoError(t, err)
+	require.Len(t, paths, 2)
+	assert.Equal(t, "20220301T100100.000000000.tar.gz", filepath.Base(paths[0]))
+	assert.Equal(t, "20220301T100200.000000000.tar.gz", filepath.Base(paths[1]))
+}
+
+func TestInvalidIDsAreRejected(t *testing.T) {
+	useSavesDir(t)
+	_, err := NewVolume("../bob", "tarzan")
+	assert.ErrorIs(t, err, ErrInvalidID)
+	_, err = NewVolume("alice", "")
+	assert.ErrorIs(t, err, ErrInvalidID)
+}
+
+func TestRestoreRejectsEntriesOutsideOfSaves(t *testing.T) {
+	useSavesDir(t)
+	v, err := NewVolume("alice", "tarzan")
+	require.NoError(t, err)
+	writeSave(t, v.Current(), "0/TARZAN.SAV", "kept")
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "../../evil", Typeflag: tar.TypeReg, Size: 4, Mode: 0644}))
+	_, err = tw.Write([]byte("evil"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+
+	assert.ErrorIs(t, v.Restore(buf.Bytes()), ErrInvalidArchive)
+	assert.ErrorIs(t, v.Restore([]byte("not an archive")), ErrInvalidArchive)
+	assert.Equal(t, "kept", readSave(t, v.Current(), "0/TARZAN.SAV"))
+	assert.NoFileExists(t, filepath.Join(settings.SavesDir, "alice", "evil"))
+}
+
+func TestClientSyncsSavesOfSession(t *testing.T) {
+	stored := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		sessionID := strings.TrimPrefix(r.URL.Path, "/saves/")
+		switch r.Method {
+		case http.MethodPut:
+			stored[sessionID], _ = ioutil.ReadAll(r.Body)
+			w.WriteHeader(http.StatusNoContent)
+		case http.MethodGet:
+			archive, ok := stored[sessionID]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(archive)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(strings.TrimPrefix(server.URL, "http://"), "token")
+	archive, err := client.Download(context.Background(), "s1")
+	require.NoError(t, err)
+	assert.Nil(t, archive)
+
+	require.NoError(t, client.Upload("s1", []byte("saves")))
+	archive, err = client.Download(context.Background(), "s1")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("saves"), archive)
+
+	assert.Error(t, NewClient(strings.TrimPrefix(server.URL, "http://"), "other").Upload("s1", []byte("saves")))
+}
+
+func TestClientDownloadIsCancelled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := NewClient(strings.TrimPrefix(server.URL, "http://"), "token").Download(ctx, "s1")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), syncTimeout)
+}
@@ -0,0 +1,90 @@
+package saves
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"provider/settings"
+)
+
+const syncTimeout = 30 * time.Second
+
+// Client syncs saves with the coordinator, so players get them back when playing on another provider.
+// Saves are exchanged for a session, the coordinator knows which player and app it is about.
+type Client struct {
+	addr  string
+	token string
+	http  *http.Client
+}
+
+// NewClient creates a client of the coordinator at addr, authenticated with the token of the owner
+func NewClient(addr, token string) *Client {
+	return &Client{
+		addr:  addr,
+		token: token,
+		http:  &http.Client{Timeout: syncTimeout},
+	}
+}
+
+func (c *Client) request(ctx context.Context, method, sessionID string, body io.Reader) (*http.Response, error) {
+	u := url.URL{Scheme: "http", Host: c.addr, Path: "/saves/" + sessionID}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/gzip")
+	}
+
+	return c.http.Do(req)
+}
+
+// Download returns the latest saves of the player of the session for its app, nil if there are none.
+// It gives up once the context is done.
+func (c *Client) Download(ctx context.Context, sessionID string) ([]byte, error) {
+	resp, err := c.request(ctx, http.MethodGet, sessionID, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("coordinator: %s", resp.Status)
+	}
+
+	archive, err := ioutil.ReadAll(io.LimitReader(resp.Body, settings.MaxSaveSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(archive)) > settings.MaxSaveSize {
+		return nil, ErrTooLarge
+	}
+
+	return archive, nil
+}
+
+// Upload stores the saves of the session as the latest saves of its player for its app
+func (c *Client) Upload(sessionID string, archive []byte) error {
+	resp, err := c.request(context.Background(), http.MethodPut, sessionID, bytes.NewReader(archive))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("coordinator: %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+
+	"provider/constants"
+)
+
+var errSessionEnded = errors.New("session has ended")
+
+// StartError describes why a session couldn't be started.
+// Its exported fields are the payload of the error message sent to the player.
+type StartError struct {
+	Stage   constants.ErrorStage `json:"stage"`
+	Code    constants.ErrorCode  `json:"code"`
+	Message string               `json:"message"`
+	err     error
+}
+
+func newStartError(stage constants.ErrorStage, code constants.ErrorCode, message string, err error) *StartError {
+	return &StartError{
+		Stage:   stage,
+		Code:    code,
+		Message: message,
+		err:     err,
+	}
+}
+
+func (e *StartError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("%s/%s: %s", e.Stage, e.Code, e.Message)
+	}
+	return fmt.Sprintf("%s/%s: %s: %s", e.Stage, e.Code, e.Message, e.err)
+}
+
+func (e *StartError) Unwrap() error {
+	return e.err
+}
@@ -1,33 +1,124 @@
 package session
 
-import "sync"
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"provider/app/saves"
+)
+
+var (
+	ErrHubFull         = errors.New("maximum number of sessions reached")
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// SessionInfo tells the coordinator which sessions run on the provider
+type SessionInfo struct {
+	// Client ID of the player the session streams to
+	PlayerID  string `json:"playerID"`
+	SessionID string `json:"sessionID"`
+}
 
 type Hub struct {
 	sessions map[string]*Session
+	// Sessions of other players which spectators watch or guests play, by client ID of the spectator or guest
+	joined      map[string]*Session
+	maxSessions int
+	// Syncs saves of players with the coordinator, nil if saves are only kept on this provider
+	saveSync *saves.Client
 	rwMutex  sync.RWMutex
 }
 
-func NewHub() *Hub {
+// NewHub creates a hub which runs at most maxSessions sessions at the same time
+func NewHub(maxSessions int) *Hub {
 	return &Hub{
-		sessions: make(map[string]*Session),
-		rwMutex:  sync.RWMutex{},
+		sessions:    make(map[string]*Session),
+		joined:      make(map[string]*Session),
+		maxSessions: maxSessions,
+		rwMutex:     sync.RWMutex{},
 	}
 }
 
-func (h *Hub) AddSession(s *Session) {
+// AddSession registers the session unless the hub is full.
+// A new session of a player replaces the old one without taking another slot, the old one is ended.
+func (h *Hub) AddSession(s *Session) error {
 	h.rwMutex.Lock()
 	defer h.rwMutex.Unlock()
 
+	old, ok := h.sessions[s.playerID]
+	if !ok && len(h.sessions) >= h.maxSessions {
+		return ErrHubFull
+	}
+
 	h.sessions[s.playerID] = s
+	// Old session may still be starting, it ends once its start is done
+	if ok && old != s {
+		go old.replace()
+	}
+
+	return nil
+}
+
+// RemoveSession unregisters the session if it hasn't been replaced by another session of the same player
+func (h *Hub) RemoveSession(s *Session) {
+	h.rwMutex.Lock()
+	defer h.rwMutex.Unlock()
+
+	// The session may have been resumed by another client of the player
+	for playerID, cur := range h.sessions {
+		if cur == s {
+			delete(h.sessions, playerID)
+		}
+	}
+	for clientID, cur := range h.joined {
+		if cur == s {
+			delete(h.joined, clientID)
+		}
+	}
+}
+
+// JoinSession routes messages of the spectator or guest to the session of another player it takes part in
+func (h *Hub) JoinSession(clientID string, s *Session) {
+	h.rwMutex.Lock()
+	defer h.rwMutex.Unlock()
+
+	h.joined[clientID] = s
+}
+
+func (h *Hub) LeaveSession(clientID string) {
+	h.rwMutex.Lock()
+	defer h.rwMutex.Unlock()
+
+	delete(h.joined, clientID)
 }
 
-func (h *Hub) RemoveSession(playerID string) {
+// JoinedSession returns the session the spectator watches or the guest plays
+func (h *Hub) JoinedSession(clientID string) *Session {
+	h.rwMutex.RLock()
+	defer h.rwMutex.RUnlock()
+
+	return h.joined[clientID]
+}
+
+// ResumeSession moves the session of the previous client of the player to the client the player resumes it from
+func (h *Hub) ResumeSession(playerID, previousID string) (*Session, error) {
 	h.rwMutex.Lock()
 	defer h.rwMutex.Unlock()
 
-	if _, ok := h.sessions[playerID]; ok {
-		delete(h.sessions, playerID)
+	s, ok := h.sessions[previousID]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	if cur, ok := h.sessions[playerID]; ok && cur != s {
+		return nil, fmt.Errorf("client %s already has a session", playerID)
 	}
+
+	delete(h.sessions, previousID)
+	h.sessions[playerID] = s
+	s.setReceiver(playerID)
+
+	return s, nil
 }
 
 func (h *Hub) GetSession(playerID string) *Session {
@@ -40,3 +131,32 @@ func (h *Hub) GetSession(playerID string) *Session {
 
 	return nil
 }
+
+// SyncSaves makes sessions download saves of players before starting and upload them once ended
+func (h *Hub) SyncSaves(client *saves.Client) {
+	h.saveSync = client
+}
+
+func (h *Hub) Count() int {
+	h.rwMutex.RLock()
+	defer h.rwMutex.RUnlock()
+
+	return len(h.sessions)
+}
+
+// Sessions returns the sessions of the hub, which the provider announces when it reconnects to the coordinator
+func (h *Hub) Sessions() []SessionInfo {
+	h.rwMutex.RLock()
+	defer h.rwMutex.RUnlock()
+
+	sessions := make([]SessionInfo, 0, len(h.sessions))
+	for _, s := range h.sessions {
+		sessions = append(sessions, s.info())
+	}
+
+	return sessions
+}
+
+func (h *Hub) Capacity() int {
+	return h.maxSessions
+}
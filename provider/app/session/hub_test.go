@@ -0,0 +1,73 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHubRejectsSessionsOverCapacity(t *testing.T) {
+	hub := NewHub(2)
+
+	require.NoError(t, hub.AddSession(&Session{playerID: "a"}))
+	require.NoError(t, hub.AddSession(&Session{playerID: "b"}))
+	assert.ErrorIs(t, hub.AddSession(&Session{playerID: "c"}), ErrHubFull)
+	assert.Equal(t, 2, hub.Count())
+
+	hub.RemoveSession(hub.GetSession("a"))
+	assert.NoError(t, hub.AddSession(&Session{playerID: "c"}))
+	assert.Equal(t, 2, hub.Count())
+}
+
+func TestHubReplacesSessionOfSamePlayer(t *testing.T) {
+	hub := NewHub(1)
+
+	old := &Session{playerID: "a"}
+	require.NoError(t, hub.AddSession(old))
+
+	cur := &Session{playerID: "a"}
+	require.NoError(t, hub.AddSession(cur))
+	assert.Equal(t, 1, hub.Count())
+
+	// Closing the replaced session must not remove the new one
+	hub.RemoveSession(old)
+	assert.Same(t, cur, hub.GetSession("a"))
+}
+
+func TestHubResumesSessionFromAnotherClient(t *testing.T) {
+	hub := NewHub(2)
+
+	s := &Session{playerID: "a", receiverID: "a"}
+	require.NoError(t, hub.AddSession(s))
+	require.NoError(t, hub.AddSession(&Session{playerID: "b"}))
+
+	_, err := hub.ResumeSession("c", "unknown")
+	assert.ErrorIs(t, err, ErrSessionNotFound)
+	_, err = hub.ResumeSession("b", "a")
+	assert.Error(t, err, "another session of the client must not be replaced")
+
+	resumed, err := hub.ResumeSession("c", "a")
+	require.NoError(t, err)
+	assert.Same(t, s, resumed)
+	assert.Equal(t, "c", s.receiver())
+	assert.Nil(t, hub.GetSession("a"))
+	assert.Equal(t, 2, hub.Count())
+
+	hub.RemoveSession(s)
+	assert.Nil(t, hub.GetSession("c"))
+}
+
+func TestHubListsSessionsForCoordinator(t *testing.T) {
+	hub := NewHub(2)
+
+	require.NoError(t, hub.AddSession(&Session{playerID: "a", receiverID: "a", sessionID: "s1"}))
+	require.NoError(t, hub.AddSession(&Session{playerID: "b", receiverID: "b"}))
+	_, err := hub.ResumeSession("c", "a")
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []SessionInfo{
+		{PlayerID: "c", SessionID: "s1"},
+		{PlayerID: "b"},
+	}, hub.Sessions())
+}
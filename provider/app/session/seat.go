@@ -0,0 +1,191 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"provider/app/stream"
+	"provider/app/webrtc"
+	"provider/app/ws"
+	"provider/constants"
+)
+
+var errInvalidSeat = errors.New("invalid seat")
+
+// guest plays the session of the player in another seat, with its own connection and input
+type guest struct {
+	peer *webrtc.WebRTC
+	seat int
+	// Stops relaying streams to the guest
+	stopRelay func()
+}
+
+// SeatData is sent by the coordinator when the player seats a guest, again when the player moves the guest
+type SeatData struct {
+	// Client ID the player streams to
+	HostID string `json:"hostID"`
+	Seat   int    `json:"seat"`
+}
+
+// RejectGuest tells the guest that the session can't be joined and closes the session made for it
+func (s *Session) RejectGuest(err error) {
+	s.sendError(newStartError(constants.SeatStage, constants.SessionNotFoundError, "The game has ended", err))
+	s.close()
+}
+
+func (s *Session) isGuest(clientID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.guests[clientID]
+	return ok
+}
+
+// seatGuest connects the guest to the session in the seat, or moves the guest there if it's already connected.
+// The coordinator makes sure that seats of guests are free.
+func (s *Session) seatGuest(guestID string, seat int) error {
+	if s.join == nil {
+		return errSessionEnded
+	}
+	if seat <= stream.HostSeat || seat >= stream.MaxSeats {
+		return fmt.Errorf("%w %d", errInvalidSeat, seat)
+	}
+
+	s.mu.Lock()
+	if s.released {
+		s.mu.Unlock()
+		return errSessionEnded
+	}
+	if g, ok := s.guests[guestID]; ok {
+		if g != nil {
+			g.seat = seat
+			g.peer.SetSeat(seat)
+			log.Printf("[%s] Guest %s moved to seat %d\n", s.playerID, guestID, seat)
+		}
+		s.mu.Unlock()
+		return nil
+	}
+	// Reserves the guest while connecting
+	s.guests[guestID] = nil
+	s.mu.Unlock()
+
+	g, offer, err := s.join(guestID, seat)
+	if err != nil {
+		s.mu.Lock()
+		delete(s.guests, guestID)
+		s.mu.Unlock()
+		return err
+	}
+
+	s.mu.Lock()
+	if s.released {
+		s.mu.Unlock()
+		g.peer.StopClient()
+		g.stopRelay()
+		return errSessionEnded
+	}
+	s.guests[guestID] = g
+	s.mu.Unlock()
+
+	log.Printf("[%s] Guest %s joined in seat %d\n", s.playerID, guestID, seat)
+	s.send(ws.Message{
+		ReceiverID: guestID,
+		Type:       constants.SDPMessage,
+		Data:       offer,
+	})
+
+	return nil
+}
+
+// removeGuest disconnects the guest, the player keeps playing
+func (s *Session) removeGuest(guestID string) {
+	s.mu.Lock()
+	g, ok := s.guests[guestID]
+	if !ok || g == nil {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.guests, guestID)
+	s.mu.Unlock()
+
+	log.Printf("[%s] Guest %s left seat %d\n", s.playerID, guestID, g.seat)
+	g.peer.StopClient()
+	g.stopRelay()
+	s.hub.LeaveSession(guestID)
+
+	// Lets the coordinator know that the seat is free
+	s.send(ws.Message{
+		ReceiverID: guestID,
+		Type:       constants.EndedMessage,
+	})
+}
+
+// removeGuests disconnects all guests once the session ends, before their input stream is closed
+func (s *Session) removeGuests() {
+	s.mu.Lock()
+	ids := make([]string, 0, len(s.guests))
+	for id := range s.guests {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+
+	for _, id := range ids {
+		s.removeGuest(id)
+	}
+}
+
+func (s *Session) guestPeer(guestID string) *webrtc.WebRTC {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if g := s.guests[guestID]; g != nil {
+		return g.peer
+	}
+	return nil
+}
+
+// handleGuestMsg handles a message of a guest or about its seat, which never ends the session of the player
+func (s *Session) handleGuestMsg(msg *ws.Message) {
+	switch msg.Type {
+	case constants.SeatMessage:
+		var data SeatData
+		err := json.Unmarshal([]byte(msg.Data), &data)
+		if err == nil {
+			err = s.seatGuest(msg.SenderID, data.Seat)
+		}
+		if err != nil {
+			log.Printf("[%s] Couldn't seat guest %s: %s\n", s.playerID, msg.SenderID, err)
+			code, reason := constants.WebRTCSetupError, "Couldn't set up the stream"
+			if errors.Is(err, errInvalidSeat) {
+				code, reason = constants.SeatError, "There is no such seat"
+			} else if errors.Is(err, errSessionEnded) {
+				code, reason = constants.SessionNotFoundError, "The game has ended"
+			}
+			s.sendErrorTo(msg.SenderID, newStartError(constants.SeatStage, code, reason, err))
+			if !s.isGuest(msg.SenderID) {
+				s.hub.LeaveSession(msg.SenderID)
+			}
+		}
+	case constants.SDPMessage:
+		peer := s.guestPeer(msg.SenderID)
+		if peer == nil {
+			return
+		}
+		if err := peer.SetRemoteSDP(msg.Data); err != nil {
+			log.Printf("[%s] Couldn't set remote SDP of guest %s: %s\n", s.playerID, msg.SenderID, err)
+			s.removeGuest(msg.SenderID)
+		}
+	case constants.IceCandidateMessage:
+		peer := s.guestPeer(msg.SenderID)
+		if peer == nil {
+			return
+		}
+		if err := peer.AddCandidate(msg.Data); err != nil {
+			log.Printf("[%s] Couldn't set ICE candidate of guest %s: %s\n", s.playerID, msg.SenderID, err)
+		}
+	case constants.LeaveSeatMessage, constants.EndMessage:
+		s.removeGuest(msg.SenderID)
+	}
+}
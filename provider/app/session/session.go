@@ -1,24 +1,34 @@
 package session
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
+	"provider/app/bitrate"
+	"provider/app/codec"
+	"provider/app/saves"
 	"provider/app/stream"
 	"provider/app/vm"
 	"provider/app/webrtc"
 	"provider/app/ws"
 	"provider/constants"
+	"provider/pkg/rtpqueue"
 	"provider/pkg/socket"
 	"provider/settings"
 	"provider/utils"
 
-	"github.com/pion/rtp"
+	"github.com/pion/rtcp"
 )
 
+// Number of VM log lines to print when the VM fails
+const vmLogTail = 20
+
 type Session struct {
+	// Client ID of the player who started the session, which names its logs and VM
 	playerID  string
 	timeStart time.Time
 	hub       *Hub
@@ -28,16 +38,55 @@ type Session struct {
 	outBuf chan interface{}
 	// WS connection to coordinator service
 	wsConn *ws.Connection
+	// Backend running app VMs
+	vm vm.Backend
+	// Done once the session ends, which cancels the download of saves while it starts
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Releases resources of a started session
+	exit      func()
+	closeOnce sync.Once
+	// Connects a client of the player to the streams of the started session, again when the player resumes it
+	connect func() (*webrtc.WebRTC, string, error)
+	// Connects a spectator to copies of the streams of the started session
+	watch func(spectatorID string) (*spectator, string, error)
+	// Connects a guest to copies of the streams of the started session and to its input in the seat
+	join func(guestID string, seat int) (*guest, string, error)
+
+	// Client ID the session streams to, which changes when the player resumes the session from another client
+	receiverID string
+	// ID of the session record of the coordinator
+	sessionID string
+	// Connection to the player, nil once resources are released
+	peer     *webrtc.WebRTC
+	released bool
+	// Set once a new session of the player replaces this one, which the coordinator has already ended
+	replaced bool
+	// Ends the session unless the player reconnects in time
+	graceTimer *time.Timer
+	// Spectators by client ID, nil while they are being connected
+	spectators map[string]*spectator
+	// Guests playing in other seats than the player by client ID, nil while they are being connected
+	guests map[string]*guest
+	// Guards the fields above
+	mu sync.Mutex
 }
 
-func NewSession(playerID string, wsConn *ws.Connection, hub *Hub) *Session {
+func NewSession(playerID string, wsConn *ws.Connection, hub *Hub, vmBackend vm.Backend) *Session {
+	ctx, cancel := context.WithCancel(context.Background())
 	s := Session{
-		playerID:  playerID,
-		hub:       hub,
-		timeStart: time.Now(),
-		inpBuf:    make(chan *ws.Message),
-		outBuf:    make(chan interface{}),
-		wsConn:    wsConn,
+		playerID:   playerID,
+		receiverID: playerID,
+		hub:        hub,
+		timeStart:  time.Now(),
+		inpBuf:     make(chan *ws.Message),
+		outBuf:     make(chan interface{}),
+		wsConn:     wsConn,
+		vm:         vmBackend,
+		ctx:        ctx,
+		cancel:     cancel,
+		spectators: make(map[string]*spectator),
+		guests:     make(map[string]*guest),
 	}
 
 	go s.readMsg()
@@ -46,21 +95,82 @@ func NewSession(playerID string, wsConn *ws.Connection, hub *Hub) *Session {
 	return &s
 }
 
+// close may be called concurrently by the VM watcher, WebRTC and the message loop
 func (s *Session) close() {
+	s.closeOnce.Do(func() {
+		s.cancel()
+
+		// Lets the coordinator know that the session is over, unless it tracks the new session of the player instead
+		s.mu.Lock()
+		replaced := s.replaced
+		s.mu.Unlock()
+		if !replaced {
+			s.send(ws.Message{
+				Type: constants.EndedMessage,
+			})
+		}
+
+		close(s.inpBuf)
+		close(s.outBuf)
+
+		s.hub.RemoveSession(s)
+	})
+}
+
+// Reject tells the player that the session couldn't be admitted and closes it
+func (s *Session) Reject(err error) {
+	s.sendError(newStartError(constants.AdmissionStage, constants.BusyError, "The provider is busy, please choose another one", err))
+	s.close()
+}
+
+// RejectResume tells the player that the session can't be resumed and closes it
+func (s *Session) RejectResume(err error) {
+	s.sendError(newStartError(constants.ResumeStage, constants.SessionNotFoundError, "The session has ended", err))
+	s.close()
+}
+
+// replace ends the session once the player starts a new one, releasing its VM before it leaves the hub's slots
+func (s *Session) replace() {
+	s.mu.Lock()
+	s.replaced = true
+	s.mu.Unlock()
+
+	s.ReceiveMsg(&ws.Message{Type: constants.EndMessage})
+}
+
+func (s *Session) ReceiveMsg(msg *ws.Message) {
 	defer func() {
 		if r := recover(); r != nil {
-			// maybe close already closed channel
+			// maybe session has been closed
 		}
 	}()
 
-	close(s.inpBuf)
-	close(s.outBuf)
+	// The message loop is blocked while the session starts, ending it mustn't wait for the saves to be downloaded
+	if msg.Type == constants.EndMessage {
+		s.cancel()
+	}
+	s.inpBuf <- msg
+}
+
+func (s *Session) receiver() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	s.hub.RemoveSession(s.playerID)
+	return s.receiverID
 }
 
-func (s *Session) ReceiveMsg(msg *ws.Message) {
-	s.inpBuf <- msg
+func (s *Session) info() SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return SessionInfo{PlayerID: s.receiverID, SessionID: s.sessionID}
+}
+
+func (s *Session) setReceiver(playerID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.receiverID = playerID
 }
 
 func (s *Session) writeMsg() {
@@ -71,122 +181,629 @@ func (s *Session) writeMsg() {
 	}
 }
 
-func (s *Session) sendIceCandidate(candidate string) {
-	s.outBuf <- ws.Message{
-		ReceiverID: s.playerID,
-		Type:       constants.IceCandidateMessage,
-		Data:       candidate,
+// send sends the message to the current client of the player, unless it's addressed to a spectator
+func (s *Session) send(msg ws.Message) {
+	if msg.ReceiverID == "" {
+		msg.ReceiverID = s.receiver()
 	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			// maybe session has been closed
+		}
+	}()
+
+	s.outBuf <- msg
+}
+
+func (s *Session) sendIceCandidate(candidate string) {
+	s.send(ws.Message{
+		Type: constants.IceCandidateMessage,
+		Data: candidate,
+	})
 }
 
 func (s *Session) sendOffer(offer string) {
-	s.outBuf <- ws.Message{
-		ReceiverID: s.playerID,
-		Type:       constants.SDPMessage,
-		Data:       offer,
+	s.send(ws.Message{
+		Type: constants.SDPMessage,
+		Data: offer,
+	})
+}
+
+// sendStreaming tells the coordinator that the player is receiving the stream
+func (s *Session) sendStreaming() {
+	s.send(ws.Message{
+		Type: constants.StreamingMessage,
+	})
+}
+
+// sendError tells the player why the session failed
+func (s *Session) sendError(err error) {
+	s.sendErrorTo("", err)
+}
+
+// sendErrorTo tells the client why it can't take part in the session, the player if receiverID is empty
+func (s *Session) sendErrorTo(receiverID string, err error) {
+	startErr, ok := err.(*StartError)
+	if !ok {
+		startErr = newStartError("", "", "Unexpected error", err)
+	}
+
+	data, err := json.Marshal(startErr)
+	if err != nil {
+		log.Printf("[%s] Couldn't marshal error message: %s\n", s.playerID, err)
+		return
 	}
+
+	s.send(ws.Message{
+		ReceiverID: receiverID,
+		Type:       constants.ErrorMessage,
+		Data:       string(data),
+	})
 }
 
 type Configure struct {
 	Device string `json:"device"`
 	AppID  string `json:"appID"`
+	// Video codecs the browser of the player can decode, as codec names or MIME types
+	Codecs []string `json:"codecs"`
+	// Account of the player and record of the session, added by the coordinator.
+	// Saves of the player are only kept if the account is known.
+	AccountID string `json:"accountID"`
+	SessionID string `json:"sessionID"`
+}
+
+// ResumeData is sent by the coordinator once it has checked that the player may resume the session
+type ResumeData struct {
+	// Client ID the player started the session from
+	PreviousID string `json:"previousID"`
 }
 
 func (s *Session) start(conf *Configure) (*webrtc.WebRTC, error) {
+	s.mu.Lock()
+	s.sessionID = conf.SessionID
+	s.mu.Unlock()
+
+	videoCodec, err := codec.Negotiate(settings.VideoCodecs, conf.Codecs)
+	if err != nil {
+		log.Printf("[%s] Couldn't negotiate video codec: %s\n", s.playerID, err)
+		return nil, newStartError(constants.ConfigureStage, constants.CodecError, "Your browser can't play any video format of the provider", err)
+	}
+	log.Printf("[%s] Streaming video with %s\n", s.playerID, videoCodec)
+
 	// Create relaying streams
-	videoStream := make(chan *rtp.Packet, 100)
-	audioStream := make(chan *rtp.Packet, 100)
+	videoStream := rtpqueue.New(settings.VideoQueueSize, rtpqueue.KeyframeDetector(string(videoCodec)))
+	audioStream := rtpqueue.New(settings.AudioQueueSize, nil)
 	inputStream := make(chan *webrtc.Packet, 100)
 
+	// What is opened below is closed in reverse order if the session fails to start, listeners before the streams
+	// they write to. Once the relay is complete, releaseRelay closes it instead.
+	var cleanups []func()
+	relaying := false
+	defer func() {
+		if relaying {
+			return
+		}
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			cleanups[i]()
+		}
+	}()
+	cleanups = append(cleanups, videoStream.Close, audioStream.Close, func() { close(inputStream) })
+
 	videoListener, err := socket.NewRandomUDPListener()
 	if err != nil {
 		log.Printf("[%s] Couldn't create a UDP listener for video: %s\n", s.playerID, err)
-		return nil, err
+		return nil, newStartError(constants.RelayStage, constants.ListenerError, "Couldn't listen for video", err)
 	}
+	cleanups = append(cleanups, func() { videoListener.Close() })
 	videoRelayPort, err := socket.ExtractPort(videoListener.LocalAddr().String())
 	if err != nil {
 		log.Printf("[%s] Couldn't extract UDP port for video: %s\n", s.playerID, err)
-		return nil, err
+		return nil, newStartError(constants.RelayStage, constants.ListenerError, "Couldn't listen for video", err)
 	}
 	audioListener, err := socket.NewRandomUDPListener()
 	if err != nil {
 		log.Printf("[%s] Couldn't create a UDP listener for audio: %s\n", s.playerID, err)
-		return nil, err
+		return nil, newStartError(constants.RelayStage, constants.ListenerError, "Couldn't listen for audio", err)
 	}
+	cleanups = append(cleanups, func() { audioListener.Close() })
 	audioRelayPort, err := socket.ExtractPort(audioListener.LocalAddr().String())
 	if err != nil {
 		log.Printf("[%s] Couldn't extract UDP port for audio: %s\n", s.playerID, err)
-		return nil, err
+		return nil, newStartError(constants.RelayStage, constants.ListenerError, "Couldn't listen for audio", err)
 	}
 	syncListener, err := socket.NewRandomTCPListener()
 	if err != nil {
 		log.Printf("[%s] Couldn't create a TCP listener for wine: %s\n", s.playerID, err)
-		return nil, err
+		return nil, newStartError(constants.RelayStage, constants.ListenerError, "Couldn't listen for input", err)
 	}
+	cleanups = append(cleanups, func() { syncListener.Close() })
 	syncPort, err := socket.ExtractPort(syncListener.Addr().String())
 	if err != nil {
 		log.Printf("[%s] Couldn't extract TCP port for wine: %s\n", s.playerID, err)
-		return nil, err
+		return nil, newStartError(constants.RelayStage, constants.ListenerError, "Couldn't listen for input", err)
+	}
+
+	controlListener, err := socket.NewRandomTCPListener()
+	if err != nil {
+		log.Printf("[%s] Couldn't create a TCP listener for the encoder: %s\n", s.playerID, err)
+		return nil, newStartError(constants.RelayStage, constants.ListenerError, "Couldn't listen for the encoder", err)
+	}
+	cleanups = append(cleanups, func() { controlListener.Close() })
+	controlPort, err := socket.ExtractPort(controlListener.Addr().String())
+	if err != nil {
+		log.Printf("[%s] Couldn't extract TCP port for the encoder: %s\n", s.playerID, err)
+		return nil, newStartError(constants.RelayStage, constants.ListenerError, "Couldn't listen for the encoder", err)
 	}
 
 	log.Printf("[%s] Wait for video at port %d\n", s.playerID, videoRelayPort)
 	log.Printf("[%s] Wait for audio at port %d\n", s.playerID, audioRelayPort)
 	log.Printf("[%s] Wait for syncinput at port %d\n", s.playerID, syncPort)
+	log.Printf("[%s] Wait for encoder at port %d\n", s.playerID, controlPort)
+
+	appName := fmt.Sprintf("%s_%s", conf.AppID, conf.Device)
+	touchMapping, err := stream.LoadTouchMapping(appName)
+	if err != nil {
+		log.Printf("[%s] Couldn't load touch mapping, tapping clicks: %s\n", s.playerID, err)
+		touchMapping = stream.DefaultTouchMapping
+	}
+	seatMapping, err := stream.LoadSeatMapping(appName)
+	if err != nil {
+		log.Printf("[%s] Couldn't load seat mapping, guests only play with gamepads: %s\n", s.playerID, err)
+		seatMapping = stream.DefaultSeatMapping
+	}
 
 	relayer := stream.NewStreamRelayer(s.playerID,
 		videoStream, audioStream, inputStream,
-		videoListener, audioListener, syncListener)
+		videoListener, audioListener, syncListener, touchMapping, seatMapping)
 	if err := relayer.Start(); err != nil {
-		fmt.Printf("[%s] Couldn't start relaying streams: %s\n", s.playerID, err)
-		return nil, err
+		log.Printf("[%s] Couldn't start relaying streams: %s\n", s.playerID, err)
+		return nil, newStartError(constants.RelayStage, constants.RelayError, "Couldn't relay streams", err)
 	}
+	encoder := stream.NewEncoderControl(s.playerID, controlListener, settings.KeyframeRequestInterval)
+	encoder.Start()
+	cleanups = append(cleanups, relayer.Close, encoder.Close)
 
 	// Start VM
-	appName := fmt.Sprintf("%s_%s", conf.AppID, conf.Device)
-	appId := fmt.Sprintf("%s_%s", s.playerID, utils.RandString(6))
-	if err := vm.StartVM(appId, appName, videoRelayPort, audioRelayPort, syncPort); err != nil {
-		log.Printf("[%s] Error when start VM: %s\n", s.playerID, err)
-		return nil, err
+	vmConf := &vm.Config{
+		ID:             fmt.Sprintf("%s_%s", s.playerID, utils.RandString(6)),
+		AppName:        appName,
+		VideoRelayPort: videoRelayPort,
+		AudioRelayPort: audioRelayPort,
+		SyncPort:       syncPort,
+		ControlPort:    controlPort,
+		VideoBitrate:   settings.VideoBitrate,
+		VideoCodec:     string(videoCodec),
 	}
-
-	// Start WebRTC
-	webrtcConn, err := webrtc.NewWebRTC(s.playerID, videoStream, audioStream, inputStream)
-	if err != nil {
-		return nil, err
+	saveVolume := s.prepareSaves(conf)
+	if saveVolume != nil {
+		vmConf.SavesDir = saveVolume.Current()
 	}
-
-	onExitCb := func() {
-		log.Printf("[%s] Releasing allocated resources", s.playerID)
-		if err := vm.StopVM(appId, appName); err != nil {
-			log.Printf("[%s] Error when stopping VM: %s\n", s.playerID, err)
-		}
-
-		// Must close webrtc connection first to ensure no writing to closed inputStream
-		webrtcConn.StopClient()
-
+	releaseRelay := func() {
 		// Must close listeners before streams to ensure no writing to closed channels
 		audioListener.Close()
 		videoListener.Close()
 		syncListener.Close()
+		controlListener.Close()
 
-		close(videoStream)
-		close(audioStream)
+		videoStream.Close()
+		audioStream.Close()
 		close(inputStream)
 
 		relayer.Close()
+		encoder.Close()
+	}
+	relaying = true
+	if err := s.vm.Start(vmConf); err != nil {
+		log.Printf("[%s] Error when start VM: %s\n", s.playerID, err)
+		releaseRelay()
+		return nil, newStartError(constants.VMStage, constants.VMStartError, "Couldn't start the game", err)
+	}
+	if err := s.checkVM(vmConf.ID); err != nil {
+		log.Printf("[%s] VM is not running: %s\n", s.playerID, err)
+		s.stopVM(vmConf.ID)
+		releaseRelay()
+		return nil, newStartError(constants.VMStage, constants.VMExitedError, "The game stopped unexpectedly", err)
+	}
+
+	// Players get a new connection to the same streams when they resume the session
+	s.connect = func() (*webrtc.WebRTC, string, error) {
+		webrtcConn, err := webrtc.NewWebRTC(s.playerID, videoCodec, videoStream, audioStream, inputStream)
+		if err != nil {
+			return nil, "", err
+		}
+
+		// Adapt the bitrate to the link of the player
+		estimator := bitrate.NewEstimator(settings.VideoBitrate, settings.MinVideoBitrate, settings.MaxVideoBitrate, settings.BitrateUpdateInterval)
+		webrtcConn.OnVideoRTCP(func(packets []rtcp.Packet) {
+			if target, ok := estimator.Feed(packets, time.Now()); ok {
+				encoder.SetTarget(target)
+			}
+		})
+		webrtcConn.OnKeyframeRequest(encoder.RequestKeyframe)
+
+		offer, err := webrtcConn.StartClient(s.sendIceCandidate,
+			func() { s.onConnected(webrtcConn) },
+			func() { s.onDisconnected(webrtcConn) })
+		if err != nil {
+			webrtcConn.StopClient()
+			return nil, "", err
+		}
+
+		return webrtcConn, offer, nil
+	}
+
+	// Spectators get their own copies of the streams and have no input
+	s.watch = func(spectatorID string) (*spectator, string, error) {
+		streams := &stream.SpectatorStreams{
+			Video: rtpqueue.New(settings.VideoQueueSize, rtpqueue.KeyframeDetector(string(videoCodec))),
+			Audio: rtpqueue.New(settings.AudioQueueSize, nil),
+		}
+		webrtcConn, err := webrtc.NewWebRTC(s.playerID+"/"+spectatorID, videoCodec, streams.Video, streams.Audio, nil)
+		if err != nil {
+			return nil, "", err
+		}
+
+		// Keyframe requests of spectators would degrade the stream of the player they share the encoder with,
+		// so they start decoding and recover from losses at the next periodic keyframe
+		offer, err := webrtcConn.StartClient(
+			func(candidate string) {
+				s.send(ws.Message{ReceiverID: spectatorID, Type: constants.IceCandidateMessage, Data: candidate})
+			},
+			func() {},
+			func() { go s.removeSpectator(spectatorID) })
+		if err != nil {
+			webrtcConn.StopClient()
+			return nil, "", err
+		}
+		relayer.AddSpectator(spectatorID, streams)
+
+		return &spectator{
+			peer:      webrtcConn,
+			stopRelay: func() { relayer.RemoveSpectator(spectatorID) },
+		}, offer, nil
+	}
+
+	// Guests get their own copies of the streams, like spectators, and send input tagged by their seat
+	s.join = func(guestID string, seat int) (*guest, string, error) {
+		streams := &stream.SpectatorStreams{
+			Video: rtpqueue.New(settings.VideoQueueSize, rtpqueue.KeyframeDetector(string(videoCodec))),
+			Audio: rtpqueue.New(settings.AudioQueueSize, nil),
+		}
+		webrtcConn, err := webrtc.NewWebRTC(s.playerID+"/"+guestID, videoCodec, streams.Video, streams.Audio, inputStream)
+		if err != nil {
+			return nil, "", err
+		}
+		webrtcConn.SetSeat(seat)
+
+		// Like spectators, guests wait for the next periodic keyframe instead of asking the shared encoder
+		offer, err := webrtcConn.StartClient(
+			func(candidate string) {
+				s.send(ws.Message{ReceiverID: guestID, Type: constants.IceCandidateMessage, Data: candidate})
+			},
+			func() {},
+			func() { go s.removeGuest(guestID) })
+		if err != nil {
+			webrtcConn.StopClient()
+			return nil, "", err
+		}
+		relayer.AddSpectator(guestID, streams)
+
+		return &guest{
+			peer:      webrtcConn,
+			seat:      seat,
+			stopRelay: func() { relayer.RemoveSpectator(guestID) },
+		}, offer, nil
+	}
+
+	// Resources may be released either by the player not reconnecting in time, by the VM exiting on its own
+	// or by a failed negotiation
+	var exitOnce sync.Once
+	stopStats := make(chan struct{})
+	release := func() {
+		exitOnce.Do(func() {
+			log.Printf("[%s] Releasing allocated resources", s.playerID)
+			close(stopStats)
+			s.stopVM(vmConf.ID)
+			s.snapshotSaves(saveVolume, conf.SessionID)
+
+			// Must close webrtc connection first to ensure no writing to closed inputStream
+			if peer := s.releasePeer(); peer != nil {
+				peer.StopClient()
+			}
+			s.removeSpectators()
+			s.removeGuests()
+
+			releaseRelay()
+		})
+	}
+	onExitCb := func() {
+		release()
 		s.close()
 	}
-	offer, err := webrtcConn.StartClient(settings.VideoCodec, s.sendIceCandidate, onExitCb)
+	s.exit = onExitCb
+	go s.watchVM(vmConf.ID, onExitCb)
+
+	webrtcConn, offer, err := s.connect()
 	if err != nil {
 		fmt.Printf("[%s] Couldn't start webrtc client: %s\n", s.playerID, err)
+		release()
+		return nil, newStartError(constants.WebRTCStage, constants.WebRTCSetupError, "Couldn't set up the stream", err)
+	}
+	if _, ok := s.swapPeer(webrtcConn); !ok {
+		webrtcConn.StopClient()
+		return nil, newStartError(constants.VMStage, constants.VMExitedError, "The game stopped unexpectedly", errSessionEnded)
+	}
+
+	s.sendOffer(offer)
+	go s.reportStats(relayer, stopStats)
+
+	return webrtcConn, nil
+}
+
+// swapPeer makes the connection the one the session streams to and returns the previous one.
+// It returns false if resources have already been released.
+func (s *Session) swapPeer(peer *webrtc.WebRTC) (*webrtc.WebRTC, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.released {
+		return nil, false
+	}
+	old := s.peer
+	s.peer = peer
+
+	return old, true
+}
+
+func (s *Session) currentPeer() *webrtc.WebRTC {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.peer
+}
+
+// releasePeer returns the current connection, which must be stopped, and prevents new ones
+func (s *Session) releasePeer() *webrtc.WebRTC {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.released = true
+	if s.graceTimer != nil {
+		s.graceTimer.Stop()
+		s.graceTimer = nil
+	}
+	peer := s.peer
+	s.peer = nil
+
+	return peer
+}
+
+// onConnected is called whenever the player gets connected, including when the connection comes back by itself
+func (s *Session) onConnected(peer *webrtc.WebRTC) {
+	if s.currentPeer() != peer {
+		return
+	}
+
+	s.stopGrace()
+	s.sendStreaming()
+}
+
+// onDisconnected keeps the session running while the player may reconnect, e.g. after a Wi-Fi blip
+func (s *Session) onDisconnected(peer *webrtc.WebRTC) {
+	if s.currentPeer() != peer {
+		return
+	}
+
+	s.startGrace()
+}
+
+// startGrace ends the session after settings.ResumeGracePeriod unless the player reconnects in the meantime.
+// A grace period which has already started isn't extended.
+func (s *Session) startGrace() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.graceTimer != nil || s.released {
+		return
+	}
+	log.Printf("[%s] Lost connection to the player, waiting %s for the player to reconnect\n", s.playerID, settings.ResumeGracePeriod)
+	s.graceTimer = time.AfterFunc(settings.ResumeGracePeriod, func() {
+		log.Printf("[%s] Player didn't reconnect in time\n", s.playerID)
+		s.exit()
+	})
+}
+
+func (s *Session) stopGrace() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.graceTimer != nil {
+		log.Printf("[%s] Player reconnected\n", s.playerID)
+		s.graceTimer.Stop()
+		s.graceTimer = nil
+	}
+}
+
+// resume offers the streams of the session to the client the player resumed it from.
+// The player has to connect before the grace period ends, the previous connection is dropped.
+func (s *Session) resume() (*webrtc.WebRTC, error) {
+	if s.connect == nil {
+		return nil, errSessionEnded
+	}
+	log.Printf("[%s] Resuming session for client %s\n", s.playerID, s.receiver())
+
+	s.startGrace()
+	webrtcConn, offer, err := s.connect()
+	if err != nil {
 		return nil, err
 	}
+	old, ok := s.swapPeer(webrtcConn)
+	if !ok {
+		webrtcConn.StopClient()
+		return nil, errSessionEnded
+	}
+	if old != nil {
+		old.StopClient()
+	}
 
 	s.sendOffer(offer)
 
 	return webrtcConn, nil
 }
 
+// SessionStats is the stream quality of a session
+type SessionStats struct {
+	webrtc.Stats
+	Relay   stream.RelayStats   `json:"relay"`
+	Latency stream.LatencyStats `json:"latency"`
+}
+
+// reportStats sends the stream quality to the coordinator, and the input latency to the player, until stop is closed
+func (s *Session) reportStats(relayer *stream.StreamRelayer, stop <-chan struct{}) {
+	ticker := time.NewTicker(settings.StatsReportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			// Stats of the current connection of the player
+			webrtcConn := s.currentPeer()
+			if webrtcConn == nil {
+				continue
+			}
+			stats := webrtcConn.Stats()
+			latency := relayer.Latency(time.Duration(stats.RTT * float64(time.Second)))
+			s.sendLatency(webrtcConn, latency)
+
+			data, err := json.Marshal(SessionStats{
+				Stats:   stats,
+				Relay:   relayer.Stats(),
+				Latency: latency,
+			})
+			if err != nil {
+				log.Printf("[%s] Couldn't marshal session stats: %s\n", s.playerID, err)
+				continue
+			}
+
+			s.send(ws.Message{
+				Type: constants.SessionStatsMessage,
+				Data: string(data),
+			})
+		}
+	}
+}
+
+// sendLatency shows the player how responsive the session is, once inputs were measured
+func (s *Session) sendLatency(webrtcConn *webrtc.WebRTC, latency stream.LatencyStats) {
+	if latency.Samples == 0 {
+		return
+	}
+
+	data, err := json.Marshal(latency)
+	if err != nil {
+		log.Printf("[%s] Couldn't marshal latency: %s\n", s.playerID, err)
+		return
+	}
+	if err := webrtcConn.SendStatus(webrtc.Packet{Type: constants.LatencyReport, Data: string(data)}); err != nil {
+		log.Printf("[%s] Couldn't send latency to the player: %s\n", s.playerID, err)
+	}
+}
+
+// prepareSaves gets the saves of the player for the app, from the coordinator if they are synced.
+// The download is cancelled if the session ends meanwhile.
+// It returns nil if saves can't be kept, the session goes on without them.
+func (s *Session) prepareSaves(conf *Configure) *saves.Volume {
+	if conf.AccountID == "" {
+		return nil
+	}
+	volume, err := saves.NewVolume(conf.AccountID, conf.AppID)
+	if err != nil {
+		log.Printf("[%s] Couldn't prepare saves: %s\n", s.playerID, err)
+		return nil
+	}
+	if s.hub.saveSync == nil || conf.SessionID == "" {
+		return volume
+	}
+
+	// Saves of the coordinator are the latest ones, local snapshots are kept in case they aren't
+	archive, err := s.hub.saveSync.Download(s.ctx, conf.SessionID)
+	if err != nil {
+		log.Printf("[%s] Couldn't download saves, using the saves of this provider: %s\n", s.playerID, err)
+		return volume
+	}
+	if archive == nil {
+		return volume
+	}
+	if err := volume.Restore(archive); err != nil {
+		log.Printf("[%s] Couldn't restore saves, using the saves of this provider: %s\n", s.playerID, err)
+	}
+
+	return volume
+}
+
+// snapshotSaves keeps the saves of a stopped VM, and uploads them in the background if they are synced
+func (s *Session) snapshotSaves(volume *saves.Volume, sessionID string) {
+	if volume == nil {
+		return
+	}
+
+	archive, err := volume.Snapshot(time.Now())
+	if err != nil {
+		log.Printf("[%s] Couldn't snapshot saves: %s\n", s.playerID, err)
+		return
+	}
+	if archive == nil || s.hub.saveSync == nil || sessionID == "" {
+		return
+	}
+
+	go func() {
+		if err := s.hub.saveSync.Upload(sessionID, archive); err != nil {
+			log.Printf("[%s] Couldn't upload saves: %s\n", s.playerID, err)
+		}
+	}()
+}
+
+// checkVM makes sure that the VM is still running after being started
+func (s *Session) checkVM(id string) error {
+	status, err := s.vm.Status(id)
+	if err != nil {
+		return err
+	}
+	if status.State == vm.StateExited {
+		return fmt.Errorf("vm %s", status)
+	}
+
+	return nil
+}
+
+func (s *Session) stopVM(id string) {
+	if err := s.vm.Stop(id); err != nil {
+		log.Printf("[%s] Error when stopping VM: %s\n", s.playerID, err)
+	}
+}
+
+// watchVM waits for the VM to exit and releases the session if that happens before the session ends
+func (s *Session) watchVM(id string, onExitCb func()) {
+	status, err := s.vm.Wait(id)
+	if err != nil {
+		// VM has already been removed, i.e. the session has ended
+		return
+	}
+
+	log.Printf("[%s] VM %s\n", s.playerID, status)
+	if status.Failed() {
+		if logs, err := s.vm.Logs(id, vmLogTail); err == nil {
+			log.Printf("[%s] Last VM logs:\n%s\n", s.playerID, logs)
+		}
+		s.sendError(newStartError(constants.VMStage, constants.VMExitedError, "The game stopped unexpectedly", fmt.Errorf("vm %s", status)))
+	}
+
+	onExitCb()
+}
+
 func (s *Session) readMsg() {
 	var (
 		webrtcConn *webrtc.WebRTC
@@ -194,16 +811,29 @@ func (s *Session) readMsg() {
 	)
 
 	for msg := range s.inpBuf {
+		if msg.Type == constants.SpectateMessage || s.isSpectator(msg.SenderID) {
+			s.handleSpectatorMsg(msg)
+			continue
+		}
+		if msg.Type == constants.SeatMessage || s.isGuest(msg.SenderID) {
+			s.handleGuestMsg(msg)
+			continue
+		}
+
 		switch msg.Type {
 		case constants.StartMessage:
 			var conf Configure
 			if err := json.Unmarshal([]byte(msg.Data), &conf); err != nil {
 				log.Printf("[%s] Error when parse Start message: %s\n", s.playerID, err)
+				s.sendError(newStartError(constants.ConfigureStage, constants.InvalidConfigError, "Invalid start message", err))
+				s.close()
 				continue
 			}
 			webrtcConn, err = s.start(&conf)
 			if err != nil {
 				log.Printf("[%s] Error when starting new session: %s\n", s.playerID, err)
+				s.sendError(err)
+				s.close()
 				webrtcConn = nil
 			}
 		case constants.SDPMessage:
@@ -213,8 +843,33 @@ func (s *Session) readMsg() {
 			err := webrtcConn.SetRemoteSDP(msg.Data)
 			if err != nil {
 				log.Printf("[%s] Couldn't set remote SDP %s\n", s.playerID, err)
+				s.sendError(newStartError(constants.WebRTCStage, constants.SDPError, "Couldn't negotiate the stream", err))
+				s.exit()
 				webrtcConn = nil
 			}
+		case constants.ResumeMessage:
+			conn, err := s.resume()
+			if err != nil {
+				log.Printf("[%s] Couldn't resume session: %s\n", s.playerID, err)
+				s.sendError(newStartError(constants.ResumeStage, constants.WebRTCSetupError, "Couldn't resume the session", err))
+				if s.exit != nil {
+					s.exit()
+				} else {
+					s.close()
+				}
+				webrtcConn = nil
+				continue
+			}
+			webrtcConn = conn
+		case constants.EndMessage:
+			// Coordinator ends sessions of players who ran out of play time
+			log.Printf("[%s] Session ended by coordinator\n", s.playerID)
+			if s.exit != nil {
+				s.exit()
+			} else {
+				s.close()
+			}
+			webrtcConn = nil
 		case constants.IceCandidateMessage:
 			if webrtcConn == nil {
 				continue
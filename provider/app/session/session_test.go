@@ -0,0 +1,606 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"provider/app/codec"
+	"provider/app/saves"
+	"provider/app/vm"
+	"provider/app/ws"
+	"provider/constants"
+	"provider/settings"
+	"provider/utils"
+
+	"github.com/gorilla/websocket"
+	pionwebrtc "github.com/pion/webrtc/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	settings.SinglePort = 0
+	settings.StatsReportInterval = 50 * time.Millisecond
+}
+
+// newCoordinator starts a fake coordinator which collects all messages sent by the provider
+func newCoordinator(t *testing.T) (*ws.Connection, <-chan ws.Message) {
+	msgs := make(chan ws.Message, 100)
+	upgrader := websocket.Upgrader{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		for {
+			var msg ws.Message
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			msgs <- msg
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	conn, err := ws.Connect(strings.TrimPrefix(server.URL, "http://"), "token")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return conn, msgs
+}
+
+func TestStartFailsWhenVMCannotStart(t *testing.T) {
+	conn, _ := newCoordinator(t)
+	backend := vm.NewFakeBackend()
+	backend.StartErr = errors.New("no such image")
+	hub := NewHub(1)
+
+	s := NewSession("player", conn, hub, backend)
+	require.NoError(t, hub.AddSession(s))
+
+	webrtcConn, err := s.start(&Configure{Device: "pc", AppID: "tarzan"})
+	assert.Nil(t, webrtcConn)
+	assert.ErrorIs(t, err, backend.StartErr)
+	var startErr *StartError
+	require.ErrorAs(t, err, &startErr)
+	assert.Equal(t, constants.VMStage, startErr.Stage)
+	assert.Equal(t, constants.VMStartError, startErr.Code)
+	assert.Empty(t, backend.IDs())
+}
+
+func TestStartFailureIsReportedToPlayer(t *testing.T) {
+	conn, msgs := newCoordinator(t)
+	backend := vm.NewFakeBackend()
+	backend.StartErr = errors.New("no such image")
+	hub := NewHub(1)
+
+	s := NewSession("player", conn, hub, backend)
+	require.NoError(t, hub.AddSession(s))
+	s.ReceiveMsg(&ws.Message{
+		SenderID: "player",
+		Type:     constants.StartMessage,
+		Data:     `{"appID":"tarzan","device":"pc"}`,
+	})
+
+	select {
+	case msg := <-msgs:
+		assert.Equal(t, "player", msg.ReceiverID)
+		assert.Equal(t, constants.ErrorMessage, msg.Type)
+
+		var data StartError
+		require.NoError(t, json.Unmarshal([]byte(msg.Data), &data))
+		assert.Equal(t, constants.VMStage, data.Stage)
+		assert.Equal(t, constants.VMStartError, data.Code)
+		assert.NotEmpty(t, data.Message)
+	case <-time.After(5 * time.Second):
+		t.Fatal("error was not sent to the player")
+	}
+
+	assert.Eventually(t, func() bool {
+		return hub.GetSession("player") == nil
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestSessionStartsVM(t *testing.T) {
+	conn, msgs := newCoordinator(t)
+	backend := vm.NewFakeBackend()
+	hub := NewHub(1)
+
+	s := NewSession("player", conn, hub, backend)
+	require.NoError(t, hub.AddSession(s))
+
+	webrtcConn, err := s.start(&Configure{Device: "pc", AppID: "tarzan", Codecs: []string{"video/VP8", "video/H264"}})
+	require.NoError(t, err)
+	require.NotNil(t, webrtcConn)
+
+	ids := backend.IDs()
+	require.Len(t, ids, 1)
+	conf, err := backend.Config(ids[0])
+	require.NoError(t, err)
+	assert.Equal(t, "tarzan_pc", conf.AppName)
+	assert.True(t, strings.HasPrefix(conf.ID, "player_"))
+	assert.NotZero(t, conf.VideoRelayPort)
+	assert.NotZero(t, conf.AudioRelayPort)
+	assert.NotZero(t, conf.SyncPort)
+	assert.NotZero(t, conf.ControlPort)
+	assert.Equal(t, "h264", conf.VideoCodec)
+
+	select {
+	case msg := <-msgs:
+		assert.Equal(t, "player", msg.ReceiverID)
+		assert.Equal(t, constants.SDPMessage, msg.Type)
+
+		// Only the negotiated codec is offered
+		var offer pionwebrtc.SessionDescription
+		require.NoError(t, utils.DecodeBase64(msg.Data, &offer))
+		assert.Contains(t, offer.SDP, "H264/90000")
+		assert.NotContains(t, offer.SDP, "VP8/90000")
+	case <-time.After(5 * time.Second):
+		t.Fatal("offer was not sent to the player")
+	}
+}
+
+func TestStartFailsWithoutCommonCodec(t *testing.T) {
+	conn, _ := newCoordinator(t)
+	backend := vm.NewFakeBackend()
+	hub := NewHub(1)
+
+	s := NewSession("player", conn, hub, backend)
+	require.NoError(t, hub.AddSession(s))
+
+	_, err := s.start(&Configure{Device: "pc", AppID: "tarzan", Codecs: []string{"video/H265"}})
+	assert.ErrorIs(t, err, codec.ErrNoCommonCodec)
+	var startErr *StartError
+	require.ErrorAs(t, err, &startErr)
+	assert.Equal(t, constants.ConfigureStage, startErr.Stage)
+	assert.Equal(t, constants.CodecError, startErr.Code)
+	assert.Empty(t, backend.IDs())
+}
+
+func TestSessionEndsWhenVMExits(t *testing.T) {
+	conn, msgs := newCoordinator(t)
+	backend := vm.NewFakeBackend()
+	hub := NewHub(1)
+
+	s := NewSession("player", conn, hub, backend)
+	require.NoError(t, hub.AddSession(s))
+
+	_, err := s.start(&Configure{Device: "pc", AppID: "tarzan"})
+	require.NoError(t, err)
+
+	ids := backend.IDs()
+	require.Len(t, ids, 1)
+	require.NoError(t, backend.Exit(ids[0], 1, "wine: cannot find tarzan.exe"))
+
+	assert.Eventually(t, func() bool {
+		return hub.GetSession("player") == nil
+	}, 10*time.Second, 50*time.Millisecond)
+	assert.Empty(t, backend.IDs())
+
+	assert.Eventually(t, func() bool {
+		for {
+			select {
+			case msg := <-msgs:
+				if msg.Type == constants.EndedMessage {
+					return msg.ReceiverID == "player"
+				}
+			default:
+				return false
+			}
+		}
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestSessionEndsOnEndMessage(t *testing.T) {
+	conn, _ := newCoordinator(t)
+	backend := vm.NewFakeBackend()
+	hub := NewHub(1)
+
+	s := NewSession("player", conn, hub, backend)
+	require.NoError(t, hub.AddSession(s))
+	s.ReceiveMsg(&ws.Message{
+		SenderID: "player",
+		Type:     constants.StartMessage,
+		Data:     `{"appID":"tarzan","device":"pc"}`,
+	})
+	assert.Eventually(t, func() bool {
+		return len(backend.IDs()) == 1
+	}, 5*time.Second, 50*time.Millisecond)
+
+	s.ReceiveMsg(&ws.Message{SenderID: "player", Type: constants.EndMessage})
+
+	assert.Eventually(t, func() bool {
+		return hub.GetSession("player") == nil && len(backend.IDs()) == 0
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestNewSessionOfPlayerEndsTheOldOne(t *testing.T) {
+	conn, msgs := newCoordinator(t)
+	backend := vm.NewFakeBackend()
+	hub := NewHub(1)
+	start := &ws.Message{
+		SenderID: "player",
+		Type:     constants.StartMessage,
+		Data:     `{"appID":"tarzan","device":"pc"}`,
+	}
+
+	old := NewSession("player", conn, hub, backend)
+	require.NoError(t, hub.AddSession(old))
+	old.ReceiveMsg(start)
+	require.Eventually(t, func() bool {
+		return len(backend.IDs()) == 1
+	}, 5*time.Second, 50*time.Millisecond)
+	oldID := backend.IDs()[0]
+
+	s := NewSession("player", conn, hub, backend)
+	require.NoError(t, hub.AddSession(s))
+	s.ReceiveMsg(start)
+
+	// Only the VM of the new session keeps running, in the slot of the old one
+	assert.Eventually(t, func() bool {
+		ids := backend.IDs()
+		return len(ids) == 1 && ids[0] != oldID
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Never(t, func() bool {
+		return len(backend.IDs()) != 1
+	}, 300*time.Millisecond, 50*time.Millisecond)
+	assert.Same(t, s, hub.GetSession("player"))
+	assert.Equal(t, 1, hub.Count())
+
+	// Coordinator already tracks the new session, which must not be ended
+	for {
+		select {
+		case msg := <-msgs:
+			assert.NotEqual(t, constants.EndedMessage, msg.Type)
+			continue
+		default:
+		}
+		break
+	}
+}
+
+func TestSessionReportsStats(t *testing.T) {
+	conn, msgs := newCoordinator(t)
+	backend := vm.NewFakeBackend()
+	hub := NewHub(1)
+
+	s := NewSession("player", conn, hub, backend)
+	require.NoError(t, hub.AddSession(s))
+	_, err := s.start(&Configure{Device: "pc", AppID: "tarzan"})
+	require.NoError(t, err)
+	t.Cleanup(s.exit)
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-msgs:
+			if msg.Type != constants.SessionStatsMessage {
+				continue
+			}
+			assert.Equal(t, "player", msg.ReceiverID)
+			var stats SessionStats
+			require.NoError(t, json.Unmarshal([]byte(msg.Data), &stats))
+			assert.Zero(t, stats.Relay.VideoBacklog)
+			return
+		case <-timeout:
+			t.Fatal("stats were not reported")
+		}
+	}
+}
+
+func TestSessionSyncsSavesOfPlayer(t *testing.T) {
+	conn, _ := newCoordinator(t)
+	backend := vm.NewFakeBackend()
+	hub := NewHub(1)
+	savesDir := settings.SavesDir
+	settings.SavesDir = t.TempDir()
+	defer func() { settings.SavesDir = savesDir }()
+
+	// Saves of the player uploaded by another provider
+	remote := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(remote, "0"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(remote, "0", "TARZAN.SAV"), []byte("level 2"), 0644))
+	archive, _, err := saves.Archive(remote)
+	require.NoError(t, err)
+
+	uploaded := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/saves/s1", r.URL.Path)
+		if r.Method == http.MethodPut {
+			body, _ := ioutil.ReadAll(r.Body)
+			uploaded <- body
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Write(archive)
+	}))
+	defer server.Close()
+	hub.SyncSaves(saves.NewClient(strings.TrimPrefix(server.URL, "http://"), "token"))
+
+	s := NewSession("player", conn, hub, backend)
+	require.NoError(t, hub.AddSession(s))
+	_, err = s.start(&Configure{Device: "pc", AppID: "tarzan", AccountID: "alice", SessionID: "s1"})
+	require.NoError(t, err)
+
+	ids := backend.IDs()
+	require.Len(t, ids, 1)
+	conf, err := backend.Config(ids[0])
+	require.NoError(t, err)
+	save := filepath.Join(conf.SavesDir, "0", "TARZAN.SAV")
+	content, err := ioutil.ReadFile(save)
+	require.NoError(t, err)
+	assert.Equal(t, "level 2", string(content))
+
+	// The game saves its progress before the session ends
+	require.NoError(t, ioutil.WriteFile(save, []byte("level 3"), 0644))
+	s.exit()
+
+	select {
+	case body := <-uploaded:
+		restored := t.TempDir()
+		require.NoError(t, saves.Extract(body, restored))
+		content, err := ioutil.ReadFile(filepath.Join(restored, "0", "TARZAN.SAV"))
+		require.NoError(t, err)
+		assert.Equal(t, "level 3", string(content))
+	case <-time.After(5 * time.Second):
+		t.Fatal("saves were not uploaded")
+	}
+}
+
+func TestSessionEndsWhileDownloadingSaves(t *testing.T) {
+	conn, _ := newCoordinator(t)
+	backend := vm.NewFakeBackend()
+	hub := NewHub(1)
+	savesDir := settings.SavesDir
+	settings.SavesDir = t.TempDir()
+	defer func() { settings.SavesDir = savesDir }()
+
+	requested := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- struct{}{}
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	hub.SyncSaves(saves.NewClient(strings.TrimPrefix(server.URL, "http://"), "token"))
+
+	s := NewSession("player", conn, hub, backend)
+	require.NoError(t, hub.AddSession(s))
+	s.ReceiveMsg(&ws.Message{
+		SenderID: "player",
+		Type:     constants.StartMessage,
+		Data:     `{"appID":"tarzan","device":"pc","accountID":"alice","sessionID":"s1"}`,
+	})
+	<-requested
+
+	ended := make(chan struct{})
+	go func() {
+		s.ReceiveMsg(&ws.Message{SenderID: "player", Type: constants.EndMessage})
+		close(ended)
+	}()
+	select {
+	case <-ended:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the end message waited for the saves to be downloaded")
+	}
+	assert.Eventually(t, func() bool {
+		return hub.GetSession("player") == nil && len(backend.IDs()) == 0
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func useResumeGracePeriod(t *testing.T, d time.Duration) {
+	period := settings.ResumeGracePeriod
+	settings.ResumeGracePeriod = d
+	t.Cleanup(func() { settings.ResumeGracePeriod = period })
+}
+
+func TestSessionEndsWhenPlayerDoesNotReconnect(t *testing.T) {
+	useResumeGracePeriod(t, 200*time.Millisecond)
+	conn, _ := newCoordinator(t)
+	backend := vm.NewFakeBackend()
+	hub := NewHub(1)
+
+	s := NewSession("player", conn, hub, backend)
+	require.NoError(t, hub.AddSession(s))
+	webrtcConn, err := s.start(&Configure{Device: "pc", AppID: "tarzan"})
+	require.NoError(t, err)
+
+	s.onDisconnected(webrtcConn)
+	assert.Len(t, backend.IDs(), 1, "the VM keeps running while the player may reconnect")
+
+	assert.Eventually(t, func() bool {
+		return hub.GetSession("player") == nil && len(backend.IDs()) == 0
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestSessionKeepsRunningWhenPlayerReconnects(t *testing.T) {
+	useResumeGracePeriod(t, 200*time.Millisecond)
+	conn, _ := newCoordinator(t)
+	backend := vm.NewFakeBackend()
+	hub := NewHub(1)
+
+	s := NewSession("player", conn, hub, backend)
+	require.NoError(t, hub.AddSession(s))
+	webrtcConn, err := s.start(&Configure{Device: "pc", AppID: "tarzan"})
+	require.NoError(t, err)
+	t.Cleanup(s.exit)
+
+	s.onDisconnected(webrtcConn)
+	s.onConnected(webrtcConn)
+
+	time.Sleep(2 * settings.ResumeGracePeriod)
+	assert.Same(t, s, hub.GetSession("player"))
+	assert.Len(t, backend.IDs(), 1)
+}
+
+func TestSessionIsResumedFromAnotherClient(t *testing.T) {
+	conn, msgs := newCoordinator(t)
+	backend := vm.NewFakeBackend()
+	hub := NewHub(1)
+
+	s := NewSession("player", conn, hub, backend)
+	require.NoError(t, hub.AddSession(s))
+	webrtcConn, err := s.start(&Configure{Device: "pc", AppID: "tarzan"})
+	require.NoError(t, err)
+	t.Cleanup(s.exit)
+	ids := backend.IDs()
+
+	resumed, err := hub.ResumeSession("player2", "player")
+	require.NoError(t, err)
+	require.Same(t, s, resumed)
+	s.ReceiveMsg(&ws.Message{SenderID: "player2", Type: constants.ResumeMessage, Data: `{"previousID":"player"}`})
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-msgs:
+			if msg.Type != constants.SDPMessage || msg.ReceiverID != "player2" {
+				continue
+			}
+			// The new client gets a new connection to the same VM
+			assert.NotSame(t, webrtcConn, s.currentPeer())
+			assert.Equal(t, ids, backend.IDs())
+			assert.Nil(t, hub.GetSession("player"))
+			return
+		case <-timeout:
+			t.Fatal("offer was not sent to the new client")
+		}
+	}
+}
+
+// nextMsgTo returns the next message of the type sent to the receiver, skipping others
+func nextMsgTo(t *testing.T, msgs <-chan ws.Message, receiverID string, msgType constants.MessageType) ws.Message {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-msgs:
+			if msg.ReceiverID == receiverID && msg.Type == msgType {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("no %s message was sent to %s", msgType, receiverID)
+			return ws.Message{}
+		}
+	}
+}
+
+func TestSpectatorsWatchWithoutAffectingPlayer(t *testing.T) {
+	maxSpectators := settings.MaxSpectators
+	settings.MaxSpectators = 1
+	defer func() { settings.MaxSpectators = maxSpectators }()
+
+	conn, msgs := newCoordinator(t)
+	backend := vm.NewFakeBackend()
+	hub := NewHub(1)
+
+	s := NewSession("player", conn, hub, backend)
+	require.NoError(t, hub.AddSession(s))
+	_, err := s.start(&Configure{Device: "pc", AppID: "tarzan"})
+	require.NoError(t, err)
+	t.Cleanup(s.exit)
+
+	hub.JoinSession("friend", s)
+	s.ReceiveMsg(&ws.Message{SenderID: "friend", Type: constants.SpectateMessage, Data: `{"hostID":"player"}`})
+	offer := nextMsgTo(t, msgs, "friend", constants.SDPMessage)
+	assert.NotEmpty(t, offer.Data)
+	assert.Same(t, s, hub.JoinedSession("friend"))
+
+	// Spectators are limited
+	s.ReceiveMsg(&ws.Message{SenderID: "stranger", Type: constants.SpectateMessage, Data: `{"hostID":"player"}`})
+	rejected := nextMsgTo(t, msgs, "stranger", constants.ErrorMessage)
+	var startErr StartError
+	require.NoError(t, json.Unmarshal([]byte(rejected.Data), &startErr))
+	assert.Equal(t, constants.SpectatorLimitError, startErr.Code)
+
+	// Spectator leaving doesn't end the session of the player
+	s.ReceiveMsg(&ws.Message{SenderID: "friend", Type: constants.EndMessage})
+	nextMsgTo(t, msgs, "friend", constants.EndedMessage)
+	assert.False(t, s.isSpectator("friend"))
+	assert.Nil(t, hub.JoinedSession("friend"))
+	assert.Same(t, s, hub.GetSession("player"))
+	assert.Len(t, backend.IDs(), 1)
+}
+
+func TestSpectatorsLeaveWhenSessionEnds(t *testing.T) {
+	conn, msgs := newCoordinator(t)
+	backend := vm.NewFakeBackend()
+	hub := NewHub(1)
+
+	s := NewSession("player", conn, hub, backend)
+	require.NoError(t, hub.AddSession(s))
+	_, err := s.start(&Configure{Device: "pc", AppID: "tarzan"})
+	require.NoError(t, err)
+
+	hub.JoinSession("friend", s)
+	s.ReceiveMsg(&ws.Message{SenderID: "friend", Type: constants.SpectateMessage, Data: `{"hostID":"player"}`})
+	nextMsgTo(t, msgs, "friend", constants.SDPMessage)
+
+	s.ReceiveMsg(&ws.Message{SenderID: "player", Type: constants.EndMessage})
+	nextMsgTo(t, msgs, "friend", constants.EndedMessage)
+	assert.Eventually(t, func() bool {
+		return hub.GetSession("player") == nil && hub.JoinedSession("friend") == nil
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestGuestsPlayInTheirSeats(t *testing.T) {
+	conn, msgs := newCoordinator(t)
+	backend := vm.NewFakeBackend()
+	hub := NewHub(1)
+
+	s := NewSession("player", conn, hub, backend)
+	require.NoError(t, hub.AddSession(s))
+	_, err := s.start(&Configure{Device: "pc", AppID: "tarzan"})
+	require.NoError(t, err)
+
+	// The seat of the player can't be taken
+	hub.JoinSession("friend", s)
+	s.ReceiveMsg(&ws.Message{SenderID: "friend", Type: constants.SeatMessage, Data: `{"hostID":"player","seat":0}`})
+	rejected := nextMsgTo(t, msgs, "friend", constants.ErrorMessage)
+	var startErr StartError
+	require.NoError(t, json.Unmarshal([]byte(rejected.Data), &startErr))
+	assert.Equal(t, constants.SeatError, startErr.Code)
+	assert.Nil(t, hub.JoinedSession("friend"))
+
+	hub.JoinSession("friend", s)
+	s.ReceiveMsg(&ws.Message{SenderID: "friend", Type: constants.SeatMessage, Data: `{"hostID":"player","seat":1}`})
+	offer := nextMsgTo(t, msgs, "friend", constants.SDPMessage)
+	assert.NotEmpty(t, offer.Data)
+	assert.True(t, s.isGuest("friend"))
+
+	// Guests are moved without reconnecting
+	peer := s.guestPeer("friend")
+	s.ReceiveMsg(&ws.Message{SenderID: "friend", Type: constants.SeatMessage, Data: `{"hostID":"player","seat":2}`})
+	assert.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.guests["friend"].seat == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Same(t, peer, s.guestPeer("friend"))
+
+	// Kicked guests don't end the session of the player, which ends the sessions of the others
+	s.ReceiveMsg(&ws.Message{SenderID: "friend", Type: constants.LeaveSeatMessage})
+	nextMsgTo(t, msgs, "friend", constants.EndedMessage)
+	assert.Nil(t, hub.JoinedSession("friend"))
+	assert.Same(t, s, hub.GetSession("player"))
+
+	hub.JoinSession("other", s)
+	s.ReceiveMsg(&ws.Message{SenderID: "other", Type: constants.SeatMessage, Data: `{"hostID":"player","seat":1}`})
+	nextMsgTo(t, msgs, "other", constants.SDPMessage)
+	s.ReceiveMsg(&ws.Message{SenderID: "player", Type: constants.EndMessage})
+	nextMsgTo(t, msgs, "other", constants.EndedMessage)
+	assert.Eventually(t, func() bool {
+		return hub.GetSession("player") == nil && hub.JoinedSession("other") == nil
+	}, 5*time.Second, 50*time.Millisecond)
+}
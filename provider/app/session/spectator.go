@@ -0,0 +1,175 @@
+package session
+
+import (
+	"errors"
+	"log"
+
+	"provider/app/webrtc"
+	"provider/app/ws"
+	"provider/constants"
+	"provider/settings"
+)
+
+var errSpectatorLimit = errors.New("maximum number of spectators reached")
+
+// spectator watches the session of the player without sending input
+type spectator struct {
+	peer *webrtc.WebRTC
+	// Stops relaying streams to the spectator
+	stopRelay func()
+}
+
+// SpectateData is sent by the coordinator once the player invited the spectator
+type SpectateData struct {
+	// Client ID the player streams to
+	HostID string `json:"hostID"`
+}
+
+// RejectSpectator tells the spectator that the session can't be watched and closes the session made for it
+func (s *Session) RejectSpectator(err error) {
+	s.sendError(newStartError(constants.SpectateStage, constants.SessionNotFoundError, "The game has ended", err))
+	s.close()
+}
+
+func (s *Session) isSpectator(clientID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.spectators[clientID]
+	return ok
+}
+
+// addSpectator offers the streams of the session to the spectator, up to settings.MaxSpectators
+func (s *Session) addSpectator(spectatorID string) error {
+	if s.watch == nil {
+		return errSessionEnded
+	}
+
+	s.mu.Lock()
+	if s.released {
+		s.mu.Unlock()
+		return errSessionEnded
+	}
+	if _, ok := s.spectators[spectatorID]; ok {
+		s.mu.Unlock()
+		return nil
+	}
+	if len(s.spectators) >= settings.MaxSpectators {
+		s.mu.Unlock()
+		return errSpectatorLimit
+	}
+	// Reserves the seat while connecting
+	s.spectators[spectatorID] = nil
+	s.mu.Unlock()
+
+	sp, offer, err := s.watch(spectatorID)
+	if err != nil {
+		s.mu.Lock()
+		delete(s.spectators, spectatorID)
+		s.mu.Unlock()
+		return err
+	}
+
+	s.mu.Lock()
+	if s.released {
+		s.mu.Unlock()
+		sp.peer.StopClient()
+		sp.stopRelay()
+		return errSessionEnded
+	}
+	s.spectators[spectatorID] = sp
+	s.mu.Unlock()
+
+	log.Printf("[%s] Spectator %s joined\n", s.playerID, spectatorID)
+	s.send(ws.Message{
+		ReceiverID: spectatorID,
+		Type:       constants.SDPMessage,
+		Data:       offer,
+	})
+
+	return nil
+}
+
+// removeSpectator stops streaming to the spectator, the player keeps playing
+func (s *Session) removeSpectator(spectatorID string) {
+	s.mu.Lock()
+	sp, ok := s.spectators[spectatorID]
+	if !ok || sp == nil {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.spectators, spectatorID)
+	s.mu.Unlock()
+
+	log.Printf("[%s] Spectator %s left\n", s.playerID, spectatorID)
+	sp.peer.StopClient()
+	sp.stopRelay()
+	s.hub.LeaveSession(spectatorID)
+
+	// Lets the coordinator know that the spectator no longer watches
+	s.send(ws.Message{
+		ReceiverID: spectatorID,
+		Type:       constants.EndedMessage,
+	})
+}
+
+// removeSpectators stops streaming to all spectators once the session ends
+func (s *Session) removeSpectators() {
+	s.mu.Lock()
+	ids := make([]string, 0, len(s.spectators))
+	for id := range s.spectators {
+		ids = append(ids, id)
+	}
+	s.mu.Unlock()
+
+	for _, id := range ids {
+		s.removeSpectator(id)
+	}
+}
+
+func (s *Session) spectatorPeer(spectatorID string) *webrtc.WebRTC {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sp := s.spectators[spectatorID]; sp != nil {
+		return sp.peer
+	}
+	return nil
+}
+
+// handleSpectatorMsg handles a message of a spectator, which never affects the session of the player
+func (s *Session) handleSpectatorMsg(msg *ws.Message) {
+	switch msg.Type {
+	case constants.SpectateMessage:
+		if err := s.addSpectator(msg.SenderID); err != nil {
+			log.Printf("[%s] Couldn't add spectator %s: %s\n", s.playerID, msg.SenderID, err)
+			code, reason := constants.WebRTCSetupError, "Couldn't set up the stream"
+			if errors.Is(err, errSpectatorLimit) {
+				code, reason = constants.SpectatorLimitError, "Too many people are already watching"
+			} else if errors.Is(err, errSessionEnded) {
+				code, reason = constants.SessionNotFoundError, "The game has ended"
+			}
+			s.sendErrorTo(msg.SenderID, newStartError(constants.SpectateStage, code, reason, err))
+			s.hub.LeaveSession(msg.SenderID)
+		}
+	case constants.SDPMessage:
+		peer := s.spectatorPeer(msg.SenderID)
+		if peer == nil {
+			return
+		}
+		if err := peer.SetRemoteSDP(msg.Data); err != nil {
+			log.Printf("[%s] Couldn't set remote SDP of spectator %s: %s\n", s.playerID, msg.SenderID, err)
+			s.removeSpectator(msg.SenderID)
+		}
+	case constants.IceCandidateMessage:
+		peer := s.spectatorPeer(msg.SenderID)
+		if peer == nil {
+			return
+		}
+		if err := peer.AddCandidate(msg.Data); err != nil {
+			log.Printf("[%s] Couldn't set ICE candidate of spectator %s: %s\n", s.playerID, msg.SenderID, err)
+		}
+	case constants.StopSpectatingMessage, constants.EndMessage:
+		s.removeSpectator(msg.SenderID)
+	}
+}
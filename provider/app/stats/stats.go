@@ -47,6 +47,20 @@ func GetSysInfo() (*SysInfo, error) {
 	return &s, nil
 }
 
+// SessionCapacity estimates how many sessions can run at the same time
+// given the number of CPUs and GB of memory a single session needs
+func (s *SysInfo) SessionCapacity(cpuPerSession int, memPerSession float64) int {
+	capacity := s.CpuNum / cpuPerSession
+	if byMem := int(s.MemSize / memPerSession); byMem < capacity {
+		capacity = byMem
+	}
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	return capacity
+}
+
 type SysStats struct {
 	CpuPercent float64
 	MemPercent float64
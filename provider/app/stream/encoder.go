@@ -0,0 +1,143 @@
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"provider/app/bitrate"
+)
+
+// EncoderControl sends bitrate targets and keyframe requests to the encoder of the VM.
+// The encoder connects to the control listener and receives one JSON message per line.
+type EncoderControl struct {
+	logID    string
+	listener *net.TCPListener
+	conn     *net.TCPConn
+	// Last target, sent again whenever the encoder reconnects
+	target *bitrate.Target
+	// Minimum time between two keyframes requested by the player
+	keyframeInterval time.Duration
+	lastKeyframe     time.Time
+	// Set while a request which came too early waits for the interval to elapse
+	pendingKeyframe *time.Timer
+	closed          bool
+	mu              sync.Mutex
+}
+
+type keyframeRequest struct {
+	Keyframe bool `json:"keyframe"`
+}
+
+func NewEncoderControl(logID string, listener *net.TCPListener, keyframeInterval time.Duration) *EncoderControl {
+	return &EncoderControl{
+		logID:            logID,
+		listener:         listener,
+		keyframeInterval: keyframeInterval,
+	}
+}
+
+func (c *EncoderControl) Start() {
+	go func() {
+		for {
+			conn, err := c.listener.AcceptTCP()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				log.Printf("[%s] Couldn't accept encoder connection: %s\n", c.logID, err)
+				continue
+			}
+
+			c.mu.Lock()
+			if c.conn != nil {
+				_ = c.conn.Close()
+			}
+			c.conn = conn
+			if c.target != nil {
+				c.send(*c.target)
+			}
+			c.mu.Unlock()
+
+			log.Printf("[%s] Encoder connected\n", c.logID)
+		}
+	}()
+}
+
+// SetTarget tells the encoder to produce the target bitrate and resolution
+func (c *EncoderControl) SetTarget(target bitrate.Target) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	log.Printf("[%s] Target video bitrate %d bps at scale %.2f\n", c.logID, target.Bitrate, target.Scale)
+	c.target = &target
+	if c.conn != nil {
+		c.send(target)
+	}
+}
+
+// RequestKeyframe asks the encoder for a keyframe, so the player can decode the stream again after losing packets.
+// Requests are limited to one per keyframe interval, a request coming earlier is delayed until the interval elapses
+// and merged with the ones following it, so a lossy player can't make the encoder produce only keyframes.
+func (c *EncoderControl) RequestKeyframe() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || c.pendingKeyframe != nil {
+		return
+	}
+
+	wait := c.keyframeInterval - time.Since(c.lastKeyframe)
+	if wait <= 0 {
+		c.sendKeyframe()
+		return
+	}
+	c.pendingKeyframe = time.AfterFunc(wait, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		c.pendingKeyframe = nil
+		if !c.closed {
+			c.sendKeyframe()
+		}
+	})
+}
+
+func (c *EncoderControl) sendKeyframe() {
+	c.lastKeyframe = time.Now()
+	if c.conn == nil {
+		// The encoder starts with a keyframe once connected anyway
+		return
+	}
+	log.Printf("[%s] Requesting keyframe from encoder\n", c.logID)
+	c.send(keyframeRequest{Keyframe: true})
+}
+
+func (c *EncoderControl) send(msg interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("[%s] Couldn't marshal encoder message: %s\n", c.logID, err)
+		return
+	}
+
+	if _, err := c.conn.Write(append(data, '\n')); err != nil {
+		log.Printf("[%s] Couldn't send message to encoder: %s\n", c.logID, err)
+	}
+}
+
+func (c *EncoderControl) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	if c.pendingKeyframe != nil {
+		c.pendingKeyframe.Stop()
+		c.pendingKeyframe = nil
+	}
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+}
@@ -0,0 +1,62 @@
+package stream
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"provider/pkg/socket"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// connectEncoder starts an encoder control and connects to it as the encoder of the VM would
+func connectEncoder(t *testing.T, keyframeInterval time.Duration) (*EncoderControl, *bufio.Reader) {
+	listener, err := socket.NewRandomTCPListener()
+	require.NoError(t, err)
+
+	control := NewEncoderControl("test", listener, keyframeInterval)
+	control.Start()
+	t.Cleanup(func() {
+		control.Close()
+		listener.Close()
+	})
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	// Wait for the control to register the connection
+	require.Eventually(t, func() bool {
+		control.mu.Lock()
+		defer control.mu.Unlock()
+		return control.conn != nil
+	}, time.Second, 10*time.Millisecond)
+
+	return control, bufio.NewReader(conn)
+}
+
+func TestKeyframeRequestsAreRateLimited(t *testing.T) {
+	control, encoder := connectEncoder(t, 200*time.Millisecond)
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		control.RequestKeyframe()
+	}
+
+	line, err := encoder.ReadString('\n')
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"keyframe": true}`, line)
+
+	// Further requests are merged into a single delayed one
+	line, err = encoder.ReadString('\n')
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"keyframe": true}`, line)
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+
+	control.mu.Lock()
+	assert.Nil(t, control.pendingKeyframe)
+	control.mu.Unlock()
+}
@@ -0,0 +1,77 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"provider/pkg/inputproto"
+)
+
+// Gamepads follow the standard mapping of the browser Gamepad API:
+// buttons 0-3 are A, B, X, Y, 4-5 the bumpers, 8-9 back and start, 10-11 the stick buttons, 12-15 the d-pad and 16 the guide button.
+// Triggers, which browsers report as buttons 6 and 7, are sent as triggers 0 and 1 instead.
+// Axes 0-1 are the left stick and 2-3 the right stick.
+const (
+	MaxGamepads     = 4
+	gamepadButtons  = 17
+	gamepadAxes     = 4
+	gamepadTriggers = 2
+)
+
+type gamepadPayload struct {
+	Pad   int     `json:"pad"`
+	Index int     `json:"index"`
+	Value float64 `json:"value"`
+}
+
+// parseGamepadInput parses a change of state of one of the pads of the player
+func parseGamepadInput(t inputproto.EventType, jsonPayload string, ev *inputproto.Event) error {
+	var p gamepadPayload
+	if err := json.Unmarshal([]byte(jsonPayload), &p); err != nil {
+		return err
+	}
+	if p.Pad < 0 || p.Pad >= MaxGamepads {
+		return fmt.Errorf("pad %d out of range", p.Pad)
+	}
+	if p.Index < 0 || p.Index > math.MaxUint8 {
+		return fmt.Errorf("index %d of pad %d out of range", p.Index, p.Pad)
+	}
+
+	*ev = inputproto.Event{Type: t, Pad: uint8(p.Pad), Index: uint8(p.Index), Value: p.Value}
+	return validateGamepad(ev)
+}
+
+// validateGamepad checks that the pad and its button, axis or trigger exist, and clamps the value to its range
+func validateGamepad(ev *inputproto.Event) error {
+	if ev.Pad >= MaxGamepads {
+		return fmt.Errorf("pad %d out of range", ev.Pad)
+	}
+	if math.IsNaN(ev.Value) {
+		return fmt.Errorf("invalid value of pad %d", ev.Pad)
+	}
+
+	count := 0
+	switch ev.Type {
+	case inputproto.GamepadConnect, inputproto.GamepadDisconnect:
+		ev.Index = 0
+		ev.Value = 0
+		return nil
+	case inputproto.GamepadButton:
+		ev.Value = math.Max(0, math.Min(1, ev.Value))
+		count = gamepadButtons
+	case inputproto.GamepadAxis:
+		ev.Value = math.Max(-1, math.Min(1, ev.Value))
+		count = gamepadAxes
+	case inputproto.GamepadTrigger:
+		ev.Value = math.Max(0, math.Min(1, ev.Value))
+		count = gamepadTriggers
+	default:
+		return fmt.Errorf("unknown gamepad event %d", ev.Type)
+	}
+	if int(ev.Index) >= count {
+		return fmt.Errorf("index %d of pad %d out of range", ev.Index, ev.Pad)
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package stream
+
+import (
+	"testing"
+
+	"provider/app/webrtc"
+	"provider/constants"
+	"provider/pkg/inputproto"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGamepadEvents(t *testing.T) {
+	tests := []struct {
+		packetType string
+		payload    string
+		event      inputproto.Event
+	}{
+		{constants.GamepadConnect, `{"pad":1}`, inputproto.Event{Type: inputproto.GamepadConnect, Pad: 1}},
+		{constants.GamepadDisconnect, `{"pad":3}`, inputproto.Event{Type: inputproto.GamepadDisconnect, Pad: 3}},
+		{constants.GamepadButton, `{"pad":0,"index":16,"value":1}`, inputproto.Event{Type: inputproto.GamepadButton, Index: 16, Value: 1}},
+		{constants.GamepadAxis, `{"pad":2,"index":1,"value":-0.25}`, inputproto.Event{Type: inputproto.GamepadAxis, Pad: 2, Index: 1, Value: -0.25}},
+		// Values are clamped to their range
+		{constants.GamepadAxis, `{"pad":0,"index":3,"value":-1.5}`, inputproto.Event{Type: inputproto.GamepadAxis, Index: 3, Value: -1}},
+		{constants.GamepadTrigger, `{"pad":0,"index":1,"value":0.5}`, inputproto.Event{Type: inputproto.GamepadTrigger, Index: 1, Value: 0.5}},
+	}
+	for _, test := range tests {
+		var ev inputproto.Event
+		require.NoError(t, parsePacket(&webrtc.Packet{Type: test.packetType, Data: test.payload}, &ev), test.payload)
+		assert.Equal(t, test.event, ev)
+	}
+}
+
+func TestGamepadInputOutOfRange(t *testing.T) {
+	for _, test := range []struct {
+		packetType string
+		payload    string
+	}{
+		{constants.GamepadConnect, `{"pad":4}`},
+		{constants.GamepadButton, `{"pad":0,"index":17,"value":1}`},
+		{constants.GamepadAxis, `{"pad":0,"index":-1,"value":0}`},
+		{constants.GamepadTrigger, `{"pad":0,"index":2,"value":1}`},
+		{constants.GamepadButton, `{"pad":0,"index":0`},
+	} {
+		var ev inputproto.Event
+		assert.Error(t, parsePacket(&webrtc.Packet{Type: test.packetType, Data: test.payload}, &ev), test.payload)
+	}
+
+	// Binary frames are checked too
+	assert.Error(t, validateGamepad(&inputproto.Event{Type: inputproto.GamepadAxis, Pad: 0, Index: 4}))
+	assert.Error(t, validateGamepad(&inputproto.Event{Type: inputproto.GamepadConnect, Pad: 7}))
+}
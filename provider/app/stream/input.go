@@ -0,0 +1,173 @@
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"provider/app/webrtc"
+	"provider/constants"
+	"provider/pkg/inputproto"
+)
+
+// Packet types of players using the JSON protocol
+var packetEvents = map[string]inputproto.EventType{
+	constants.KeyDown:           inputproto.KeyDown,
+	constants.KeyUp:             inputproto.KeyUp,
+	constants.MouseMove:         inputproto.MouseMove,
+	constants.MouseDown:         inputproto.MouseDown,
+	constants.MouseUp:           inputproto.MouseUp,
+	constants.MouseWheel:        inputproto.Wheel,
+	constants.MouseMoveRelative: inputproto.MoveRelative,
+	constants.GamepadConnect:    inputproto.GamepadConnect,
+	constants.GamepadDisconnect: inputproto.GamepadDisconnect,
+	constants.GamepadButton:     inputproto.GamepadButton,
+	constants.GamepadAxis:       inputproto.GamepadAxis,
+	constants.GamepadTrigger:    inputproto.GamepadTrigger,
+	constants.TouchStart:        inputproto.TouchStart,
+	constants.TouchMove:         inputproto.TouchMove,
+	constants.TouchEnd:          inputproto.TouchEnd,
+}
+
+// parsePacket converts a packet of the JSON protocol into the event of the binary protocol
+func parsePacket(packet *webrtc.Packet, ev *inputproto.Event) error {
+	t, ok := packetEvents[packet.Type]
+	if !ok {
+		return fmt.Errorf("unknown packet %s", packet.Type)
+	}
+
+	switch t {
+	case inputproto.KeyDown, inputproto.KeyUp:
+		return parseKey(t, packet.Data, ev)
+	case inputproto.MouseMove, inputproto.MouseDown, inputproto.MouseUp:
+		return parseMouseEvent(t, packet.Data, ev)
+	case inputproto.Wheel:
+		return parseWheel(packet.Data, ev)
+	case inputproto.MoveRelative:
+		return parseRelativeMove(packet.Data, ev)
+	case inputproto.TouchStart, inputproto.TouchMove, inputproto.TouchEnd:
+		return parseTouch(t, packet.Data, ev)
+	}
+
+	return parseGamepadInput(t, packet.Data, ev)
+}
+
+// handleFrames simulates the events of a message of the binary protocol, which may hold several frames
+func (s *StreamRelayer) handleFrames(frames []byte) {
+	var ev inputproto.Event
+	for len(frames) > 0 {
+		n, err := inputproto.Decode(frames, &ev)
+		if err != nil {
+			log.Printf("[%s] Couldn't decode input frame: %s\n", s.logID, err)
+			return
+		}
+		frames = frames[n:]
+
+		s.simulate(&ev)
+	}
+}
+
+// simulate checks the event and sends what it does to syncinput, as an event of the seat of the player who sent it
+func (s *StreamRelayer) simulate(ev *inputproto.Event) {
+	if !s.seats.tag(s.seat, ev) {
+		return
+	}
+
+	switch ev.Type {
+	case inputproto.TouchStart, inputproto.TouchMove, inputproto.TouchEnd:
+		s.send(s.touch.handle(ev)...)
+		return
+	case inputproto.GamepadConnect, inputproto.GamepadDisconnect, inputproto.GamepadButton, inputproto.GamepadAxis, inputproto.GamepadTrigger:
+		if err := validateGamepad(ev); err != nil {
+			log.Printf("[%s] Invalid gamepad event: %s\n", s.logID, err)
+			return
+		}
+	case inputproto.Marker:
+		// Markers are only sent by the provider
+		return
+	case inputproto.MouseMove, inputproto.MouseDown, inputproto.MouseUp, inputproto.ButtonDown, inputproto.ButtonUp:
+		if ev.Button > forwardButton {
+			log.Printf("[%s] Unknown mouse button %d\n", s.logID, ev.Button)
+			return
+		}
+	}
+
+	s.send(*ev)
+}
+
+// send writes the events to syncinput at once, as frames of the binary protocol.
+// Some inputs are followed by a marker to measure their latency.
+func (s *StreamRelayer) send(events ...inputproto.Event) {
+	if s.wineConn == nil || len(events) == 0 {
+		return
+	}
+
+	s.inputBuf = s.inputBuf[:0]
+	for i := range events {
+		s.inputBuf = inputproto.Append(s.inputBuf, &events[i])
+	}
+	if id, ok := s.latency.mark(s.receivedAt, time.Now()); ok {
+		s.inputBuf = inputproto.Append(s.inputBuf, &inputproto.Event{Type: inputproto.Marker, Marker: id})
+	}
+	if _, err := s.wineConn.Write(s.inputBuf); err != nil {
+		log.Printf("[%s] Couldn't send input to wine: %s\n", s.logID, err)
+	}
+}
+
+type keydownPayload struct {
+	KeyCode int `json:"keycode"`
+}
+
+func parseKey(t inputproto.EventType, jsonPayload string, ev *inputproto.Event) error {
+	p := &keydownPayload{}
+	if err := json.Unmarshal([]byte(jsonPayload), &p); err != nil {
+		return err
+	}
+	if p.KeyCode < 0 || p.KeyCode > 0xFFFF {
+		return fmt.Errorf("key code %d out of range", p.KeyCode)
+	}
+
+	*ev = keyEvent(p.KeyCode, t == inputproto.KeyDown)
+	return nil
+}
+
+func keyEvent(keyCode int, down bool) inputproto.Event {
+	if down {
+		return inputproto.Event{Type: inputproto.KeyDown, Key: uint16(keyCode)}
+	}
+	return inputproto.Event{Type: inputproto.KeyUp, Key: uint16(keyCode)}
+}
+
+// readEchoes reads the markers echoed by syncinput until the connection is closed
+func (s *StreamRelayer) readEchoes(conn *net.TCPConn) {
+	buf := make([]byte, 256)
+	n := 0
+	var ev inputproto.Event
+	for {
+		read, err := conn.Read(buf[n:])
+		if err != nil {
+			return
+		}
+		frames := buf[:n+read]
+
+		for len(frames) > 0 {
+			size, err := inputproto.Decode(frames, &ev)
+			if errors.Is(err, inputproto.ErrShortFrame) {
+				break
+			} else if err != nil {
+				log.Printf("[%s] Couldn't decode syncinput frame: %s\n", s.logID, err)
+				frames = nil
+				break
+			}
+			frames = frames[size:]
+
+			if ev.Type == inputproto.Marker {
+				s.latency.onEcho(ev.Marker, time.Now())
+			}
+		}
+		n = copy(buf, frames)
+	}
+}
@@ -0,0 +1,26 @@
+//go:build go1.18
+// +build go1.18
+
+// Fuzz tests need Go 1.18, older versions build the tests without them
+
+package stream
+
+import (
+	"io"
+	"testing"
+
+	"provider/pkg/inputproto"
+)
+
+func FuzzHandleFrames(f *testing.F) {
+	f.Add(inputproto.Append(nil, &inputproto.Event{Type: inputproto.TouchStart, X: 0.5, Y: 0.5}))
+	f.Add(inputproto.Append(nil, &inputproto.Event{Type: inputproto.GamepadAxis, Pad: 3, Index: 3, Value: 1}))
+	f.Add([]byte{inputproto.Version, byte(inputproto.MouseDown), 7, 0, 0, 0, 0})
+
+	s, syncinput := relayerWithSyncinput(f)
+	go io.Copy(io.Discard, syncinput)
+
+	f.Fuzz(func(t *testing.T, frames []byte) {
+		s.handleFrames(frames)
+	})
+}
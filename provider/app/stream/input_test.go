@@ -0,0 +1,60 @@
+package stream
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"provider/app/webrtc"
+	"provider/constants"
+	"provider/pkg/inputproto"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// relayerWithSyncinput returns a relayer whose syncinput connection is read by the returned end
+func relayerWithSyncinput(t testing.TB) (*StreamRelayer, *net.TCPConn) {
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer listener.Close()
+
+	conn, err := net.DialTCP("tcp", nil, listener.Addr().(*net.TCPAddr))
+	require.NoError(t, err)
+	syncinput, err := listener.AcceptTCP()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+		syncinput.Close()
+	})
+
+	s := NewStreamRelayer("test", nil, nil, nil, nil, nil, nil, DefaultTouchMapping, DefaultSeatMapping)
+	s.wineConn = conn
+	return s, syncinput
+}
+
+func TestJSONAndBinaryInputAreForwardedAsFrames(t *testing.T) {
+	s, syncinput := relayerWithSyncinput(t)
+
+	events := make(chan *webrtc.Packet, 3)
+	events <- &webrtc.Packet{Type: constants.KeyDown, Data: `{"keyCode":65}`}
+	events <- &webrtc.Packet{Frames: append(
+		inputproto.Append(nil, &inputproto.Event{Type: inputproto.Wheel, DY: -120}),
+		inputproto.Append(nil, &inputproto.Event{Type: inputproto.ButtonDown, Button: rightButton})...,
+	)}
+	// Invalid packets are dropped
+	events <- &webrtc.Packet{Type: constants.MouseDown, Data: `{"button":9}`}
+	close(events)
+	s.eventStream = events
+	s.handleAppEvents()
+
+	want := inputproto.Append(nil, &inputproto.Event{Type: inputproto.KeyDown, Key: 65})
+	// The first input is probed, the next ones wait for its marker to be echoed
+	want = inputproto.Append(want, &inputproto.Event{Type: inputproto.Marker, Marker: 1})
+	want = inputproto.Append(want, &inputproto.Event{Type: inputproto.Wheel, DY: -120})
+	want = inputproto.Append(want, &inputproto.Event{Type: inputproto.ButtonDown, Button: rightButton})
+	got := make([]byte, len(want))
+	_, err := io.ReadFull(syncinput, got)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
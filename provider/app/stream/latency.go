@@ -0,0 +1,148 @@
+package stream
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"provider/settings"
+)
+
+// Number of latency samples percentiles are computed on, i.e. the last minute or so of play
+const latencyWindowSize = 256
+
+// Probes whose marker is never echoed, e.g. because syncinput reconnected, are given up after this delay
+const probeTimeout = 2 * time.Second
+
+// LatencyStats are percentiles of the input latency of a session in milliseconds
+type LatencyStats struct {
+	Samples int `json:"samples"`
+	// From the receipt of an input by the provider to its simulation in the VM
+	Input Percentiles `json:"input"`
+	// From the receipt of an input to the first frame captured after its simulation
+	InputToFrame Percentiles `json:"inputToFrame"`
+	// InputToFrame plus the round trip time to the player, i.e. from the player's input to the frame reaching the player.
+	// Decoding and displaying the frame aren't included.
+	EndToEnd Percentiles `json:"endToEnd"`
+}
+
+type Percentiles struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+}
+
+// latencyWindow keeps the latest samples
+type latencyWindow struct {
+	samples []time.Duration
+	next    int
+}
+
+func (w *latencyWindow) add(d time.Duration) {
+	if len(w.samples) < latencyWindowSize {
+		w.samples = append(w.samples, d)
+		return
+	}
+	w.samples[w.next] = d
+	w.next = (w.next + 1) % latencyWindowSize
+}
+
+// percentiles returns percentiles of the samples shifted by offset
+func (w *latencyWindow) percentiles(offset time.Duration) Percentiles {
+	if len(w.samples) == 0 {
+		return Percentiles{}
+	}
+	sorted := append([]time.Duration(nil), w.samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	at := func(p float64) float64 {
+		d := sorted[int(p*float64(len(sorted)-1))] + offset
+		return float64(d) / float64(time.Millisecond)
+	}
+	return Percentiles{P50: at(0.5), P95: at(0.95), P99: at(0.99)}
+}
+
+// latencyProbe measures the latency of a sample of inputs, one at a time:
+// a marker sent to syncinput after an input is echoed back once the input is simulated,
+// then the first video frame with a new RTP timestamp is the first one which may show the input.
+type latencyProbe struct {
+	// RTP timestamp of the last video packet, read when the marker is echoed
+	lastTimestamp uint32
+	// Set from the echo of the marker until the next frame
+	waitingFrame uint32
+
+	nextID uint16
+	// Marker in flight
+	pending    bool
+	pendingID  uint16
+	receivedAt time.Time
+	sentAt     time.Time
+	// RTP timestamp of the frame being relayed when the marker was echoed
+	echoTimestamp uint32
+	input         latencyWindow
+	inputToFrame  latencyWindow
+	mu            sync.Mutex
+}
+
+// mark returns the identifier of the marker to send after an input received at receivedAt, if the input is probed
+func (p *latencyProbe) mark(receivedAt, now time.Time) (uint16, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if (p.pending || atomic.LoadUint32(&p.waitingFrame) == 1) && now.Sub(p.sentAt) < probeTimeout {
+		return 0, false
+	}
+	if now.Sub(p.sentAt) < settings.LatencyProbeInterval {
+		return 0, false
+	}
+
+	atomic.StoreUint32(&p.waitingFrame, 0)
+	p.nextID++
+	p.pending = true
+	p.pendingID = p.nextID
+	p.receivedAt = receivedAt
+	p.sentAt = now
+	return p.pendingID, true
+}
+
+func (p *latencyProbe) onEcho(id uint16, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.pending || id != p.pendingID {
+		return
+	}
+	p.pending = false
+	p.input.add(now.Sub(p.receivedAt))
+	p.echoTimestamp = atomic.LoadUint32(&p.lastTimestamp)
+	atomic.StoreUint32(&p.waitingFrame, 1)
+}
+
+// onVideoPacket is called for every packet of the VM, so it only locks while waiting for a frame
+func (p *latencyProbe) onVideoPacket(timestamp uint32, now func() time.Time) {
+	atomic.StoreUint32(&p.lastTimestamp, timestamp)
+	if atomic.LoadUint32(&p.waitingFrame) == 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if timestamp == p.echoTimestamp || atomic.LoadUint32(&p.waitingFrame) == 0 {
+		return
+	}
+	p.inputToFrame.add(now().Sub(p.receivedAt))
+	atomic.StoreUint32(&p.waitingFrame, 0)
+}
+
+func (p *latencyProbe) stats(rtt time.Duration) LatencyStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return LatencyStats{
+		Samples:      len(p.input.samples),
+		Input:        p.input.percentiles(0),
+		InputToFrame: p.inputToFrame.percentiles(0),
+		EndToEnd:     p.inputToFrame.percentiles(rtt),
+	}
+}
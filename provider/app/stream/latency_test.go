@@ -0,0 +1,91 @@
+package stream
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"provider/pkg/inputproto"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyProbe(t *testing.T) {
+	var p latencyProbe
+	start := time.Now()
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+	now := func(ms int) func() time.Time { return func() time.Time { return at(ms) } }
+
+	p.onVideoPacket(1000, now(0))
+	id, ok := p.mark(at(0), at(1))
+	require.True(t, ok)
+	// Only one input is probed at a time
+	_, ok = p.mark(at(300), at(300))
+	assert.False(t, ok)
+
+	p.onEcho(id+1, at(5))
+	p.onEcho(id, at(6))
+	// Packets of the frame being relayed when the input was simulated don't count
+	p.onVideoPacket(1000, now(10))
+	p.onVideoPacket(4000, now(40))
+	p.onVideoPacket(7000, now(70))
+
+	stats := p.stats(20 * time.Millisecond)
+	assert.Equal(t, 1, stats.Samples)
+	assert.Equal(t, Percentiles{6, 6, 6}, stats.Input)
+	assert.Equal(t, Percentiles{40, 40, 40}, stats.InputToFrame)
+	assert.Equal(t, Percentiles{60, 60, 60}, stats.EndToEnd)
+
+	// Probes are spaced out
+	_, ok = p.mark(at(100), at(100))
+	assert.False(t, ok)
+	id, ok = p.mark(at(300), at(301))
+	require.True(t, ok)
+	// Lost markers are given up
+	_, ok = p.mark(at(400), at(400))
+	assert.False(t, ok)
+	next, ok := p.mark(at(2400), at(2401))
+	require.True(t, ok)
+	assert.NotEqual(t, id, next)
+}
+
+func TestPercentiles(t *testing.T) {
+	var w latencyWindow
+	for i := 1; i <= latencyWindowSize+100; i++ {
+		w.add(time.Duration(i) * time.Millisecond)
+	}
+
+	// Only the latest samples are kept
+	assert.Len(t, w.samples, latencyWindowSize)
+	p := w.percentiles(0)
+	assert.Equal(t, 228.0, p.P50)
+	assert.Equal(t, 343.0, p.P95)
+	assert.Equal(t, 353.0, p.P99)
+}
+
+func TestEchoedMarkersAreMeasured(t *testing.T) {
+	s, syncinput := relayerWithSyncinput(t)
+	go s.readEchoes(s.wineConn)
+
+	s.receivedAt = time.Now()
+	s.send(keyEvent(65, true))
+	frames := make([]byte, 8)
+	_, err := io.ReadFull(syncinput, frames)
+	require.NoError(t, err)
+
+	// syncinput echoes the marker, sent in two parts
+	var ev inputproto.Event
+	_, err = inputproto.Decode(frames[4:], &ev)
+	require.NoError(t, err)
+	require.Equal(t, inputproto.Marker, ev.Type)
+	_, err = syncinput.Write(frames[4:6])
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	_, err = syncinput.Write(frames[6:8])
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return s.Latency(0).Samples == 1
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,142 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"provider/pkg/inputproto"
+)
+
+// Mouse buttons, numbered as MouseEvent.button of browsers
+const (
+	leftButton = iota
+	middleButton
+	rightButton
+	backButton
+	forwardButton
+)
+
+// Windows unit of a wheel notch
+const wheelDelta = 120
+
+type mousePayload struct {
+	// Kept for players which only send left and right clicks, 1 for the left button and 0 for the right one
+	IsLeft byte `json:"isLeft"`
+	// Button pressed or released, takes precedence over IsLeft
+	Button *int    `json:"button"`
+	X      float32 `json:"x"`
+	Y      float32 `json:"y"`
+	Width  float32 `json:"width"`
+	Height float32 `json:"height"`
+	// Set when the cursor is captured by the player, buttons are then pressed wherever the cursor is
+	Locked bool `json:"locked"`
+}
+
+func (p *mousePayload) button() (int, error) {
+	if p.Button == nil {
+		if p.IsLeft == 1 {
+			return leftButton, nil
+		}
+		return rightButton, nil
+	}
+	if *p.Button < leftButton || *p.Button > forwardButton {
+		return 0, fmt.Errorf("unknown mouse button %d", *p.Button)
+	}
+
+	return *p.Button, nil
+}
+
+// parseMouseEvent parses a mouse event of the player, t is MouseMove, MouseDown or MouseUp
+func parseMouseEvent(t inputproto.EventType, jsonPayload string, ev *inputproto.Event) error {
+	p := &mousePayload{}
+	if err := json.Unmarshal([]byte(jsonPayload), &p); err != nil {
+		return err
+	}
+	button, err := p.button()
+	if err != nil {
+		return err
+	}
+
+	if p.Locked {
+		switch t {
+		case inputproto.MouseDown:
+			*ev = inputproto.Event{Type: inputproto.ButtonDown, Button: uint8(button)}
+		case inputproto.MouseUp:
+			*ev = inputproto.Event{Type: inputproto.ButtonUp, Button: uint8(button)}
+		default:
+			return fmt.Errorf("absolute move while the cursor is locked")
+		}
+		return nil
+	}
+	if p.Width <= 0 || p.Height <= 0 {
+		return fmt.Errorf("invalid screen size %vx%v", p.Width, p.Height)
+	}
+
+	// Original formula: p.X = p.X * s.screenWidth / p.Width
+	// However, syncinput will handle the multiplication with screenWidth to remove the need of configuring screenWidth
+	// Same for p.Y
+	x := float64(p.X / p.Width)
+	y := float64(p.Y / p.Height)
+
+	*ev = mouseEvent(t, button, x, y)
+	return nil
+}
+
+// mouseEvent is a mouse event at a position relative to the screen
+func mouseEvent(t inputproto.EventType, button int, x, y float64) inputproto.Event {
+	return inputproto.Event{Type: t, Button: uint8(button), X: x, Y: y}
+}
+
+type wheelPayload struct {
+	// Scrolled notches, positive toward the right and the bottom as in browsers
+	DeltaX float64 `json:"deltaX"`
+	DeltaY float64 `json:"deltaY"`
+}
+
+// parseWheel converts scrolling in units of Windows, whose vertical axis is positive away from the user
+func parseWheel(jsonPayload string, ev *inputproto.Event) error {
+	var p wheelPayload
+	if err := json.Unmarshal([]byte(jsonPayload), &p); err != nil {
+		return err
+	}
+	if math.IsNaN(p.DeltaX) || math.IsNaN(p.DeltaY) {
+		return fmt.Errorf("invalid wheel delta")
+	}
+	// Browsers may report huge deltas when scrolling by pages
+	dx := math.Max(-10, math.Min(10, p.DeltaX))
+	dy := math.Max(-10, math.Min(10, p.DeltaY))
+
+	*ev = inputproto.Event{
+		Type: inputproto.Wheel,
+		DX:   int16(math.Round(dx * wheelDelta)),
+		DY:   int16(math.Round(-dy * wheelDelta)),
+	}
+	return nil
+}
+
+type relativeMovePayload struct {
+	// Movement in pixels of the player's screen, as MouseEvent.movementX and movementY
+	DX int `json:"dx"`
+	DY int `json:"dy"`
+}
+
+// parseRelativeMove parses a move of the captured cursor, as games which capture it only look at movements
+func parseRelativeMove(jsonPayload string, ev *inputproto.Event) error {
+	var p relativeMovePayload
+	if err := json.Unmarshal([]byte(jsonPayload), &p); err != nil {
+		return err
+	}
+
+	*ev = inputproto.Event{Type: inputproto.MoveRelative, DX: clampInt16(p.DX), DY: clampInt16(p.DY)}
+	return nil
+}
+
+func clampInt16(v int) int16 {
+	if v > math.MaxInt16 {
+		return math.MaxInt16
+	} else if v < math.MinInt16 {
+		return math.MinInt16
+	}
+	return int16(v)
+}
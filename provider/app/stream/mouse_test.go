@@ -0,0 +1,55 @@
+package stream
+
+import (
+	"testing"
+
+	"provider/pkg/inputproto"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMouseEvents(t *testing.T) {
+	tests := []struct {
+		payload string
+		t       inputproto.EventType
+		event   inputproto.Event
+	}{
+		// Players of older versions only tell whether the left button is used
+		{`{"isLeft":1,"x":200,"y":150,"width":800,"height":600}`, inputproto.MouseDown, mouseEvent(inputproto.MouseDown, leftButton, 0.25, 0.25)},
+		{`{"isLeft":0,"x":200,"y":150,"width":800,"height":600}`, inputproto.MouseUp, mouseEvent(inputproto.MouseUp, rightButton, 0.25, 0.25)},
+		{`{"button":1,"x":400,"y":300,"width":800,"height":600}`, inputproto.MouseDown, mouseEvent(inputproto.MouseDown, middleButton, 0.5, 0.5)},
+		{`{"button":4,"locked":true}`, inputproto.MouseUp, inputproto.Event{Type: inputproto.ButtonUp, Button: forwardButton}},
+	}
+	for _, test := range tests {
+		var ev inputproto.Event
+		require.NoError(t, parseMouseEvent(test.t, test.payload, &ev), test.payload)
+		assert.Equal(t, test.event, ev)
+	}
+
+	var ev inputproto.Event
+	for _, payload := range []string{
+		`{"button":5,"x":1,"y":1,"width":800,"height":600}`,
+		`{"button":0,"x":1,"y":1}`,
+		`{"button":0`,
+	} {
+		assert.Error(t, parseMouseEvent(inputproto.MouseDown, payload, &ev), payload)
+	}
+	assert.Error(t, parseMouseEvent(inputproto.MouseMove, `{"locked":true}`, &ev))
+}
+
+func TestWheelEvent(t *testing.T) {
+	var ev inputproto.Event
+	require.NoError(t, parseWheel(`{"deltaY":1}`, &ev))
+	// Scrolling down is negative for Windows
+	assert.Equal(t, inputproto.Event{Type: inputproto.Wheel, DY: -120}, ev)
+
+	require.NoError(t, parseWheel(`{"deltaX":-0.5,"deltaY":-100}`, &ev))
+	assert.Equal(t, inputproto.Event{Type: inputproto.Wheel, DX: -60, DY: 1200}, ev)
+}
+
+func TestRelativeMoveEvent(t *testing.T) {
+	var ev inputproto.Event
+	require.NoError(t, parseRelativeMove(`{"dx":-12,"dy":40000}`, &ev))
+	assert.Equal(t, inputproto.Event{Type: inputproto.MoveRelative, DX: -12, DY: 32767}, ev)
+}
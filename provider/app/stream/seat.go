@@ -0,0 +1,77 @@
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"provider/pkg/inputproto"
+	"provider/settings"
+)
+
+// Players sharing a session sit in seats, the player who started the session in HostSeat and guests in the next ones.
+// Each seat plays the gamepad of the VM with the same number, so there are as many seats as gamepads.
+const (
+	HostSeat = 0
+	MaxSeats = MaxGamepads
+)
+
+// SeatMapping tells how guests play with the keyboard, it's read from <appName>.seats.json of the app config.
+// Games played by several players on one keyboard give each of them a region of keys, e.g. WASD and the arrows.
+type SeatMapping struct {
+	// Windows virtual-key codes by the code pressed by the guest, for each seat from seat 1 on
+	Keys []map[uint16]uint16 `json:"keys"`
+}
+
+// DefaultSeatMapping lets guests only play with a gamepad
+var DefaultSeatMapping = &SeatMapping{}
+
+// LoadSeatMapping reads the seat mapping of the app, or returns the default one if the app has none
+func LoadSeatMapping(appName string) (*SeatMapping, error) {
+	raw, err := os.ReadFile(filepath.Join(settings.AppConfDir, appName+".seats.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return DefaultSeatMapping, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var mapping SeatMapping
+	if err := json.Unmarshal(raw, &mapping); err != nil {
+		return nil, fmt.Errorf("invalid seat mapping of %s: %w", appName, err)
+	}
+
+	return &mapping, nil
+}
+
+// tag turns the event of a guest into the event of its seat, and returns false if the guest can't send it.
+// Guests play the pad of their seat with their first pad and the keys of their seat, the host plays everything else,
+// so a host with several pads on one device should leave the seats of its pads free.
+func (m *SeatMapping) tag(seat int, ev *inputproto.Event) bool {
+	if seat == HostSeat {
+		return true
+	}
+	if seat < 0 || seat >= MaxSeats {
+		return false
+	}
+
+	switch ev.Type {
+	case inputproto.GamepadConnect, inputproto.GamepadDisconnect, inputproto.GamepadButton, inputproto.GamepadAxis, inputproto.GamepadTrigger:
+		if ev.Pad != 0 {
+			return false
+		}
+		ev.Pad = uint8(seat)
+		return true
+	case inputproto.KeyDown, inputproto.KeyUp:
+		if seat > len(m.Keys) {
+			return false
+		}
+		key, ok := m.Keys[seat-1][ev.Key]
+		ev.Key = key
+		return ok
+	}
+
+	// The mouse and touches move the only cursor of the VM, which is the host's
+	return false
+}
@@ -0,0 +1,46 @@
+package stream
+
+import (
+	"encoding/json"
+	"testing"
+
+	"provider/pkg/inputproto"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeatsTagInputOfGuests(t *testing.T) {
+	var mapping SeatMapping
+	// Guest in seat 1 plays with WASD, sent as the arrows
+	require.NoError(t, json.Unmarshal([]byte(`{"keys":[{"87":38,"65":37,"83":40,"68":39}]}`), &mapping))
+
+	tests := []struct {
+		name string
+		seat int
+		ev   inputproto.Event
+		want *inputproto.Event
+	}{
+		{"host plays every pad", HostSeat, inputproto.Event{Type: inputproto.GamepadButton, Pad: 2}, &inputproto.Event{Type: inputproto.GamepadButton, Pad: 2}},
+		{"host plays the mouse", HostSeat, inputproto.Event{Type: inputproto.MouseDown}, &inputproto.Event{Type: inputproto.MouseDown}},
+		{"first pad of guest is the pad of its seat", 2, inputproto.Event{Type: inputproto.GamepadAxis, Value: 1}, &inputproto.Event{Type: inputproto.GamepadAxis, Pad: 2, Value: 1}},
+		{"other pads of guest are dropped", 2, inputproto.Event{Type: inputproto.GamepadAxis, Pad: 1}, nil},
+		{"keys of guest are mapped", 1, inputproto.Event{Type: inputproto.KeyDown, Key: 87}, &inputproto.Event{Type: inputproto.KeyDown, Key: 38}},
+		{"unmapped keys of guest are dropped", 1, inputproto.Event{Type: inputproto.KeyDown, Key: 13}, nil},
+		{"guest without keys", 2, inputproto.Event{Type: inputproto.KeyUp, Key: 87}, nil},
+		{"mouse of guest is dropped", 1, inputproto.Event{Type: inputproto.MouseMove}, nil},
+		{"unknown seat", MaxSeats, inputproto.Event{Type: inputproto.GamepadButton}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := tt.ev
+			ok := mapping.tag(tt.seat, &ev)
+			if tt.want == nil {
+				assert.False(t, ok)
+				return
+			}
+			assert.True(t, ok)
+			assert.Equal(t, *tt.want, ev)
+		})
+	}
+}
@@ -1,32 +1,83 @@
 package stream
 
 import (
-	"container/ring"
-	"encoding/json"
+	"encoding/binary"
 	"errors"
-	"fmt"
 	"log"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"provider/app/webrtc"
-	"provider/constants"
-
-	"github.com/pion/rtp"
+	"provider/pkg/inputproto"
+	"provider/pkg/rtpqueue"
 )
 
 type StreamRelayer struct {
+	// Counters are first to be 64-bit aligned for atomic operations
+	videoCounters relayCounters
+	audioCounters relayCounters
 	logID         string
-	videoStream   chan *rtp.Packet
-	audioStream   chan *rtp.Packet
+	videoStream   *rtpqueue.Queue
+	audioStream   *rtpqueue.Queue
 	eventStream   chan *webrtc.Packet
 	videoListener *net.UDPConn
 	audioListener *net.UDPConn
 	wineConn      *net.TCPConn
 	syncListener  *net.TCPListener
+	latency       latencyProbe
+	// Queues of spectators, which get copies of the packets relayed to the player
+	spectators   map[string]*SpectatorStreams
+	spectatorsMu sync.RWMutex
+	// Only used by the app events routine
+	touch    *touchState
+	seats    *SeatMapping
+	inputBuf []byte
+	// When the input being simulated was received, and the seat of the player who sent it
+	receivedAt time.Time
+	seat       int
+}
+
+// SpectatorStreams are the queues read by the WebRTC connection of a spectator
+type SpectatorStreams struct {
+	Video *rtpqueue.Queue
+	Audio *rtpqueue.Queue
+}
+
+type relayCounters struct {
+	packetsIn uint64
+	dropped   uint64
+}
+
+// RelayStats tells how well streams of the VM are relayed
+type RelayStats struct {
+	VideoPacketsIn uint64 `json:"videoPacketsIn"`
+	VideoDropped   uint64 `json:"videoDropped"`
+	// Packets waiting to be sent to the player
+	VideoBacklog   int    `json:"videoBacklog"`
+	AudioPacketsIn uint64 `json:"audioPacketsIn"`
+	AudioDropped   uint64 `json:"audioDropped"`
+	AudioBacklog   int    `json:"audioBacklog"`
+}
+
+func (s *StreamRelayer) Stats() RelayStats {
+	return RelayStats{
+		VideoPacketsIn: atomic.LoadUint64(&s.videoCounters.packetsIn),
+		VideoDropped:   atomic.LoadUint64(&s.videoCounters.dropped) + s.videoStream.Dropped(),
+		VideoBacklog:   s.videoStream.Len(),
+		AudioPacketsIn: atomic.LoadUint64(&s.audioCounters.packetsIn),
+		AudioDropped:   atomic.LoadUint64(&s.audioCounters.dropped) + s.audioStream.Dropped(),
+		AudioBacklog:   s.audioStream.Len(),
+	}
+}
+
+// Latency returns the input latency of the session, rtt is the round trip time to the player
+func (s *StreamRelayer) Latency(rtt time.Duration) LatencyStats {
+	return s.latency.stats(rtt)
 }
 
-func NewStreamRelayer(logID string, videoStream, audioStream chan *rtp.Packet, eventStream chan *webrtc.Packet, videoListener, audioListener *net.UDPConn, syncListener *net.TCPListener) *StreamRelayer {
+func NewStreamRelayer(logID string, videoStream, audioStream *rtpqueue.Queue, eventStream chan *webrtc.Packet, videoListener, audioListener *net.UDPConn, syncListener *net.TCPListener, touchMapping *TouchMapping, seatMapping *SeatMapping) *StreamRelayer {
 	s := &StreamRelayer{
 		logID:         logID,
 		videoStream:   videoStream,
@@ -35,11 +86,46 @@ func NewStreamRelayer(logID string, videoStream, audioStream chan *rtp.Packet, e
 		videoListener: videoListener,
 		audioListener: audioListener,
 		syncListener:  syncListener,
+		touch:         newTouchState(touchMapping),
+		seats:         seatMapping,
+		spectators:    make(map[string]*SpectatorStreams),
 	}
 
 	return s
 }
 
+// AddSpectator relays copies of the streams to the queues of the spectator.
+// Queues of a spectator are separate from the player's, so a slow spectator only drops its own packets.
+func (s *StreamRelayer) AddSpectator(id string, streams *SpectatorStreams) {
+	s.spectatorsMu.Lock()
+	defer s.spectatorsMu.Unlock()
+
+	s.spectators[id] = streams
+}
+
+// RemoveSpectator stops relaying streams to the spectator and closes its queues
+func (s *StreamRelayer) RemoveSpectator(id string) {
+	s.spectatorsMu.Lock()
+	streams, ok := s.spectators[id]
+	delete(s.spectators, id)
+	s.spectatorsMu.Unlock()
+
+	if ok {
+		streams.Video.Close()
+		streams.Audio.Close()
+	}
+}
+
+// pushToSpectators copies the packet to the queue of each spectator which output selects
+func (s *StreamRelayer) pushToSpectators(packet []byte, output func(*SpectatorStreams) *rtpqueue.Queue) {
+	s.spectatorsMu.RLock()
+	defer s.spectatorsMu.RUnlock()
+
+	for _, streams := range s.spectators {
+		output(streams).Push(packet)
+	}
+}
+
 func (s *StreamRelayer) Start() error {
 	log.Printf("[%s] Start relaying streams..\n", s.logID)
 
@@ -73,6 +159,7 @@ func (s *StreamRelayer) Start() error {
 				_ = s.wineConn.Close()
 			}
 			s.wineConn = conn
+			go s.readEchoes(conn)
 			wineConnected <- struct{}{}
 
 			log.Printf("[%s] Successfully set up syncinput connection\n", s.logID)
@@ -84,8 +171,8 @@ func (s *StreamRelayer) Start() error {
 
 		go s.healthCheckVM()
 		go s.handleAppEvents()
-		go s.relayStream(s.videoListener, s.videoStream)
-		go s.relayStream(s.audioListener, s.audioStream)
+		go s.relayStream(s.videoListener, s.videoStream, spectatorVideo, &s.videoCounters, &s.latency)
+		go s.relayStream(s.audioListener, s.audioStream, spectatorAudio, &s.audioCounters, nil)
 	}()
 
 	return nil
@@ -95,22 +182,27 @@ func (s *StreamRelayer) Close() {
 	if s.wineConn != nil {
 		_ = s.wineConn.Close()
 	}
+
+	s.spectatorsMu.Lock()
+	for id, streams := range s.spectators {
+		streams.Video.Close()
+		streams.Audio.Close()
+		delete(s.spectators, id)
+	}
+	s.spectatorsMu.Unlock()
 }
 
-func (s *StreamRelayer) relayStream(listener *net.UDPConn, output chan<- *rtp.Packet) {
-	r := ring.New(120)
+func spectatorVideo(streams *SpectatorStreams) *rtpqueue.Queue { return streams.Video }
+func spectatorAudio(streams *SpectatorStreams) *rtpqueue.Queue { return streams.Audio }
 
-	n := r.Len()
-	for i := 0; i < n; i++ {
-		r.Value = make([]byte, 1500)
-		r = r.Next()
-	}
+// relayStream forwards RTP packets of the VM to the queue read by WebRTC, and to the queues of spectators.
+// It never waits for the player, if the player can't keep up the queue drops the oldest packets instead.
+// Timestamps of video packets are given to the latency probe.
+func (s *StreamRelayer) relayStream(listener *net.UDPConn, output *rtpqueue.Queue, spectatorOutput func(*SpectatorStreams) *rtpqueue.Queue, counters *relayCounters, probe *latencyProbe) {
+	buf := make([]byte, rtpqueue.MaxPacketSize)
 
 	for {
-		inboundRTPPacket := r.Value.([]byte)
-		r = r.Next()
-
-		n, _, err := listener.ReadFrom(inboundRTPPacket)
+		n, err := listener.Read(buf)
 		if err != nil {
 			if errors.Is(err, net.ErrClosed) {
 				return
@@ -118,21 +210,26 @@ func (s *StreamRelayer) relayStream(listener *net.UDPConn, output chan<- *rtp.Pa
 			log.Printf("[%s] Error during read RTP packet: %s\n", s.logID, err)
 			continue
 		}
+		atomic.AddUint64(&counters.packetsIn, 1)
 
-		var packet rtp.Packet
-		if err := packet.Unmarshal(inboundRTPPacket[:n]); err != nil {
-			log.Printf("[%s] Error during unmarshalling RTP packet: %s\n", s.logID, err)
+		if !rtpqueue.Valid(buf[:n]) {
+			log.Printf("[%s] Dropping invalid RTP packet of %d bytes\n", s.logID, n)
+			atomic.AddUint64(&counters.dropped, 1)
 			continue
 		}
 
-		output <- &packet
+		output.Push(buf[:n])
+		s.pushToSpectators(buf[:n], spectatorOutput)
+		if probe != nil {
+			probe.onVideoPacket(binary.BigEndian.Uint32(buf[4:8]), time.Now)
+		}
 	}
 }
 
 func (s *StreamRelayer) healthCheckVM() {
 	for {
 		if s.wineConn != nil {
-			_, err := s.wineConn.Write([]byte{0})
+			_, err := s.wineConn.Write([]byte{inputproto.Ping})
 			if err != nil {
 				if errors.Is(err, net.ErrClosed) {
 					return
@@ -145,78 +242,21 @@ func (s *StreamRelayer) healthCheckVM() {
 	}
 }
 
+// handleAppEvents simulates input of the players, which is either JSON packets or frames of the binary protocol
 func (s *StreamRelayer) handleAppEvents() {
+	var ev inputproto.Event
 	for packet := range s.eventStream {
-		switch packet.Type {
-		case constants.KeyUp:
-			s.simulateKey(packet.Data, 0)
-		case constants.KeyDown:
-			s.simulateKey(packet.Data, 1)
-		case constants.MouseMove:
-			s.simulateMouseEvent(packet.Data, 0)
-		case constants.MouseDown:
-			s.simulateMouseEvent(packet.Data, 1)
-		case constants.MouseUp:
-			s.simulateMouseEvent(packet.Data, 2)
+		s.receivedAt = packet.ReceivedAt
+		s.seat = packet.Seat
+		if packet.Frames != nil {
+			s.handleFrames(packet.Frames)
+			continue
 		}
-	}
-}
-
-type keydownPayload struct {
-	KeyCode int `json:"keycode"`
-}
-
-func (s *StreamRelayer) simulateKey(jsonPayload string, keyState byte) {
-	if s.wineConn == nil {
-		return
-	}
-
-	p := &keydownPayload{}
-	err := json.Unmarshal([]byte(jsonPayload), &p)
-	if err != nil {
-		log.Printf("[%s] Couldn't parse keydown payload: %s\n", s.logID, err)
-		return
-	}
-
-	vmKeyMsg := fmt.Sprintf("K%d,%b|", p.KeyCode, keyState)
-	_, err = s.wineConn.Write([]byte(vmKeyMsg))
-	if err != nil {
-		log.Printf("[%s] Couldn't send key event to wine: %s\n", s.logID, err)
-		return
-	}
-}
-
-type mousePayload struct {
-	IsLeft byte    `json:"isLeft"`
-	X      float32 `json:"x"`
-	Y      float32 `json:"y"`
-	Width  float32 `json:"width"`
-	Height float32 `json:"height"`
-}
 
-func (s *StreamRelayer) simulateMouseEvent(jsonPayload string, mouseState int) {
-	if s.wineConn == nil {
-		return
-	}
-
-	p := &mousePayload{}
-	err := json.Unmarshal([]byte(jsonPayload), &p)
-	if err != nil {
-		log.Printf("[%s] Couldn't parse mouse payload: %s\n", s.logID, err)
-		return
-	}
-
-	// Original formula: p.X = p.X * s.screenWidth / p.Width
-	// However, syncinput will handle the multiplication with screenWidth to remove the need of configuring screenWidth
-	// Same for p.Y
-	p.X = p.X / p.Width
-	p.Y = p.Y / p.Height
-
-	// Mouse is in format of comma separated "12.4,52.3"
-	vmMouseMsg := fmt.Sprintf("M%d,%d,%f,%f,%f,%f|", p.IsLeft, mouseState, p.X, p.Y, p.Width, p.Height)
-	_, err = s.wineConn.Write([]byte(vmMouseMsg))
-	if err != nil {
-		log.Printf("[%s] Couldn't send mouse event to wine: %s\n", s.logID, err)
-		return
+		if err := parsePacket(packet, &ev); err != nil {
+			log.Printf("[%s] Couldn't parse %s packet: %s\n", s.logID, packet.Type, err)
+			continue
+		}
+		s.simulate(&ev)
 	}
 }
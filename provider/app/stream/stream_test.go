@@ -0,0 +1,69 @@
+package stream
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"provider/pkg/rtpqueue"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func popWithin(t *testing.T, q *rtpqueue.Queue, d time.Duration) ([]byte, bool) {
+	type result struct {
+		n  int
+		ok bool
+	}
+	buf := make([]byte, rtpqueue.MaxPacketSize)
+	done := make(chan result, 1)
+	go func() {
+		n, ok := q.Pop(buf)
+		done <- result{n, ok}
+	}()
+
+	select {
+	case r := <-done:
+		return buf[:r.n], r.ok
+	case <-time.After(d):
+		t.Fatal("no packet was relayed")
+		return nil, false
+	}
+}
+
+func TestPacketsAreRelayedToSpectators(t *testing.T) {
+	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer listener.Close()
+	sender, err := net.DialUDP("udp", nil, listener.LocalAddr().(*net.UDPAddr))
+	require.NoError(t, err)
+	defer sender.Close()
+
+	player := rtpqueue.New(8, nil)
+	s := NewStreamRelayer("test", player, nil, nil, listener, nil, nil, DefaultTouchMapping, DefaultSeatMapping)
+	spectator := &SpectatorStreams{Video: rtpqueue.New(8, nil), Audio: rtpqueue.New(8, nil)}
+	s.AddSpectator("friend", spectator)
+	go s.relayStream(listener, player, spectatorVideo, &s.videoCounters, nil)
+
+	packet := []byte{0x80, 96, 0, 1, 0, 0, 0, 1, 0, 0, 0, 1, 0xab}
+	_, err = sender.Write(packet)
+	require.NoError(t, err)
+
+	relayed, ok := popWithin(t, player, 5*time.Second)
+	require.True(t, ok)
+	assert.Equal(t, packet, relayed)
+	relayed, ok = popWithin(t, spectator.Video, 5*time.Second)
+	require.True(t, ok)
+	assert.Equal(t, packet, relayed)
+	assert.Zero(t, spectator.Audio.Len())
+
+	// Spectator leaving doesn't affect the player
+	s.RemoveSpectator("friend")
+	_, ok = popWithin(t, spectator.Video, 5*time.Second)
+	assert.False(t, ok)
+	_, err = sender.Write(packet)
+	require.NoError(t, err)
+	_, ok = popWithin(t, player, 5*time.Second)
+	assert.True(t, ok)
+}
@@ -0,0 +1,268 @@
+package stream
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	"provider/pkg/inputproto"
+	"provider/settings"
+)
+
+// TouchMapping tells how touches of mobile players are played, it's read from <appName>.touch.json of the app config.
+// Positions and sizes of regions are fractions of the screen, so mappings don't depend on the resolution.
+type TouchMapping struct {
+	// Touches outside of regions click where they are, unless Tap is disabled
+	Tap       TapMapping       `json:"tap"`
+	Joysticks []JoystickRegion `json:"joysticks"`
+	Buttons   []ButtonRegion   `json:"buttons"`
+}
+
+type TapMapping struct {
+	Disabled bool `json:"disabled"`
+	// Clicks with the right button instead of the left one
+	Right bool `json:"right"`
+}
+
+func (m *TapMapping) button() int {
+	if m.Right {
+		return rightButton
+	}
+	return leftButton
+}
+
+type Region struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+func (r *Region) contains(x, y float64) bool {
+	return x >= r.X && x < r.X+r.Width && y >= r.Y && y < r.Y+r.Height
+}
+
+// JoystickRegion is a virtual joystick centered where the touch starts, moving the touch presses direction keys
+type JoystickRegion struct {
+	Region
+	// Distance from the center, as a fraction of the screen, under which no key is pressed
+	Deadzone float64 `json:"deadzone"`
+	// Windows virtual-key codes of directions
+	Up    int `json:"up"`
+	Down  int `json:"down"`
+	Left  int `json:"left"`
+	Right int `json:"right"`
+}
+
+// ButtonRegion holds a key down while touched
+type ButtonRegion struct {
+	Region
+	Key int `json:"key"`
+}
+
+// DefaultTouchMapping clicks where the player taps
+var DefaultTouchMapping = &TouchMapping{}
+
+// LoadTouchMapping reads the touch mapping of the app, or returns the default one if the app has none
+func LoadTouchMapping(appName string) (*TouchMapping, error) {
+	raw, err := os.ReadFile(filepath.Join(settings.AppConfDir, appName+".touch.json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return DefaultTouchMapping, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var mapping TouchMapping
+	if err := json.Unmarshal(raw, &mapping); err != nil {
+		return nil, fmt.Errorf("invalid touch mapping of %s: %w", appName, err)
+	}
+
+	return &mapping, nil
+}
+
+// Touches beyond this number are ignored, so lost ends of touches can't pile up
+const maxTouches = 10
+
+type touchPayload struct {
+	ID     int     `json:"id"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Width  float64 `json:"width"`
+	Height float64 `json:"height"`
+}
+
+// activeTouch is a finger on the screen, bound to what it touched first
+type activeTouch struct {
+	joystick *JoystickRegion
+	button   *ButtonRegion
+	// Start of a joystick touch
+	startX, startY float64
+	// Direction keys held by a joystick touch
+	pressed map[int]bool
+}
+
+// touchState turns touches of the player into key and mouse events
+type touchState struct {
+	mapping *TouchMapping
+	touches map[int]*activeTouch
+	// Touch moving the mouse, only one can click at a time
+	mouseTouch int
+	mouseDown  bool
+}
+
+func newTouchState(mapping *TouchMapping) *touchState {
+	return &touchState{
+		mapping: mapping,
+		touches: make(map[int]*activeTouch),
+	}
+}
+
+// parseTouch parses a touch of the player, whose position is made relative to the screen
+func parseTouch(t inputproto.EventType, jsonPayload string, ev *inputproto.Event) error {
+	var p touchPayload
+	if err := json.Unmarshal([]byte(jsonPayload), &p); err != nil {
+		return err
+	}
+	if p.Width <= 0 || p.Height <= 0 || math.IsNaN(p.X) || math.IsNaN(p.Y) {
+		return fmt.Errorf("invalid touch position %v,%v in %vx%v", p.X, p.Y, p.Width, p.Height)
+	}
+	*ev = inputproto.Event{
+		Type: t,
+		// Some browsers use big identifiers, they only need to tell apart the touches in progress
+		ID: uint8(p.ID),
+		X:  math.Max(0, math.Min(1, p.X/p.Width)),
+		Y:  math.Max(0, math.Min(1, p.Y/p.Height)),
+	}
+	return nil
+}
+
+// handle returns the events simulating the touch
+func (t *touchState) handle(ev *inputproto.Event) []inputproto.Event {
+	id := int(ev.ID)
+	switch ev.Type {
+	case inputproto.TouchStart:
+		return t.start(id, ev.X, ev.Y)
+	case inputproto.TouchMove:
+		return t.move(id, ev.X, ev.Y)
+	case inputproto.TouchEnd:
+		return t.end(id, ev.X, ev.Y)
+	}
+
+	return nil
+}
+
+func (t *touchState) start(id int, x, y float64) []inputproto.Event {
+	if _, ok := t.touches[id]; ok || len(t.touches) >= maxTouches {
+		return nil
+	}
+
+	touch := &activeTouch{startX: x, startY: y, pressed: make(map[int]bool)}
+	t.touches[id] = touch
+
+	for i := range t.mapping.Joysticks {
+		if t.mapping.Joysticks[i].contains(x, y) {
+			touch.joystick = &t.mapping.Joysticks[i]
+			return nil
+		}
+	}
+	for i := range t.mapping.Buttons {
+		if t.mapping.Buttons[i].contains(x, y) {
+			touch.button = &t.mapping.Buttons[i]
+			return []inputproto.Event{keyEvent(touch.button.Key, true)}
+		}
+	}
+	if t.mapping.Tap.Disabled || t.mouseDown {
+		return nil
+	}
+
+	t.mouseTouch = id
+	t.mouseDown = true
+	return []inputproto.Event{
+		mouseEvent(inputproto.MouseMove, t.mapping.Tap.button(), x, y),
+		mouseEvent(inputproto.MouseDown, t.mapping.Tap.button(), x, y),
+	}
+}
+
+func (t *touchState) move(id int, x, y float64) []inputproto.Event {
+	touch, ok := t.touches[id]
+	if !ok {
+		return nil
+	}
+
+	if touch.joystick != nil {
+		return t.moveJoystick(touch, x, y)
+	}
+	if t.mouseDown && t.mouseTouch == id {
+		return []inputproto.Event{mouseEvent(inputproto.MouseMove, t.mapping.Tap.button(), x, y)}
+	}
+
+	return nil
+}
+
+// moveJoystick presses the direction keys toward which the touch moved from its start, releasing the other ones
+func (t *touchState) moveJoystick(touch *activeTouch, x, y float64) []inputproto.Event {
+	j := touch.joystick
+	dx, dy := x-touch.startX, y-touch.startY
+	distance := math.Hypot(dx, dy)
+
+	want := make(map[int]bool)
+	if distance > j.Deadzone {
+		// Diagonals press two keys, the direction is split in 8 sectors of 45°
+		angle := math.Atan2(dy, dx)
+		if math.Cos(angle) > math.Cos(3*math.Pi/8) {
+			want[j.Right] = true
+		} else if math.Cos(angle) < -math.Cos(3*math.Pi/8) {
+			want[j.Left] = true
+		}
+		if math.Sin(angle) > math.Sin(math.Pi/8) {
+			want[j.Down] = true
+		} else if math.Sin(angle) < -math.Sin(math.Pi/8) {
+			want[j.Up] = true
+		}
+	}
+
+	return touch.press(want)
+}
+
+// press holds the wanted keys and releases the others, in a stable order
+func (touch *activeTouch) press(want map[int]bool) []inputproto.Event {
+	var events []inputproto.Event
+	j := touch.joystick
+	for _, key := range []int{j.Up, j.Down, j.Left, j.Right} {
+		if key == 0 {
+			continue
+		}
+		if touch.pressed[key] && !want[key] {
+			delete(touch.pressed, key)
+			events = append(events, keyEvent(key, false))
+		} else if !touch.pressed[key] && want[key] {
+			touch.pressed[key] = true
+			events = append(events, keyEvent(key, true))
+		}
+	}
+
+	return events
+}
+
+func (t *touchState) end(id int, x, y float64) []inputproto.Event {
+	touch, ok := t.touches[id]
+	if !ok {
+		return nil
+	}
+	delete(t.touches, id)
+
+	switch {
+	case touch.joystick != nil:
+		return touch.press(nil)
+	case touch.button != nil:
+		return []inputproto.Event{keyEvent(touch.button.Key, false)}
+	case t.mouseDown && t.mouseTouch == id:
+		t.mouseDown = false
+		return []inputproto.Event{mouseEvent(inputproto.MouseUp, t.mapping.Tap.button(), x, y)}
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package stream
+
+import (
+	"fmt"
+	"testing"
+
+	"provider/app/webrtc"
+	"provider/constants"
+	"provider/pkg/inputproto"
+	"provider/settings"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testMapping = &TouchMapping{
+	Joysticks: []JoystickRegion{{
+		Region:   Region{X: 0, Y: 0.5, Width: 0.5, Height: 0.5},
+		Deadzone: 0.05,
+		Up:       38, Down: 40, Left: 37, Right: 39,
+	}},
+	Buttons: []ButtonRegion{{
+		Region: Region{X: 0.8, Y: 0.8, Width: 0.2, Height: 0.2},
+		Key:    32,
+	}},
+}
+
+// touch sends a touch event of the JSON protocol on a 1000x1000 screen
+func touch(t *testing.T, state *touchState, packetType string, id int, x, y float64) []inputproto.Event {
+	var ev inputproto.Event
+	payload := fmt.Sprintf(`{"id":%d,"x":%v,"y":%v,"width":1000,"height":1000}`, id, x, y)
+	require.NoError(t, parsePacket(&webrtc.Packet{Type: packetType, Data: payload}, &ev))
+	return state.handle(&ev)
+}
+
+// keys returns key events, negative codes release their key
+func keys(keyCodes ...int) []inputproto.Event {
+	var events []inputproto.Event
+	for _, keyCode := range keyCodes {
+		if keyCode < 0 {
+			events = append(events, keyEvent(-keyCode, false))
+		} else {
+			events = append(events, keyEvent(keyCode, true))
+		}
+	}
+	return events
+}
+
+func TestTapClicks(t *testing.T) {
+	state := newTouchState(DefaultTouchMapping)
+
+	assert.Equal(t, []inputproto.Event{
+		mouseEvent(inputproto.MouseMove, leftButton, 0.25, 0.5),
+		mouseEvent(inputproto.MouseDown, leftButton, 0.25, 0.5),
+	}, touch(t, state, constants.TouchStart, 1, 250, 500))
+	assert.Equal(t, []inputproto.Event{mouseEvent(inputproto.MouseMove, leftButton, 0.3, 0.5)}, touch(t, state, constants.TouchMove, 1, 300, 500))
+	// A second finger can't click while the first one holds the button
+	assert.Empty(t, touch(t, state, constants.TouchStart, 2, 700, 100))
+	assert.Empty(t, touch(t, state, constants.TouchEnd, 2, 700, 100))
+	assert.Equal(t, []inputproto.Event{mouseEvent(inputproto.MouseUp, leftButton, 0.3, 0.5)}, touch(t, state, constants.TouchEnd, 1, 300, 500))
+}
+
+func TestJoystickPressesDirectionKeys(t *testing.T) {
+	state := newTouchState(testMapping)
+
+	assert.Empty(t, touch(t, state, constants.TouchStart, 1, 200, 700))
+	// Within the deadzone
+	assert.Empty(t, touch(t, state, constants.TouchMove, 1, 220, 700))
+	assert.Equal(t, keys(39), touch(t, state, constants.TouchMove, 1, 300, 700))
+	// Up-right diagonal
+	assert.Equal(t, keys(38), touch(t, state, constants.TouchMove, 1, 300, 600))
+	assert.Equal(t, keys(-38, 37, -39), touch(t, state, constants.TouchMove, 1, 100, 700))
+	assert.Equal(t, keys(-37), touch(t, state, constants.TouchEnd, 1, 100, 700))
+}
+
+func TestMultiTouchButtonAndJoystick(t *testing.T) {
+	state := newTouchState(testMapping)
+
+	touch(t, state, constants.TouchStart, 1, 200, 700)
+	assert.Equal(t, keys(32), touch(t, state, constants.TouchStart, 2, 900, 900))
+	assert.Equal(t, keys(40), touch(t, state, constants.TouchMove, 1, 200, 800))
+	// The button stays bound to its touch even when the finger slides away
+	assert.Empty(t, touch(t, state, constants.TouchMove, 2, 500, 200))
+	assert.Equal(t, keys(-32), touch(t, state, constants.TouchEnd, 2, 500, 200))
+	assert.Equal(t, keys(-40), touch(t, state, constants.TouchEnd, 1, 200, 800))
+}
+
+func TestInvalidTouch(t *testing.T) {
+	var ev inputproto.Event
+	assert.Error(t, parseTouch(inputproto.TouchStart, `{"x":1,"y":1}`, &ev))
+	assert.Error(t, parsePacket(&webrtc.Packet{Type: "TOUCHHOLD", Data: `{"x":1,"y":1,"width":10,"height":10}`}, &ev))
+}
+
+func TestLoadTouchMappingOfApps(t *testing.T) {
+	confDir := settings.AppConfDir
+	settings.AppConfDir = "../../appconf"
+	t.Cleanup(func() { settings.AppConfDir = confDir })
+
+	mapping, err := LoadTouchMapping("tarzan_mobile")
+	require.NoError(t, err)
+	assert.NotEmpty(t, mapping.Joysticks)
+	assert.NotEmpty(t, mapping.Buttons)
+
+	mapping, err = LoadTouchMapping("unknown_mobile")
+	require.NoError(t, err)
+	assert.Same(t, DefaultTouchMapping, mapping)
+}
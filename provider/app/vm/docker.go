@@ -0,0 +1,386 @@
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"provider/settings"
+)
+
+const dockerRequestTimeout = 30 * time.Second
+
+// DockerBackend runs VMs as containers through the Docker Engine API.
+// Images are expected to be prebuilt as <settings.VMImage>:<appName>, see run.sh.
+type DockerBackend struct {
+	client *http.Client
+	// Client without timeout for long polling requests
+	waitClient *http.Client
+	// uinput device passed to VMs for gamepads, empty if the host doesn't have one
+	uinputDevice string
+}
+
+func NewDockerBackend(socketPath string) *DockerBackend {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socketPath)
+		},
+	}
+
+	d := &DockerBackend{
+		client:     &http.Client{Transport: transport, Timeout: dockerRequestTimeout},
+		waitClient: &http.Client{Transport: transport},
+	}
+	if settings.UInputDevice != "" {
+		if _, err := os.Stat(settings.UInputDevice); err != nil {
+			log.Printf("Gamepads are disabled, %s is missing: %s\n", settings.UInputDevice, err)
+		} else {
+			log.Printf("Gamepads are enabled, VMs may use input devices of this host through %s\n", settings.UInputDevice)
+			d.uinputDevice = settings.UInputDevice
+		}
+	}
+
+	return d
+}
+
+type dockerError struct {
+	Message string `json:"message"`
+}
+
+func (d *DockerBackend) do(client *http.Client, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	u := url.URL{Scheme: "http", Host: "docker", Path: path, RawQuery: query.Encode()}
+	req, err := http.NewRequest(method, u.String(), reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+
+		var dErr dockerError
+		if err := json.NewDecoder(resp.Body).Decode(&dErr); err != nil || dErr.Message == "" {
+			return nil, fmt.Errorf("docker: %s %s: %s", method, path, resp.Status)
+		}
+		return nil, fmt.Errorf("docker: %s", dErr.Message)
+	}
+
+	return resp, nil
+}
+
+func (d *DockerBackend) call(method, path string, query url.Values, body, out interface{}) error {
+	resp, err := d.do(d.client, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type containerDevice struct {
+	PathOnHost        string `json:"PathOnHost"`
+	PathInContainer   string `json:"PathInContainer"`
+	CgroupPermissions string `json:"CgroupPermissions"`
+}
+
+type containerHostConfig struct {
+	Binds             []string          `json:"Binds"`
+	Devices           []containerDevice `json:"Devices,omitempty"`
+	DeviceCgroupRules []string          `json:"DeviceCgroupRules,omitempty"`
+}
+
+// gamepadHostConfig lets the VM create virtual gamepads, if the provider was started with a uinput device.
+// The VM creates the nodes of its gamepads itself, so it's allowed to use input devices (major 13) it creates.
+// Minors of its gamepads aren't known in advance, which also lets it open input devices of the host.
+func (d *DockerBackend) gamepadHostConfig(hostConfig *containerHostConfig) {
+	if d.uinputDevice == "" {
+		return
+	}
+
+	hostConfig.Devices = append(hostConfig.Devices, containerDevice{
+		PathOnHost:        d.uinputDevice,
+		PathInContainer:   "/dev/uinput",
+		CgroupPermissions: "rwm",
+	})
+	hostConfig.DeviceCgroupRules = append(hostConfig.DeviceCgroupRules, "c 13:* rwm")
+}
+
+// saveHostConfig mounts a directory of the saves of the player over each of the comma-separated save paths of the app
+func saveHostConfig(hostConfig *containerHostConfig, savesDir, savePaths string) error {
+	if savesDir == "" || savePaths == "" {
+		return nil
+	}
+
+	for i, path := range strings.Split(savePaths, ",") {
+		path = strings.TrimSpace(path)
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("save path %q isn't absolute", path)
+		}
+
+		hostDir := filepath.Join(savesDir, strconv.Itoa(i))
+		if err := os.MkdirAll(hostDir, 0755); err != nil {
+			return err
+		}
+		hostConfig.Binds = append(hostConfig.Binds, hostDir+":"+path)
+	}
+
+	return nil
+}
+
+type containerCreateRequest struct {
+	Image      string              `json:"Image"`
+	Env        []string            `json:"Env"`
+	Labels     map[string]string   `json:"Labels"`
+	HostConfig containerHostConfig `json:"HostConfig"`
+}
+
+type containerCreateResponse struct {
+	ID       string   `json:"Id"`
+	Warnings []string `json:"Warnings"`
+}
+
+func (d *DockerBackend) Start(conf *Config) error {
+	log.Printf("[%s] Spinning off VM\n", conf.ID)
+
+	env, err := readEnvFile(filepath.Join(settings.AppConfDir, conf.AppName+".env"))
+	if err != nil {
+		return err
+	}
+	env = append(env,
+		"videoport="+strconv.Itoa(conf.VideoRelayPort),
+		"audioport="+strconv.Itoa(conf.AudioRelayPort),
+		"wsport="+strconv.Itoa(conf.SyncPort),
+		"controlport="+strconv.Itoa(conf.ControlPort),
+		"videobitrate="+strconv.Itoa(conf.VideoBitrate),
+		"videocodec="+conf.VideoCodec,
+	)
+
+	// Apps of several devices may share the files and image of one of them
+	appDir := conf.AppName
+	if dir := envValue(env, "appdir"); dir != "" {
+		appDir = dir
+	}
+	appPath, err := filepath.Abs(filepath.Join(settings.AppsDir, appDir))
+	if err != nil {
+		return err
+	}
+
+	hostConfig := containerHostConfig{
+		Binds: []string{appPath + ":/appvm/app"},
+	}
+	if err := saveHostConfig(&hostConfig, conf.SavesDir, envValue(env, "savepaths")); err != nil {
+		return err
+	}
+	d.gamepadHostConfig(&hostConfig)
+
+	var created containerCreateResponse
+	err = d.call(http.MethodPost, "/containers/create", url.Values{"name": {conf.ID}}, containerCreateRequest{
+		Image:      fmt.Sprintf("%s:%s", settings.VMImage, appDir),
+		Env:        env,
+		Labels:     map[string]string{"copegaming.app": conf.AppName},
+		HostConfig: hostConfig,
+	}, &created)
+	if err != nil {
+		return err
+	}
+	for _, w := range created.Warnings {
+		log.Printf("[%s] Docker warning: %s\n", conf.ID, w)
+	}
+
+	if err := d.call(http.MethodPost, "/containers/"+conf.ID+"/start", nil, nil, nil); err != nil {
+		_ = d.remove(conf.ID)
+		return err
+	}
+
+	return nil
+}
+
+func (d *DockerBackend) Stop(id string) error {
+	log.Printf("[%s] Stopping VM\n", id)
+
+	err := d.call(http.MethodPost, "/containers/"+id+"/stop", url.Values{"t": {"5"}}, nil, nil)
+	if err != nil {
+		log.Printf("[%s] Couldn't stop VM gracefully: %s\n", id, err)
+	}
+
+	return d.remove(id)
+}
+
+func (d *DockerBackend) remove(id string) error {
+	return d.call(http.MethodDelete, "/containers/"+id, url.Values{"force": {"1"}, "v": {"1"}}, nil, nil)
+}
+
+type containerInspectResponse struct {
+	State struct {
+		Status    string `json:"Status"`
+		Running   bool   `json:"Running"`
+		OOMKilled bool   `json:"OOMKilled"`
+		ExitCode  int    `json:"ExitCode"`
+		Error     string `json:"Error"`
+	} `json:"State"`
+}
+
+func (d *DockerBackend) Status(id string) (*Status, error) {
+	var inspect containerInspectResponse
+	if err := d.call(http.MethodGet, "/containers/"+id+"/json", nil, nil, &inspect); err != nil {
+		return nil, err
+	}
+
+	s := &Status{
+		ExitCode: inspect.State.ExitCode,
+		Error:    inspect.State.Error,
+	}
+	switch inspect.State.Status {
+	case "created":
+		s.State = StateCreated
+	case "running", "restarting", "paused":
+		s.State = StateRunning
+	case "exited", "dead":
+		s.State = StateExited
+	default:
+		s.State = StateUnknown
+	}
+	if inspect.State.OOMKilled && s.Error == "" {
+		s.Error = "out of memory"
+	}
+
+	return s, nil
+}
+
+func (d *DockerBackend) Logs(id string, tail int) (string, error) {
+	query := url.Values{
+		"stdout": {"1"},
+		"stderr": {"1"},
+		"tail":   {strconv.Itoa(tail)},
+	}
+	resp, err := d.do(d.client, http.MethodGet, "/containers/"+id+"/logs", query, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	return demuxLogs(resp.Body)
+}
+
+type containerWaitResponse struct {
+	StatusCode int `json:"StatusCode"`
+	Error      *struct {
+		Message string `json:"Message"`
+	} `json:"Error"`
+}
+
+func (d *DockerBackend) Wait(id string) (*Status, error) {
+	resp, err := d.do(d.waitClient, http.MethodPost, "/containers/"+id+"/wait", url.Values{"condition": {"not-running"}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var wait containerWaitResponse
+	if err := json.NewDecoder(resp.Body).Decode(&wait); err != nil {
+		return nil, err
+	}
+
+	s := &Status{State: StateExited, ExitCode: wait.StatusCode}
+	if wait.Error != nil {
+		s.Error = wait.Error.Message
+	}
+
+	return s, nil
+}
+
+// demuxLogs strips the stream headers Docker adds to the logs of containers without TTY.
+// Each frame is prefixed with 8 bytes: stream type, 3 zero bytes and big endian payload size.
+func demuxLogs(r io.Reader) (string, error) {
+	var out strings.Builder
+	header := make([]byte, 8)
+
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return out.String(), nil
+			}
+			return out.String(), err
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(&out, r, size); err != nil {
+			return out.String(), err
+		}
+	}
+}
+
+// readEnvFile reads the KEY=VALUE lines of an app env file, skipping blank lines and # comments
+func readEnvFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var env []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.Contains(line, "=") {
+			return nil, fmt.Errorf("invalid line in %s: %s", path, line)
+		}
+		env = append(env, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+// envValue returns the value of the key in KEY=VALUE lines, empty if it's missing
+func envValue(env []string, key string) string {
+	for _, line := range env {
+		if strings.HasPrefix(line, key+"=") {
+			return strings.TrimPrefix(line, key+"=")
+		}
+	}
+
+	return ""
+}
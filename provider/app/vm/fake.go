@@ -0,0 +1,165 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var ErrVMNotFound = errors.New("vm not found")
+
+type fakeVM struct {
+	conf   Config
+	status Status
+	logs   []string
+	done   chan struct{}
+}
+
+// FakeBackend runs VMs in memory only.
+// It is meant for running sessions without Docker, e.g. in tests.
+type FakeBackend struct {
+	// If set, Start fails with this error
+	StartErr error
+
+	vms map[string]*fakeVM
+	mu  sync.Mutex
+}
+
+func NewFakeBackend() *FakeBackend {
+	return &FakeBackend{
+		vms: make(map[string]*fakeVM),
+	}
+}
+
+func (f *FakeBackend) Start(conf *Config) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.StartErr != nil {
+		return f.StartErr
+	}
+	if _, ok := f.vms[conf.ID]; ok {
+		return fmt.Errorf("vm %s already exists", conf.ID)
+	}
+
+	f.vms[conf.ID] = &fakeVM{
+		conf:   *conf,
+		status: Status{State: StateRunning},
+		logs:   []string{fmt.Sprintf("starting %s", conf.AppName)},
+		done:   make(chan struct{}),
+	}
+
+	return nil
+}
+
+func (f *FakeBackend) Stop(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	v, ok := f.vms[id]
+	if !ok {
+		return ErrVMNotFound
+	}
+	if v.status.State == StateRunning {
+		v.status = Status{State: StateExited}
+		close(v.done)
+	}
+	delete(f.vms, id)
+
+	return nil
+}
+
+// Exit simulates the VM terminating by itself with the given exit code
+func (f *FakeBackend) Exit(id string, exitCode int, logLines ...string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	v, ok := f.vms[id]
+	if !ok {
+		return ErrVMNotFound
+	}
+	if v.status.State != StateRunning {
+		return nil
+	}
+
+	v.logs = append(v.logs, logLines...)
+	v.status = Status{State: StateExited, ExitCode: exitCode}
+	close(v.done)
+
+	return nil
+}
+
+// IDs returns IDs of all VMs which have been started and not stopped yet
+func (f *FakeBackend) IDs() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ids := make([]string, 0, len(f.vms))
+	for id := range f.vms {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// Config returns the config the VM was started with
+func (f *FakeBackend) Config(id string) (*Config, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	v, ok := f.vms[id]
+	if !ok {
+		return nil, ErrVMNotFound
+	}
+	conf := v.conf
+
+	return &conf, nil
+}
+
+func (f *FakeBackend) Status(id string) (*Status, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	v, ok := f.vms[id]
+	if !ok {
+		return nil, ErrVMNotFound
+	}
+	s := v.status
+
+	return &s, nil
+}
+
+func (f *FakeBackend) Logs(id string, tail int) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	v, ok := f.vms[id]
+	if !ok {
+		return "", ErrVMNotFound
+	}
+
+	lines := v.logs
+	if tail >= 0 && len(lines) > tail {
+		lines = lines[len(lines)-tail:]
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func (f *FakeBackend) Wait(id string) (*Status, error) {
+	f.mu.Lock()
+	v, ok := f.vms[id]
+	f.mu.Unlock()
+	if !ok {
+		return nil, ErrVMNotFound
+	}
+
+	<-v.done
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s := v.status
+
+	return &s, nil
+}
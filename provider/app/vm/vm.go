@@ -1,40 +1,97 @@
 package vm
 
 import (
-	"log"
-	"os/exec"
-	"strconv"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"provider/settings"
+)
+
+type State string
+
+const (
+	StateCreated State = "created"
+	StateRunning State = "running"
+	StateExited  State = "exited"
+	StateUnknown State = "unknown"
 )
 
-func StartVM(id string, appName string, videoRelayPort, audioRelayPort, syncPort int) error {
-	log.Printf("[%s] Spinning off VM\n", id)
+// Config describes a VM that runs a single app for a single session
+type Config struct {
+	ID             string
+	AppName        string
+	VideoRelayPort int
+	AudioRelayPort int
+	SyncPort       int
+	// Port the encoder receives bitrate targets from
+	ControlPort int
+	// Initial bitrate of the video encoder in bps
+	VideoBitrate int
+	// Codec the encoder streams with, as named by the codec package
+	VideoCodec string
+	// Host directory of the saves of the player, its subdirectory n is mounted over the nth save path of the app.
+	// Saves aren't kept if empty.
+	SavesDir string
+}
+
+// Status is the state of a VM as reported by its backend.
+// ExitCode and Error are only meaningful once the VM has exited.
+type Status struct {
+	State    State
+	ExitCode int
+	Error    string
+}
+
+func (s *Status) Failed() bool {
+	return s.State == StateExited && (s.ExitCode != 0 || s.Error != "")
+}
 
-	params := []string{
-		id,
-		strconv.Itoa(videoRelayPort),
-		strconv.Itoa(audioRelayPort),
-		strconv.Itoa(syncPort),
-		appName,
+func (s *Status) String() string {
+	if s.State != StateExited {
+		return string(s.State)
 	}
-	cmd := exec.Command("./startVM.sh", params...)
-	if err := cmd.Start(); err != nil {
-		return err
+	if s.Error != "" {
+		return fmt.Sprintf("exited with code %d: %s", s.ExitCode, s.Error)
 	}
+	return fmt.Sprintf("exited with code %d", s.ExitCode)
+}
 
-	return nil
+// Backend runs app VMs.
+type Backend interface {
+	// Start creates and starts a VM. It returns once the VM is running or failed to start.
+	Start(conf *Config) error
+	// Stop stops the VM and releases all of its resources.
+	Stop(id string) error
+	Status(id string) (*Status, error)
+	// Logs returns the last tail lines of the VM output.
+	Logs(id string, tail int) (string, error)
+	// Wait blocks until the VM is no longer running and returns its final status.
+	Wait(id string) (*Status, error)
 }
 
-func StopVM(id, appName string) error {
-	log.Printf("[%s] Stopping VM\n", id)
+func NewBackend(name string) (Backend, error) {
+	switch name {
+	case "docker":
+		return NewDockerBackend(settings.DockerHost), nil
+	case "fake":
+		return NewFakeBackend(), nil
+	}
 
-	params := []string{
-		id,
-		appName,
+	return nil, fmt.Errorf("unknown VM backend %q", name)
+}
+
+// ListApps returns names of all apps which have a config and thus can be run in a VM
+func ListApps() ([]string, error) {
+	confs, err := filepath.Glob(filepath.Join(settings.AppConfDir, "*.env"))
+	if err != nil {
+		return nil, err
 	}
-	cmd := exec.Command("./stopVM.sh", params...)
-	if err := cmd.Start(); err != nil {
-		return err
+
+	apps := make([]string, 0, len(confs))
+	for _, conf := range confs {
+		apps = append(apps, strings.TrimSuffix(filepath.Base(conf), ".env"))
 	}
 
-	return nil
+	return apps, nil
 }
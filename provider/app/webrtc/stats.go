@@ -0,0 +1,104 @@
+package webrtc
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Clock rate of video RTP timestamps, used to convert jitter to seconds
+const videoClockRate = 90000
+
+// Stats is the quality of the video stream sent to the player
+type Stats struct {
+	// Round trip time of the selected ICE candidate pair in seconds
+	RTT         float64 `json:"rtt"`
+	PacketsSent uint64  `json:"packetsSent"`
+	BytesSent   uint64  `json:"bytesSent"`
+	// Fraction of packets lost since the previous receiver report
+	PacketLoss  float64 `json:"packetLoss"`
+	PacketsLost int32   `json:"packetsLost"`
+	// Interarrival jitter in seconds
+	Jitter     float64 `json:"jitter"`
+	FramesSent uint64  `json:"framesSent"`
+	// Frames per second since the previous call of Stats
+	FrameRate float64 `json:"frameRate"`
+}
+
+type videoStats struct {
+	packetsSent uint64
+	bytesSent   uint64
+	framesSent  uint64
+	packetLoss  float64
+	packetsLost int32
+	jitter      float64
+	// Frames sent when stats were last read, to compute the frame rate
+	lastFrames uint64
+	lastRead   time.Time
+	mu         sync.Mutex
+}
+
+func newVideoStats() *videoStats {
+	return &videoStats{lastRead: time.Now()}
+}
+
+func (s *videoStats) onPacketSent(packet []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.packetsSent++
+	s.bytesSent += uint64(len(packet))
+	// Marker bit is set on the last packet of a frame
+	if len(packet) > 1 && packet[1]&0x80 != 0 {
+		s.framesSent++
+	}
+}
+
+func (s *videoStats) onRTCP(packets []rtcp.Packet) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range packets {
+		rr, ok := p.(*rtcp.ReceiverReport)
+		if !ok {
+			continue
+		}
+		for _, r := range rr.Reports {
+			s.packetLoss = float64(r.FractionLost) / 256
+			s.packetsLost = int32(r.TotalLost)
+			s.jitter = float64(r.Jitter) / videoClockRate
+		}
+	}
+}
+
+// Stats returns the quality of the stream since the session started
+func (w *WebRTC) Stats() Stats {
+	s := w.videoStats
+	s.mu.Lock()
+	now := time.Now()
+	stats := Stats{
+		PacketsSent: s.packetsSent,
+		BytesSent:   s.bytesSent,
+		PacketLoss:  s.packetLoss,
+		PacketsLost: s.packetsLost,
+		Jitter:      s.jitter,
+		FramesSent:  s.framesSent,
+	}
+	if elapsed := now.Sub(s.lastRead).Seconds(); elapsed > 0 {
+		stats.FrameRate = float64(s.framesSent-s.lastFrames) / elapsed
+	}
+	s.lastFrames = s.framesSent
+	s.lastRead = now
+	s.mu.Unlock()
+
+	for _, report := range w.conn.GetStats() {
+		pair, ok := report.(webrtc.ICECandidatePairStats)
+		if ok && pair.Nominated {
+			stats.RTT = pair.CurrentRoundTripTime
+		}
+	}
+
+	return stats
+}
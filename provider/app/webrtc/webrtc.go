@@ -5,38 +5,63 @@ import (
 	"log"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"provider/app/codec"
+	"provider/constants"
+	"provider/pkg/inputproto"
+	"provider/pkg/rtpqueue"
 	"provider/pkg/socket"
 	"provider/settings"
 	"provider/utils"
 
 	"github.com/pion/interceptor"
-	"github.com/pion/rtp"
+	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
 )
 
 type WebRTC struct {
 	logID        string
 	conn         *webrtc.PeerConnection
-	imageChannel chan *rtp.Packet
-	audioChannel chan *rtp.Packet
+	videoCodec   codec.Codec
+	imageChannel *rtpqueue.Queue
+	audioChannel *rtpqueue.Queue
 	eventChannel chan *Packet
 	inputTrack   *webrtc.DataChannel
 	healthTrack  *webrtc.DataChannel
 	// Connection close signal channel
 	closed       chan struct{}
-	// Ensure that exit callback function will be called only once
-	exitOnce     sync.Once
+	// Ensure that tracks are streamed only once, even if the connection is reestablished
+	streamOnce   sync.Once
+	// Receives RTCP feedback of the video track
+	videoRTCPCb  OnRTCPCallback
+	keyframeCb   OnKeyframeCallback
+	videoStats   *videoStats
+	// Seat of the player in the session, which tags its input, accessed atomically
+	seat         int32
 }
 
 type Packet struct {
 	Type string `json:"type"`
 	Data string `json:"data"`
+	// Frames of the binary input protocol, set instead of Type and Data for binary messages
+	Frames []byte `json:"-"`
+	// When the input was received, to measure its latency
+	ReceivedAt time.Time `json:"-"`
+	// Seat of the player who sent the input, 0 for the player who started the session
+	Seat int `json:"-"`
+}
+
+type inputProtocolData struct {
+	Versions []int `json:"versions"`
 }
 
 type OnIceCallback func(candidate string)
-type OnExitCallback func()
+type OnConnectedCallback func()
+type OnDisconnectedCallback func()
+type OnRTCPCallback func(packets []rtcp.Packet)
+type OnKeyframeCallback func()
 
 var (
 	webrtcSettings webrtc.SettingEngine
@@ -45,9 +70,22 @@ var (
 
 const MaxMissedHealthCheck int = 5
 
-func NewWebRTC(logID string, videoStream, audioStream chan *rtp.Packet, inputStream chan *Packet) (*WebRTC, error) {
+// NewWebRTC creates the connection to the player, only the negotiated video codec is offered.
+// Connections of spectators have a nil inputStream.
+func NewWebRTC(logID string, videoCodec codec.Codec, videoStream, audioStream *rtpqueue.Queue, inputStream chan *Packet) (*WebRTC, error) {
 	m := &webrtc.MediaEngine{}
-	if err := m.RegisterDefaultCodecs(); err != nil {
+	if err := videoCodec.Register(m); err != nil {
+		return nil, err
+	}
+	if err := m.RegisterCodec(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{
+			MimeType:    webrtc.MimeTypeOpus,
+			ClockRate:   48000,
+			Channels:    2,
+			SDPFmtpLine: "minptime=10;useinbandfec=1",
+		},
+		PayloadType: 111,
+	}, webrtc.RTPCodecTypeAudio); err != nil {
 		return nil, err
 	}
 
@@ -100,17 +138,37 @@ func NewWebRTC(logID string, videoStream, audioStream chan *rtp.Packet, inputStr
 	return &WebRTC{
 		logID:        logID,
 		conn:         conn,
+		videoCodec:   videoCodec,
 		imageChannel: videoStream,
 		audioChannel: audioStream,
 		eventChannel: inputStream,
 		closed:       make(chan struct{}),
+		videoStats:   newVideoStats(),
 	}, nil
 }
 
-func (w *WebRTC) StartClient(vCodec string, iceCb OnIceCallback, exitCb OnExitCallback) (string, error) {
+// OnVideoRTCP sets the callback receiving RTCP feedback of the video track, it must be called before StartClient
+func (w *WebRTC) OnVideoRTCP(cb OnRTCPCallback) {
+	w.videoRTCPCb = cb
+}
+
+// OnKeyframeRequest sets the callback called when the player asks for a keyframe, it must be called before StartClient
+func (w *WebRTC) OnKeyframeRequest(cb OnKeyframeCallback) {
+	w.keyframeCb = cb
+}
+
+// SetSeat tags the input received from now on with the seat of the player
+func (w *WebRTC) SetSeat(seat int) {
+	atomic.StoreInt32(&w.seat, int32(seat))
+}
+
+// StartClient creates the offer to the player.
+// connectedCb is called whenever the player gets connected, disconnectedCb whenever the connection is lost,
+// since the connection may be reestablished in between.
+func (w *WebRTC) StartClient(iceCb OnIceCallback, connectedCb OnConnectedCallback, disconnectedCb OnDisconnectedCallback) (string, error) {
 	log.Printf("[%s] Start WebRTC..\n", w.logID)
 
-	videoTrack, err := w.addVideoTrack(vCodec)
+	videoTrack, err := w.addVideoTrack()
 	if err != nil {
 		return "", err
 	}
@@ -120,12 +178,15 @@ func (w *WebRTC) StartClient(vCodec string, iceCb OnIceCallback, exitCb OnExitCa
 		return "", err
 	}
 
-	err = w.addInputTrack(true)
-	if err != nil {
-		return "", err
+	// Spectators only watch, they have no input stream
+	if w.eventChannel != nil {
+		err = w.addInputTrack(true)
+		if err != nil {
+			return "", err
+		}
 	}
 
-	err = w.addHealthCheck(exitCb)
+	err = w.addHealthCheck(disconnectedCb)
 	if err != nil {
 		return "", err
 	}
@@ -133,13 +194,17 @@ func (w *WebRTC) StartClient(vCodec string, iceCb OnIceCallback, exitCb OnExitCa
 	w.conn.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
 		if state == webrtc.ICEConnectionStateConnected {
 			log.Printf("[%s] ICE Connected succeeded\n", w.logID)
-			w.startStreamingVideo(videoTrack)
-			w.startStreamingAudio(audioTrack)
+			w.streamOnce.Do(func() {
+				w.startStreamingVideo(videoTrack)
+				w.startStreamingAudio(audioTrack)
+			})
+			connectedCb()
 		}
 
-		if state == webrtc.ICEConnectionStateFailed || state == webrtc.ICEConnectionStateClosed || state == webrtc.ICEConnectionStateDisconnected {
+		// Closed is only reached by stopping the client
+		if state == webrtc.ICEConnectionStateFailed || state == webrtc.ICEConnectionStateDisconnected {
 			log.Printf("[%s] ICE Connected failed: %s\n", w.logID, state)
-			w.exitOnce.Do(exitCb)
+			disconnectedCb()
 		}
 	})
 
@@ -173,9 +238,9 @@ func (w *WebRTC) StartClient(vCodec string, iceCb OnIceCallback, exitCb OnExitCa
 	return encodedOffer, nil
 }
 
-func (w *WebRTC) addVideoTrack(vCodec string) (*webrtc.TrackLocalStaticRTP, error) {
+func (w *WebRTC) addVideoTrack() (*webrtc.TrackLocalStaticRTP, error) {
 	videoTrack, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{
-		MimeType: verbalCodecToMime(vCodec),
+		MimeType: w.videoCodec.MimeType(),
 	}, "video", "pion")
 	if err != nil {
 		return nil, err
@@ -189,18 +254,38 @@ func (w *WebRTC) addVideoTrack(vCodec string) (*webrtc.TrackLocalStaticRTP, erro
 	// Read incoming RTCP packets
 	// Before these packets are returned they are processed by interceptors. For things
 	// like NACK this needs to be called.
+	// Feedback of the player is then used to adapt the bitrate.
 	go func() {
-		rtcpBuf := make([]byte, 1500)
 		for {
-			if _, _, rtcpErr := videoSender.Read(rtcpBuf); rtcpErr != nil {
+			packets, _, rtcpErr := videoSender.ReadRTCP()
+			if rtcpErr != nil {
 				return
 			}
+			w.videoStats.onRTCP(packets)
+			if w.keyframeCb != nil && requestsKeyframe(packets) {
+				w.keyframeCb()
+			}
+			if w.videoRTCPCb != nil {
+				w.videoRTCPCb(packets)
+			}
 		}
 	}()
 
 	return videoTrack, nil
 }
 
+// requestsKeyframe tells whether the player asked for a keyframe with a Picture Loss Indication or a Full Intra Request
+func requestsKeyframe(packets []rtcp.Packet) bool {
+	for _, p := range packets {
+		switch p.(type) {
+		case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+			return true
+		}
+	}
+
+	return false
+}
+
 func (w *WebRTC) addAudioTrack() (*webrtc.TrackLocalStaticRTP, error) {
 	audioTrack, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{
 		MimeType: webrtc.MimeTypeOpus,
@@ -246,6 +331,15 @@ func (w *WebRTC) addInputTrack(unreliable bool) error {
 	}
 	w.inputTrack = inputTrack
 
+	// Players switch to the binary input protocol once told it's supported, older ones keep sending JSON
+	inputTrack.OnOpen(func() {
+		versions, _ := json.Marshal(inputProtocolData{Versions: []int{inputproto.Version}})
+		msg, _ := json.Marshal(Packet{Type: constants.InputProtocol, Data: string(versions)})
+		if err := inputTrack.SendText(string(msg)); err != nil {
+			log.Printf("[%s] Couldn't announce input protocol: %s\n", w.logID, err)
+		}
+	})
+
 	inputTrack.OnMessage(func(rawMsg webrtc.DataChannelMessage) {
 		defer func() {
 			if r := recover(); r != nil {
@@ -253,18 +347,28 @@ func (w *WebRTC) addInputTrack(unreliable bool) error {
 			}
 		}()
 
+		receivedAt := time.Now()
+		seat := int(atomic.LoadInt32(&w.seat))
+		if !rawMsg.IsString {
+			w.eventChannel <- &Packet{Frames: rawMsg.Data, ReceivedAt: receivedAt, Seat: seat}
+			return
+		}
+
 		var msg Packet
 		if err := json.Unmarshal(rawMsg.Data, &msg); err != nil {
 			log.Printf("[%s] Couldn't parse webrtc data message: %s\n", w.logID, err)
 			return
 		}
 
+		msg.ReceivedAt = receivedAt
+		msg.Seat = seat
 		w.eventChannel <- &msg
 	})
 	return nil
 }
 
-func (w *WebRTC) addHealthCheck(exitCb OnExitCallback) error {
+// addHealthCheck reports the connection as lost once the player misses MaxMissedHealthCheck checks in a row
+func (w *WebRTC) addHealthCheck(disconnectedCb OnDisconnectedCallback) error {
 	healthTrack, err := w.conn.CreateDataChannel("health-check", nil)
 	if err != nil {
 		return err
@@ -282,12 +386,12 @@ func (w *WebRTC) addHealthCheck(exitCb OnExitCallback) error {
 			default:
 				lock.Lock()
 				missedHealthCheckCounts += 1
-				if missedHealthCheckCounts == MaxMissedHealthCheck {
+				missed := missedHealthCheckCounts
+				lock.Unlock()
+				if missed == MaxMissedHealthCheck {
 					log.Printf("[%s] Health-check failed", w.logID)
-					w.exitOnce.Do(exitCb)
-					return
+					disconnectedCb()
 				}
-				lock.Unlock()
 				time.Sleep(2 * time.Second)
 			}
 		}
@@ -302,6 +406,19 @@ func (w *WebRTC) addHealthCheck(exitCb OnExitCallback) error {
 	return nil
 }
 
+// SendStatus sends a packet to the player over the health-check channel, which is reliable unlike the input one
+func (w *WebRTC) SendStatus(packet Packet) error {
+	if w.healthTrack == nil {
+		return webrtc.ErrConnectionClosed
+	}
+
+	msg, err := json.Marshal(packet)
+	if err != nil {
+		return err
+	}
+	return w.healthTrack.SendText(string(msg))
+}
+
 func (w *WebRTC) SetRemoteSDP(remoteSDP string) error {
 	var answer webrtc.SessionDescription
 
@@ -339,39 +456,56 @@ func (w *WebRTC) AddCandidate(candidate string) error {
 }
 
 func (w *WebRTC) StopClient() {
-	w.inputTrack.Close()
-	w.healthTrack.Close()
+	// Tracks may be missing if the client failed to start
+	if w.inputTrack != nil {
+		w.inputTrack.Close()
+	}
+	if w.healthTrack != nil {
+		w.healthTrack.Close()
+	}
 	w.conn.Close()
-	w.closed <- struct{}{}
+	// Closing instead of sending as the health-check routine may have already returned
+	close(w.closed)
+}
+
+func (w *WebRTC) isClosed() bool {
+	select {
+	case <-w.closed:
+		return true
+	default:
+		return false
+	}
 }
 
 func (w *WebRTC) startStreamingVideo(videoTrack *webrtc.TrackLocalStaticRTP) {
 	go func() {
-		for packet := range w.imageChannel {
-			if err := videoTrack.WriteRTP(packet); err != nil {
+		buf := make([]byte, rtpqueue.MaxPacketSize)
+		for {
+			n, ok := w.imageChannel.Pop(buf)
+			if !ok || w.isClosed() {
+				// The stream may be relayed to another connection of the player
+				return
+			}
+			if _, err := videoTrack.Write(buf[:n]); err != nil {
 				log.Printf("[%s] Error when writing RTP to video track: %s\n", w.logID, err)
+				continue
 			}
+			w.videoStats.onPacketSent(buf[:n])
 		}
 	}()
 }
 
 func (w *WebRTC) startStreamingAudio(audioTrack *webrtc.TrackLocalStaticRTP) {
 	go func() {
-		for packet := range w.audioChannel {
-			if err := audioTrack.WriteRTP(packet); err != nil {
+		buf := make([]byte, rtpqueue.MaxPacketSize)
+		for {
+			n, ok := w.audioChannel.Pop(buf)
+			if !ok || w.isClosed() {
+				return
+			}
+			if _, err := audioTrack.Write(buf[:n]); err != nil {
 				log.Printf("[%s] Error when writing RTP to opus track: %s\n", w.logID, err)
 			}
 		}
 	}()
 }
-
-func verbalCodecToMime(vCodec string) string {
-	switch vCodec {
-	case "h264":
-		return webrtc.MimeTypeH264
-	case "vpx":
-		return webrtc.MimeTypeVP8
-	default:
-		return webrtc.MimeTypeVP8
-	}
-}
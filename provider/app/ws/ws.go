@@ -3,10 +3,12 @@ package ws
 import (
 	"encoding/json"
 	"log"
+	"net/http"
 	"net/url"
 	"sync"
 
 	"provider/constants"
+	"provider/utils"
 
 	"github.com/gorilla/websocket"
 )
@@ -18,20 +20,68 @@ type Message struct {
 	Data       string                `json:"data"`
 }
 
+// Identity lets the coordinator recognize a provider when it reconnects, so running sessions stay reachable
+type Identity struct {
+	ID     string
+	Secret string
+}
+
+// NewIdentity creates a random identity which lasts as long as the provider runs
+func NewIdentity() *Identity {
+	return &Identity{
+		ID:     utils.RandString(16),
+		Secret: utils.SecretString(32),
+	}
+}
+
+// Connection to the coordinator, which is reconnected in place so sessions keep using it
 type Connection struct {
-	conn *websocket.Conn
-	mu   sync.Mutex
+	addr     string
+	token    string
+	identity *Identity
+	conn     *websocket.Conn
+	mu       sync.Mutex
 }
 
-func Connect(addr string) (*Connection, error) {
-	u := url.URL{Scheme: "ws", Host: addr, Path: "/ws"}
+// Connect dials the coordinator and authenticates with the token of the owner
+func Connect(addr, token string) (*Connection, error) {
+	return ConnectAs(addr, token, nil)
+}
 
-	c, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
-	if err != nil {
+// ConnectAs dials the coordinator as the provider of the identity, a nil identity gets a new ID on each connection
+func ConnectAs(addr, token string, identity *Identity) (*Connection, error) {
+	c := &Connection{addr: addr, token: token, identity: identity}
+	if err := c.Reconnect(); err != nil {
 		return nil, err
 	}
 
-	return &Connection{conn: c}, nil
+	return c, nil
+}
+
+// Reconnect dials the coordinator again and replaces the previous connection
+func (c *Connection) Reconnect() error {
+	u := url.URL{Scheme: "ws", Host: c.addr, Path: "/ws"}
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+c.token)
+	if c.identity != nil {
+		header.Set("X-Provider-ID", c.identity.ID)
+		header.Set("X-Provider-Secret", c.identity.Secret)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(u.String(), header)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.conn = conn
+
+	return nil
 }
 
 func (c *Connection) Send(v interface{}) error {
@@ -41,8 +91,12 @@ func (c *Connection) Send(v interface{}) error {
 }
 
 func (c *Connection) ReadMsg() (*Message, error) {
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+
 	for {
-		msgType, rawMsg, err := c.conn.ReadMessage()
+		msgType, rawMsg, err := conn.ReadMessage()
 		if err != nil {
 			return nil, err
 		}
@@ -62,5 +116,8 @@ func (c *Connection) ReadMsg() (*Message, error) {
 }
 
 func (c *Connection) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	return c.conn.Close()
 }
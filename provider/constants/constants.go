@@ -8,9 +8,62 @@ const StatsMessage MessageType = "stats"
 const StartMessage MessageType = "start"
 const SDPMessage MessageType = "sdp"
 const IceCandidateMessage MessageType = "ice-candidate"
+const ErrorMessage MessageType = "error"
+const StreamingMessage MessageType = "streaming"
+const EndedMessage MessageType = "ended"
+const EndMessage MessageType = "end"
+const SessionStatsMessage MessageType = "session-stats"
+const ResumeMessage MessageType = "resume"
+const SpectateMessage MessageType = "spectate"
+const StopSpectatingMessage MessageType = "stop-spectating"
+const SeatMessage MessageType = "seat"
+const LeaveSeatMessage MessageType = "leave-seat"
+
+// ErrorStage tells at which step of starting a session an error happened
+type ErrorStage string
+
+const AdmissionStage ErrorStage = "admission"
+const ConfigureStage ErrorStage = "configure"
+const RelayStage ErrorStage = "relay"
+const VMStage ErrorStage = "vm"
+const WebRTCStage ErrorStage = "webrtc"
+const ResumeStage ErrorStage = "resume"
+const SpectateStage ErrorStage = "spectate"
+const SeatStage ErrorStage = "seat"
+
+type ErrorCode string
+
+const BusyError ErrorCode = "busy"
+const InvalidConfigError ErrorCode = "invalid-config"
+const CodecError ErrorCode = "codec"
+const ListenerError ErrorCode = "listener"
+const RelayError ErrorCode = "relay"
+const VMStartError ErrorCode = "vm-start"
+const VMExitedError ErrorCode = "vm-exited"
+const WebRTCSetupError ErrorCode = "webrtc-setup"
+const SDPError ErrorCode = "sdp"
+const SessionNotFoundError ErrorCode = "session-not-found"
+const SpectatorLimitError ErrorCode = "spectator-limit"
+const SeatError ErrorCode = "seat"
 
 const KeyUp = "KEYUP"
 const KeyDown = "KEYDOWN"
 const MouseMove = "MOUSEMOVE"
 const MouseUp = "MOUSEUP"
 const MouseDown = "MOUSEDOWN"
+const MouseWheel = "MOUSEWHEEL"
+const MouseMoveRelative = "MOUSEMOVEREL"
+const GamepadConnect = "GAMEPADCONNECT"
+const GamepadDisconnect = "GAMEPADDISCONNECT"
+const GamepadButton = "GAMEPADBUTTON"
+const GamepadAxis = "GAMEPADAXIS"
+const GamepadTrigger = "GAMEPADTRIGGER"
+const TouchStart = "TOUCHSTART"
+const TouchMove = "TOUCHMOVE"
+const TouchEnd = "TOUCHEND"
+
+// Sent by the provider when the input channel opens, with the binary input protocol versions it supports
+const InputProtocol = "INPUTPROTOCOL"
+
+// Sent by the provider over the health-check channel with the input latency of the session
+const LatencyReport = "LATENCY"
@@ -6,8 +6,11 @@ import (
 	"log"
 	"time"
 
+	"provider/app/codec"
+	"provider/app/saves"
 	"provider/app/session"
 	"provider/app/stats"
+	"provider/app/vm"
 	"provider/app/ws"
 	"provider/constants"
 	"provider/settings"
@@ -16,18 +19,24 @@ import (
 )
 
 type JoinData struct {
-	Role       string  `json:"role"`
-	OwnerID    string  `json:"ownerID"`
-	HostName   string  `json:"hostName"`
-	Platform   string  `json:"platform"`
-	CpuName    string  `json:"cpuName"`
-	CpuNum     int     `json:"cpuNum"`
-	MemSize    float64 `json:"memSize"`
-	CpuPercent float64 `json:"cpuPercent"`
-	MemPercent float64 `json:"memPercent"`
+	Role           string   `json:"role"`
+	HostName       string   `json:"hostName"`
+	Platform       string   `json:"platform"`
+	CpuName        string   `json:"cpuName"`
+	CpuNum         int      `json:"cpuNum"`
+	MemSize        float64  `json:"memSize"`
+	CpuPercent     float64  `json:"cpuPercent"`
+	MemPercent     float64  `json:"memPercent"`
+	MaxSessions    int      `json:"maxSessions"`
+	ActiveSessions int      `json:"activeSessions"`
+	Apps           []string `json:"apps"`
+	// Video codecs the provider can stream, in order of preference
+	Codecs []codec.Codec `json:"codecs"`
+	// Sessions still running when the provider joins again after losing the connection
+	Sessions []session.SessionInfo `json:"sessions"`
 }
 
-func joinAsProvider(ownerID string, conn *ws.Connection) error {
+func joinAsProvider(conn *ws.Connection, hub *session.Hub) error {
 	sysInfo, err := stats.GetSysInfo()
 	if err != nil {
 		return err
@@ -36,17 +45,25 @@ func joinAsProvider(ownerID string, conn *ws.Connection) error {
 	if err != nil {
 		return err
 	}
+	apps, err := vm.ListApps()
+	if err != nil {
+		return err
+	}
 
 	joinData, err := json.Marshal(JoinData{
-		Role:       "provider",
-		OwnerID:    ownerID,
-		HostName:   sysInfo.HostName,
-		Platform:   sysInfo.Platform,
-		CpuName:    sysInfo.CpuName,
-		CpuNum:     sysInfo.CpuNum,
-		MemSize:    sysInfo.MemSize,
-		CpuPercent: sysStats.CpuPercent,
-		MemPercent: sysStats.MemPercent,
+		Role:           "provider",
+		HostName:       sysInfo.HostName,
+		Platform:       sysInfo.Platform,
+		CpuName:        sysInfo.CpuName,
+		CpuNum:         sysInfo.CpuNum,
+		MemSize:        sysInfo.MemSize,
+		CpuPercent:     sysStats.CpuPercent,
+		MemPercent:     sysStats.MemPercent,
+		MaxSessions:    hub.Capacity(),
+		ActiveSessions: hub.Count(),
+		Apps:           apps,
+		Codecs:         settings.VideoCodecs,
+		Sessions:       hub.Sessions(),
 	})
 	if err != nil {
 		return err
@@ -60,15 +77,15 @@ func joinAsProvider(ownerID string, conn *ws.Connection) error {
 	return conn.Send(msg)
 }
 
-// tryConnect tries to dial and setup a WS connection with Coordinator service
+// tryConnect tries to setup a WS connection with Coordinator service with dial and to join as a provider
 // maxTries = -1 means it will retry forever
-func tryConnect(ownerID, addr string, maxTries int) *ws.Connection {
+func tryConnect(dial func() (*ws.Connection, error), maxTries int, hub *session.Hub) *ws.Connection {
 	count := 0
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 
 	for range ticker.C {
-		conn, err := ws.Connect(addr)
+		conn, err := dial()
 		if err != nil {
 			count++
 			log.Println("Failed to connect to Coordinator", count, err)
@@ -78,7 +95,7 @@ func tryConnect(ownerID, addr string, maxTries int) *ws.Connection {
 			}
 			continue
 		}
-		if err = joinAsProvider(ownerID, conn); err != nil {
+		if err = joinAsProvider(conn, hub); err != nil {
 			conn.Close()
 			count++
 			log.Println("Failed to join as a provider", count, err)
@@ -96,11 +113,12 @@ func tryConnect(ownerID, addr string, maxTries int) *ws.Connection {
 }
 
 type StatsData struct {
-	CpuPercent float64 `json:"cpuPercent"`
-	MemPercent float64 `json:"memPercent"`
+	CpuPercent     float64 `json:"cpuPercent"`
+	MemPercent     float64 `json:"memPercent"`
+	ActiveSessions int     `json:"activeSessions"`
 }
 
-func updateStats(conn *ws.Connection, interval time.Duration) {
+func updateStats(conn *ws.Connection, hub *session.Hub, interval time.Duration) {
 	for {
 		sysStats, err := stats.GetSysStats(interval)
 		if err != nil {
@@ -109,8 +127,9 @@ func updateStats(conn *ws.Connection, interval time.Duration) {
 		}
 
 		statsData, err := json.Marshal(StatsData{
-			CpuPercent: sysStats.CpuPercent,
-			MemPercent: sysStats.MemPercent,
+			CpuPercent:     sysStats.CpuPercent,
+			MemPercent:     sysStats.MemPercent,
+			ActiveSessions: hub.Count(),
 		})
 		if err != nil {
 			log.Println("Couldn't marshal stats data", err)
@@ -126,31 +145,79 @@ func updateStats(conn *ws.Connection, interval time.Duration) {
 	}
 }
 
-var ownerID = flag.String("owner", "", "ID of this computer's owner")
+var token = flag.String("token", "", "Auth token of this computer's owner, issued by Coordinator on login")
+var vmBackendName = flag.String("vm", settings.VMBackend, "VM backend to run apps with (docker or fake)")
+var videoCodecs = flag.String("codecs", codec.Join(settings.VideoCodecs), "Video codecs the VM can encode in order of preference, among h264, vp8 and vp9")
+var syncSaves = flag.Bool("sync-saves", settings.SyncSaves, "Sync saves of players with Coordinator, so they get them back on other providers")
+var uinputDevice = flag.String("uinput", settings.UInputDevice, "uinput device VMs create virtual gamepads with, e.g. /dev/uinput. VMs get access to input devices of this computer, so only use it on a computer dedicated to providing")
+var maxSessions = flag.Int("max-sessions", settings.MaxSessions, "Maximum number of concurrent sessions, 0 to derive from CPUs and memory")
+
+// sessionCapacity returns the configured maximum number of sessions or estimates it from system info
+func sessionCapacity() (int, error) {
+	if *maxSessions > 0 {
+		return *maxSessions, nil
+	}
+
+	sysInfo, err := stats.GetSysInfo()
+	if err != nil {
+		return 0, err
+	}
+
+	return sysInfo.SessionCapacity(settings.SessionCpuNum, settings.SessionMemSize), nil
+}
 
 func main() {
 	flag.Parse()
+	if *token == "" {
+		log.Fatalln("Missing auth token of the owner")
+	}
+
+	codecs, err := codec.ParseList(*videoCodecs)
+	if err != nil {
+		log.Fatalln("Invalid video codecs", err)
+	}
+	settings.VideoCodecs = codecs
+	settings.UInputDevice = *uinputDevice
 
-	hub := session.NewHub()
+	capacity, err := sessionCapacity()
+	if err != nil {
+		log.Fatalln("Couldn't get session capacity", err)
+	}
+	log.Printf("Running at most %d sessions at the same time", capacity)
+	hub := session.NewHub(capacity)
+	if *syncSaves {
+		hub.SyncSaves(saves.NewClient(settings.CoordinatorAddr, *token))
+	}
+
+	vmBackend, err := vm.NewBackend(*vmBackendName)
+	if err != nil {
+		log.Fatalln("Couldn't create VM backend", err)
+	}
 
-	conn := tryConnect(*ownerID, settings.CoordinatorAddr, 1)
+	// Coordinator recognizes this provider when it reconnects, so it can still relay messages of running sessions
+	identity := ws.NewIdentity()
+	conn := tryConnect(func() (*ws.Connection, error) {
+		return ws.ConnectAs(settings.CoordinatorAddr, *token, identity)
+	}, 1, hub)
 	if conn == nil {
 		log.Fatalln("Couldn't connect to coordinator service")
 	}
 	log.Println("Connected to Coordinator service as a Provider")
 
-	go updateStats(conn, 5*time.Second)
+	go updateStats(conn, hub, 5*time.Second)
 
 	for {
 		msg, err := conn.ReadMsg()
 		if err != nil {
-			if _, ok := err.(*websocket.CloseError); ok {
-				log.Println("Reconnecting to Coordinator service..")
-				conn = tryConnect(*ownerID, settings.CoordinatorAddr, -1)
-				log.Println("Connected to Coordinator service")
-			} else {
+			if _, ok := err.(*websocket.CloseError); !ok {
 				log.Println("Error when reading WS message", err)
 			}
+			// Sessions keep sending through the same connection once it's reconnected
+			log.Println("Reconnecting to Coordinator service..")
+			tryConnect(func() (*ws.Connection, error) {
+				return conn, conn.Reconnect()
+			}, -1, hub)
+			log.Println("Connected to Coordinator service")
 			continue
 		}
 
@@ -159,10 +226,61 @@ func main() {
 			log.Printf("Owner's ID: %s", msg.Data)
 			continue
 		} else if msg.Type == constants.StartMessage {
-			s = session.NewSession(msg.SenderID, conn, hub)
-			hub.AddSession(s)
+			s = session.NewSession(msg.SenderID, conn, hub, vmBackend)
+			if err := hub.AddSession(s); err != nil {
+				log.Printf("[%s] Rejected session: %s\n", msg.SenderID, err)
+				s.Reject(err)
+				continue
+			}
+		} else if msg.Type == constants.ResumeMessage {
+			var data session.ResumeData
+			err = json.Unmarshal([]byte(msg.Data), &data)
+			if err == nil {
+				s, err = hub.ResumeSession(msg.SenderID, data.PreviousID)
+			}
+			if err != nil {
+				log.Printf("[%s] Couldn't resume session of %s: %s\n", msg.SenderID, data.PreviousID, err)
+				session.NewSession(msg.SenderID, conn, hub, vmBackend).RejectResume(err)
+				continue
+			}
+		} else if msg.Type == constants.SpectateMessage {
+			var data session.SpectateData
+			err = json.Unmarshal([]byte(msg.Data), &data)
+			if err == nil {
+				s = hub.GetSession(data.HostID)
+				if s == nil {
+					err = session.ErrSessionNotFound
+				}
+			}
+			if err != nil {
+				log.Printf("[%s] Couldn't watch session of %s: %s\n", msg.SenderID, data.HostID, err)
+				session.NewSession(msg.SenderID, conn, hub, vmBackend).RejectSpectator(err)
+				continue
+			}
+			hub.JoinSession(msg.SenderID, s)
+		} else if msg.Type == constants.SeatMessage {
+			var data session.SeatData
+			err = json.Unmarshal([]byte(msg.Data), &data)
+			if err == nil {
+				s = hub.GetSession(data.HostID)
+				if s == nil {
+					err = session.ErrSessionNotFound
+				}
+			}
+			if err != nil {
+				log.Printf("[%s] Couldn't join session of %s: %s\n", msg.SenderID, data.HostID, err)
+				session.NewSession(msg.SenderID, conn, hub, vmBackend).RejectGuest(err)
+				continue
+			}
+			hub.JoinSession(msg.SenderID, s)
 		} else {
 			s = hub.GetSession(msg.SenderID)
+			if s == nil {
+				s = hub.JoinedSession(msg.SenderID)
+			}
+			if s == nil {
+				continue
+			}
 		}
 
 		s.ReceiveMsg(msg)
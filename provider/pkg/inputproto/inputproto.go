@@ -0,0 +1,204 @@
+// Package inputproto encodes input events of players as compact binary frames.
+//
+// A frame is a version byte, an event type byte and a fixed-size payload depending on the type,
+// with multi-byte fields in little endian. Frames are self-delimiting, so several of them can be
+// sent back to back in a data channel message or over a stream. In a stream, a zero byte in place
+// of a frame is a ping.
+//
+// Payloads of version 1:
+//
+//	KeyDown, KeyUp                   key uint16
+//	MouseMove, MouseDown, MouseUp    button uint8, x uint16, y uint16
+//	ButtonDown, ButtonUp             button uint8
+//	Wheel, MoveRelative              dx int16, dy int16
+//	Gamepad*                         pad uint8, index uint8, value int16
+//	TouchStart, TouchMove, TouchEnd  id uint8, x uint16, y uint16
+//	Marker                           id uint16
+//
+// Positions are fractions of the screen scaled to 0-65535, gamepad values are scaled to ±32767.
+package inputproto
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Version is the latest version of the protocol, the only one supported so far
+const Version = 1
+
+// Ping is sent in place of a frame to check that a stream is alive
+const Ping = 0
+
+const headerSize = 2
+
+type EventType byte
+
+const (
+	KeyDown EventType = iota + 1
+	KeyUp
+	// Mouse events at a position of the screen
+	MouseMove
+	MouseDown
+	MouseUp
+	// Mouse button events wherever the cursor is, used when the player captures the cursor
+	ButtonDown
+	ButtonUp
+	Wheel
+	MoveRelative
+	GamepadConnect
+	GamepadDisconnect
+	GamepadButton
+	GamepadAxis
+	GamepadTrigger
+	TouchStart
+	TouchMove
+	TouchEnd
+	// Echoed back by syncinput once the events sent before it are simulated, to measure latency
+	Marker
+)
+
+var (
+	ErrShortFrame         = errors.New("frame too short")
+	ErrUnsupportedVersion = errors.New("unsupported input protocol version")
+	ErrUnknownEvent       = errors.New("unknown input event")
+)
+
+// Event is a decoded frame, only the fields of its type are used
+type Event struct {
+	Type EventType
+	// Windows virtual-key code
+	Key uint16
+	// Mouse button, numbered as MouseEvent.button of browsers
+	Button uint8
+	Pad    uint8
+	// Button, axis or trigger of a pad
+	Index uint8
+	// Identifier of a touch
+	ID uint8
+	// Position of mouse and touch events as fractions of the screen, from 0 to 1
+	X, Y float64
+	// Wheel deltas in WHEEL_DELTA units, or relative movements in pixels
+	DX, DY int16
+	// From -1 to 1 for axes, from 0 to 1 for buttons and triggers of pads
+	Value float64
+	// Identifier of a marker
+	Marker uint16
+}
+
+// payloadSize returns the size of the payload of the event type, or -1 if the type is unknown
+func payloadSize(t EventType) int {
+	switch t {
+	case KeyDown, KeyUp, Marker:
+		return 2
+	case ButtonDown, ButtonUp:
+		return 1
+	case Wheel, MoveRelative:
+		return 4
+	case GamepadConnect, GamepadDisconnect, GamepadButton, GamepadAxis, GamepadTrigger:
+		return 4
+	case MouseMove, MouseDown, MouseUp, TouchStart, TouchMove, TouchEnd:
+		return 5
+	}
+	return -1
+}
+
+// Decode reads the frame at the start of buf into ev and returns its size
+func Decode(buf []byte, ev *Event) (int, error) {
+	if len(buf) < headerSize {
+		return 0, ErrShortFrame
+	}
+	if buf[0] != Version {
+		return 0, fmt.Errorf("%w %d", ErrUnsupportedVersion, buf[0])
+	}
+	t := EventType(buf[1])
+	size := payloadSize(t)
+	if size < 0 {
+		return 0, fmt.Errorf("%w %d", ErrUnknownEvent, t)
+	}
+	if len(buf) < headerSize+size {
+		return 0, ErrShortFrame
+	}
+
+	p := buf[headerSize : headerSize+size]
+	*ev = Event{Type: t}
+	switch t {
+	case KeyDown, KeyUp:
+		ev.Key = binary.LittleEndian.Uint16(p)
+	case Marker:
+		ev.Marker = binary.LittleEndian.Uint16(p)
+	case ButtonDown, ButtonUp:
+		ev.Button = p[0]
+	case Wheel, MoveRelative:
+		ev.DX = int16(binary.LittleEndian.Uint16(p))
+		ev.DY = int16(binary.LittleEndian.Uint16(p[2:]))
+	case GamepadConnect, GamepadDisconnect, GamepadButton, GamepadAxis, GamepadTrigger:
+		ev.Pad = p[0]
+		ev.Index = p[1]
+		ev.Value = math.Max(-1, float64(int16(binary.LittleEndian.Uint16(p[2:])))/math.MaxInt16)
+	case MouseMove, MouseDown, MouseUp:
+		ev.Button = p[0]
+		ev.X, ev.Y = decodePosition(p[1:])
+	case TouchStart, TouchMove, TouchEnd:
+		ev.ID = p[0]
+		ev.X, ev.Y = decodePosition(p[1:])
+	}
+
+	return headerSize + size, nil
+}
+
+// Append encodes the event as a frame at the end of buf, positions and values out of range are clamped
+func Append(buf []byte, ev *Event) []byte {
+	size := payloadSize(ev.Type)
+	if size < 0 {
+		return buf
+	}
+
+	buf = append(buf, Version, byte(ev.Type))
+	switch ev.Type {
+	case KeyDown, KeyUp:
+		buf = appendUint16(buf, ev.Key)
+	case Marker:
+		buf = appendUint16(buf, ev.Marker)
+	case ButtonDown, ButtonUp:
+		buf = append(buf, ev.Button)
+	case Wheel, MoveRelative:
+		buf = appendUint16(buf, uint16(ev.DX))
+		buf = appendUint16(buf, uint16(ev.DY))
+	case GamepadConnect, GamepadDisconnect, GamepadButton, GamepadAxis, GamepadTrigger:
+		buf = append(buf, ev.Pad, ev.Index)
+		buf = appendUint16(buf, uint16(int16(math.Round(clamp(ev.Value, -1, 1)*math.MaxInt16))))
+	case MouseMove, MouseDown, MouseUp:
+		buf = append(buf, ev.Button)
+		buf = appendPosition(buf, ev.X, ev.Y)
+	case TouchStart, TouchMove, TouchEnd:
+		buf = append(buf, ev.ID)
+		buf = appendPosition(buf, ev.X, ev.Y)
+	}
+
+	return buf
+}
+
+func decodePosition(p []byte) (float64, float64) {
+	x := float64(binary.LittleEndian.Uint16(p)) / math.MaxUint16
+	y := float64(binary.LittleEndian.Uint16(p[2:])) / math.MaxUint16
+	return x, y
+}
+
+func appendPosition(buf []byte, x, y float64) []byte {
+	buf = appendUint16(buf, uint16(math.Round(clamp(x, 0, 1)*math.MaxUint16)))
+	return appendUint16(buf, uint16(math.Round(clamp(y, 0, 1)*math.MaxUint16)))
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v), byte(v>>8))
+}
+
+// clamp also maps NaN to min, so garbage never reaches the VM
+func clamp(v, min, max float64) float64 {
+	if !(v >= min) {
+		return min
+	}
+	return math.Min(v, max)
+}
@@ -0,0 +1,41 @@
+//go:build go1.18
+// +build go1.18
+
+// Fuzz tests need Go 1.18, older versions build the tests without them
+
+package inputproto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func FuzzDecode(f *testing.F) {
+	for i := range events {
+		f.Add(Append(nil, &events[i]))
+	}
+	f.Add([]byte{Version, byte(GamepadAxis), 0, 0, 0x00, 0x80})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var ev Event
+		n, err := Decode(data, &ev)
+		if err != nil {
+			return
+		}
+		if n > len(data) {
+			t.Fatalf("decoded %d bytes out of %d", n, len(data))
+		}
+		assert.True(t, ev.X >= 0 && ev.X <= 1 && ev.Y >= 0 && ev.Y <= 1, "position out of range")
+		assert.True(t, ev.Value >= -1 && ev.Value <= 1, "value out of range")
+
+		// Decoded events are encoded back to the same event
+		var again Event
+		frame := Append(nil, &ev)
+		m, err := Decode(frame, &again)
+		require.NoError(t, err)
+		assert.Equal(t, len(frame), m)
+		assert.Equal(t, ev, again)
+	})
+}
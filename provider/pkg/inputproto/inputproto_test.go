@@ -0,0 +1,86 @@
+package inputproto
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var events = []Event{
+	{Type: KeyDown, Key: 0x25},
+	{Type: KeyUp, Key: 0xFFFF},
+	{Type: MouseMove, Button: 0, X: 0.25, Y: 1},
+	{Type: MouseDown, Button: 2, X: 0, Y: 0.5},
+	{Type: ButtonUp, Button: 4},
+	{Type: Wheel, DX: -120, DY: 1200},
+	{Type: MoveRelative, DX: 32767, DY: -32768},
+	{Type: GamepadConnect, Pad: 3},
+	{Type: GamepadAxis, Pad: 1, Index: 2, Value: -0.5},
+	{Type: GamepadTrigger, Index: 1, Value: 1},
+	{Type: TouchEnd, ID: 9, X: 0.75, Y: 0.125},
+	{Type: Marker, Marker: 513},
+}
+
+func TestRoundTrip(t *testing.T) {
+	var buf []byte
+	for i := range events {
+		buf = Append(buf, &events[i])
+	}
+
+	// Frames are decoded back to back
+	for _, want := range events {
+		var ev Event
+		n, err := Decode(buf, &ev)
+		require.NoError(t, err)
+		assert.Equal(t, want.Type, ev.Type)
+		assert.Equal(t, want.Key, ev.Key)
+		assert.Equal(t, want.Button, ev.Button)
+		assert.Equal(t, want.Pad, ev.Pad)
+		assert.Equal(t, want.Index, ev.Index)
+		assert.Equal(t, want.ID, ev.ID)
+		assert.InDelta(t, want.X, ev.X, 1.0/65535)
+		assert.InDelta(t, want.Y, ev.Y, 1.0/65535)
+		assert.Equal(t, want.DX, ev.DX)
+		assert.Equal(t, want.DY, ev.DY)
+		assert.InDelta(t, want.Value, ev.Value, 1.0/32767)
+		assert.Equal(t, want.Marker, ev.Marker)
+		buf = buf[n:]
+	}
+	assert.Empty(t, buf)
+}
+
+func TestAppendClampsValues(t *testing.T) {
+	frame := Append(nil, &Event{Type: TouchStart, X: -3, Y: 2})
+	assert.Equal(t, []byte{Version, byte(TouchStart), 0, 0, 0, 0xFF, 0xFF}, frame)
+
+	var ev Event
+	_, err := Decode(Append(nil, &Event{Type: GamepadButton, Value: 7}), &ev)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, ev.Value)
+
+	assert.Empty(t, Append(nil, &Event{Type: 200}))
+}
+
+func TestDecodeErrors(t *testing.T) {
+	var ev Event
+	_, err := Decode([]byte{Version}, &ev)
+	assert.ErrorIs(t, err, ErrShortFrame)
+	_, err = Decode([]byte{Version, byte(MouseMove), 0, 0}, &ev)
+	assert.ErrorIs(t, err, ErrShortFrame)
+	_, err = Decode([]byte{2, byte(KeyDown), 0, 0}, &ev)
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+	_, err = Decode([]byte{Version, 0, 0, 0}, &ev)
+	assert.ErrorIs(t, err, ErrUnknownEvent)
+}
+
+func BenchmarkDecode(b *testing.B) {
+	frame := Append(nil, &Event{Type: MouseMove, X: 0.5, Y: 0.5})
+	var ev Event
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Decode(frame, &ev); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package rtpqueue
+
+import "encoding/binary"
+
+const rtpHeaderSize = 12
+
+// Valid tells whether the packet looks like an RTP packet
+func Valid(packet []byte) bool {
+	_, ok := payload(packet)
+	return ok
+}
+
+// payload returns the payload of a raw RTP packet without parsing it into an rtp.Packet, which would allocate
+func payload(packet []byte) ([]byte, bool) {
+	if len(packet) < rtpHeaderSize || packet[0]>>6 != 2 {
+		return nil, false
+	}
+
+	offset := rtpHeaderSize + int(packet[0]&0x0f)*4
+	if packet[0]&0x10 != 0 {
+		// Header extension: 2 bytes profile, 2 bytes length in 32-bit words
+		if len(packet) < offset+4 {
+			return nil, false
+		}
+		offset += 4 + int(binary.BigEndian.Uint16(packet[offset+2:]))*4
+	}
+	end := len(packet)
+	if packet[0]&0x20 != 0 {
+		// Padding: the last byte is the padding size
+		end -= int(packet[len(packet)-1])
+	}
+	if offset > end {
+		return nil, false
+	}
+
+	return packet[offset:end], true
+}
+
+// timestamp returns the RTP timestamp of a raw RTP packet, the same for every packet of a frame
+func timestamp(packet []byte) (uint32, bool) {
+	if len(packet) < rtpHeaderSize {
+		return 0, false
+	}
+
+	return binary.BigEndian.Uint32(packet[4:]), true
+}
+
+// KeyframeDetector returns the keyframe detector of the video codec, nil if the codec isn't supported
+func KeyframeDetector(codec string) KeyframeFunc {
+	switch codec {
+	case "vp8":
+		return IsVP8Keyframe
+	case "vp9":
+		return IsVP9Keyframe
+	case "h264":
+		return IsH264Keyframe
+	}
+
+	return nil
+}
+
+// IsVP8Keyframe tells whether the packet is the first packet of a VP8 keyframe, see RFC 7741
+func IsVP8Keyframe(packet []byte) bool {
+	p, ok := payload(packet)
+	if !ok || len(p) < 1 {
+		return false
+	}
+
+	// Only the start of the first partition carries the frame header
+	start := p[0]&0x10 != 0
+	partition := p[0] & 0x07
+	if !start || partition != 0 {
+		return false
+	}
+
+	offset := 1
+	if p[0]&0x80 != 0 {
+		if len(p) < 2 {
+			return false
+		}
+		ext := p[1]
+		offset++
+		if ext&0x80 != 0 {
+			// Picture ID of 7 or 15 bits
+			if len(p) < offset+1 {
+				return false
+			}
+			if p[offset]&0x80 != 0 {
+				offset += 2
+			} else {
+				offset++
+			}
+		}
+		if ext&0x40 != 0 {
+			// TL0PICIDX
+			offset++
+		}
+		if ext&0x30 != 0 {
+			// TID/KEYIDX
+			offset++
+		}
+	}
+	if len(p) <= offset {
+		return false
+	}
+
+	// P bit of the VP8 frame header is 0 for keyframes
+	return p[offset]&0x01 == 0
+}
+
+// IsVP9Keyframe tells whether the packet is the first packet of a VP9 frame which isn't inter-picture predicted,
+// see the VP9 RTP payload format
+func IsVP9Keyframe(packet []byte) bool {
+	p, ok := payload(packet)
+	if !ok || len(p) < 1 {
+		return false
+	}
+
+	// P bit is clear for frames decodable on their own, B bit is set on the first packet of a frame
+	return p[0]&0x40 == 0 && p[0]&0x08 != 0
+}
+
+// IsH264Keyframe tells whether the packet starts an IDR picture or carries parameter sets which precede one, see RFC 6184
+func IsH264Keyframe(packet []byte) bool {
+	p, ok := payload(packet)
+	if !ok || len(p) < 1 {
+		return false
+	}
+
+	switch naluType := p[0] & 0x1f; naluType {
+	case 5, 7:
+		// IDR slice or SPS
+		return true
+	case 24:
+		// STAP-A: 2 bytes size before each NAL unit
+		for offset := 1; offset+2 < len(p); {
+			size := int(binary.BigEndian.Uint16(p[offset:]))
+			if t := p[offset+2] & 0x1f; t == 5 || t == 7 {
+				return true
+			}
+			offset += 2 + size
+		}
+	case 28:
+		// FU-A: start bit and type of the fragmented NAL unit
+		if len(p) < 2 {
+			return false
+		}
+		return p[1]&0x80 != 0 && p[1]&0x1f == 5
+	}
+
+	return false
+}
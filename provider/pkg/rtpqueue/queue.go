@@ -0,0 +1,140 @@
+// Package rtpqueue relays RTP packets between goroutines without blocking the producer.
+package rtpqueue
+
+import "sync"
+
+// MaxPacketSize is the size of the biggest RTP packet which can be queued
+const MaxPacketSize = 1500
+
+// KeyframeFunc tells whether a raw RTP packet starts a keyframe
+type KeyframeFunc func(packet []byte) bool
+
+// Queue is a bounded FIFO of raw RTP packets.
+// When the consumer is too slow the oldest packets are dropped, so the producer never blocks.
+// Packets are copied in and out of preallocated buffers, thus pushing and popping never allocate.
+type Queue struct {
+	slots [][]byte
+	// Index of the oldest packet
+	head  int
+	count int
+	// Set if stale packets can be dropped as soon as a keyframe arrives
+	isKeyframe KeyframeFunc
+	// RTP timestamp of the last pushed packet, packets of the same frame share it
+	lastTimestamp uint32
+	dropped       uint64
+	closed        bool
+	// Signals the consumer that packets were pushed or the queue was closed
+	ready chan struct{}
+	mu    sync.Mutex
+}
+
+func New(capacity int, isKeyframe KeyframeFunc) *Queue {
+	slots := make([][]byte, capacity)
+	buf := make([]byte, capacity*MaxPacketSize)
+	for i := range slots {
+		slots[i] = buf[i*MaxPacketSize : i*MaxPacketSize : (i+1)*MaxPacketSize]
+	}
+
+	return &Queue{
+		slots:      slots,
+		isKeyframe: isKeyframe,
+		ready:      make(chan struct{}, 1),
+	}
+}
+
+// Push copies the packet to the end of the queue, dropping the oldest packet if the queue is full.
+// Packets queued before a keyframe are dropped once more than half of the queue is used,
+// as the player can't decode them anyway once it is late. Only the first packet of the keyframe flushes the queue,
+// so a keyframe split in several slices or fragments doesn't drop its own earlier packets.
+// It returns false if the packet itself was dropped.
+func (q *Queue) Push(packet []byte) bool {
+	if len(packet) > MaxPacketSize {
+		q.mu.Lock()
+		q.dropped++
+		q.mu.Unlock()
+		return false
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return false
+	}
+
+	ts, _ := timestamp(packet)
+	newFrame := q.count == 0 || ts != q.lastTimestamp
+	q.lastTimestamp = ts
+	if q.isKeyframe != nil && newFrame && q.count > len(q.slots)/2 && q.isKeyframe(packet) {
+		q.dropped += uint64(q.count)
+		q.head = 0
+		q.count = 0
+	}
+	if q.count == len(q.slots) {
+		q.head = (q.head + 1) % len(q.slots)
+		q.count--
+		q.dropped++
+	}
+
+	tail := (q.head + q.count) % len(q.slots)
+	q.slots[tail] = append(q.slots[tail][:0], packet...)
+	q.count++
+
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+// Pop copies the oldest packet to dst, which must be at least MaxPacketSize long, and returns its size.
+// It blocks until a packet is available and returns false once the queue is closed.
+func (q *Queue) Pop(dst []byte) (int, bool) {
+	for {
+		q.mu.Lock()
+		if q.count > 0 {
+			n := copy(dst, q.slots[q.head])
+			q.head = (q.head + 1) % len(q.slots)
+			q.count--
+			q.mu.Unlock()
+			return n, true
+		}
+		if q.closed {
+			q.mu.Unlock()
+			return 0, false
+		}
+		q.mu.Unlock()
+
+		<-q.ready
+	}
+}
+
+// Len returns the number of packets waiting to be popped
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.count
+}
+
+// Dropped returns the number of packets which were dropped before being popped
+func (q *Queue) Dropped() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.dropped
+}
+
+// Close discards queued packets and wakes up the consumer. Pushing to a closed queue is a no-op.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.count = 0
+	close(q.ready)
+}
@@ -0,0 +1,209 @@
+package rtpqueue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rtpPacket returns a packet carrying a whole frame
+func rtpPacket(t testing.TB, seq uint16, payload []byte) []byte {
+	return rtpFramePacket(t, seq, uint32(seq)*3000, payload)
+}
+
+// rtpFramePacket returns a packet of the frame with the timestamp
+func rtpFramePacket(t testing.TB, seq uint16, timestamp uint32, payload []byte) []byte {
+	p := rtp.Packet{
+		Header:  rtp.Header{Version: 2, SequenceNumber: seq, Timestamp: timestamp, PayloadType: 96},
+		Payload: payload,
+	}
+	raw, err := p.Marshal()
+	require.NoError(t, err)
+
+	return raw
+}
+
+// VP8 payload descriptors with S bit and partition 0, followed by the frame header
+var (
+	vp8Keyframe   = []byte{0x10, 0x00}
+	vp8Interframe = []byte{0x10, 0x01}
+)
+
+func seqOf(t *testing.T, packet []byte) uint16 {
+	var p rtp.Packet
+	require.NoError(t, p.Unmarshal(packet))
+	return p.SequenceNumber
+}
+
+func TestQueueDropsOldest(t *testing.T) {
+	q := New(3, nil)
+	for seq := uint16(1); seq <= 5; seq++ {
+		assert.True(t, q.Push(rtpPacket(t, seq, vp8Interframe)))
+	}
+	assert.Equal(t, 3, q.Len())
+	assert.Equal(t, uint64(2), q.Dropped())
+
+	buf := make([]byte, MaxPacketSize)
+	for want := uint16(3); want <= 5; want++ {
+		n, ok := q.Pop(buf)
+		require.True(t, ok)
+		assert.Equal(t, want, seqOf(t, buf[:n]))
+	}
+}
+
+func TestQueueFlushesStalePacketsOnKeyframe(t *testing.T) {
+	q := New(4, IsVP8Keyframe)
+	q.Push(rtpPacket(t, 1, vp8Interframe))
+	// Queue isn't late yet, the keyframe is simply queued
+	q.Push(rtpPacket(t, 2, vp8Keyframe))
+	q.Push(rtpPacket(t, 3, vp8Interframe))
+	assert.Equal(t, 3, q.Len())
+
+	q.Push(rtpPacket(t, 4, vp8Keyframe))
+	assert.Equal(t, 1, q.Len())
+	assert.Equal(t, uint64(3), q.Dropped())
+
+	buf := make([]byte, MaxPacketSize)
+	n, ok := q.Pop(buf)
+	require.True(t, ok)
+	assert.Equal(t, uint16(4), seqOf(t, buf[:n]))
+}
+
+func TestQueueKeepsSlicesOfKeyframe(t *testing.T) {
+	q := New(6, IsH264Keyframe)
+	for seq := uint16(1); seq <= 4; seq++ {
+		q.Push(rtpPacket(t, seq, []byte{0x41}))
+	}
+
+	// IDR picture of 3 slices, each fragmented in 2 FU-A packets
+	var idr [][]byte
+	for slice := uint16(0); slice < 3; slice++ {
+		seq := 5 + slice*2
+		idr = append(idr,
+			rtpFramePacket(t, seq, 5*3000, []byte{0x7c, 0x85}),
+			rtpFramePacket(t, seq+1, 5*3000, []byte{0x7c, 0x45}))
+	}
+	for _, packet := range idr {
+		q.Push(packet)
+	}
+	require.Equal(t, len(idr), q.Len())
+	assert.Equal(t, uint64(4), q.Dropped())
+
+	buf := make([]byte, MaxPacketSize)
+	for _, want := range idr {
+		n, ok := q.Pop(buf)
+		require.True(t, ok)
+		assert.Equal(t, seqOf(t, want), seqOf(t, buf[:n]))
+	}
+}
+
+func TestQueuePopsAfterClose(t *testing.T) {
+	q := New(2, nil)
+	popped := make(chan bool)
+	go func() {
+		_, ok := q.Pop(make([]byte, MaxPacketSize))
+		popped <- ok
+	}()
+
+	q.Close()
+	select {
+	case ok := <-popped:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("Pop is still blocked after Close")
+	}
+	assert.False(t, q.Push(rtpPacket(t, 1, nil)))
+}
+
+func TestQueueDoesNotAllocate(t *testing.T) {
+	q := New(8, IsVP8Keyframe)
+	packet := rtpPacket(t, 1, vp8Interframe)
+	buf := make([]byte, MaxPacketSize)
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		q.Push(packet)
+		q.Pop(buf)
+	})
+	assert.Zero(t, allocs)
+}
+
+func TestIsVP8Keyframe(t *testing.T) {
+	assert.True(t, IsVP8Keyframe(rtpPacket(t, 1, vp8Keyframe)))
+	assert.False(t, IsVP8Keyframe(rtpPacket(t, 1, vp8Interframe)))
+	// Extended descriptor with a 15-bit picture ID
+	assert.True(t, IsVP8Keyframe(rtpPacket(t, 1, []byte{0x90, 0x80, 0x81, 0x23, 0x00})))
+	// Not the start of the frame
+	assert.False(t, IsVP8Keyframe(rtpPacket(t, 1, []byte{0x00, 0x00})))
+	assert.False(t, IsVP8Keyframe([]byte{0x80}))
+}
+
+func TestIsVP9Keyframe(t *testing.T) {
+	assert.True(t, IsVP9Keyframe(rtpPacket(t, 1, []byte{0x08})))
+	// Inter-picture predicted
+	assert.False(t, IsVP9Keyframe(rtpPacket(t, 1, []byte{0x48})))
+	// Not the start of the frame
+	assert.False(t, IsVP9Keyframe(rtpPacket(t, 1, []byte{0x00})))
+}
+
+func TestIsH264Keyframe(t *testing.T) {
+	assert.True(t, IsH264Keyframe(rtpPacket(t, 1, []byte{0x65})))
+	assert.True(t, IsH264Keyframe(rtpPacket(t, 1, []byte{0x7c, 0x85})))
+	assert.False(t, IsH264Keyframe(rtpPacket(t, 1, []byte{0x7c, 0x05})))
+	assert.True(t, IsH264Keyframe(rtpPacket(t, 1, []byte{0x78, 0x00, 0x02, 0x09, 0xf0, 0x00, 0x02, 0x67, 0x42})))
+	assert.False(t, IsH264Keyframe(rtpPacket(t, 1, []byte{0x41})))
+}
+
+func BenchmarkQueuePushPop(b *testing.B) {
+	q := New(128, IsVP8Keyframe)
+	packet := rtpPacket(b, 1, make([]byte, 1100))
+	buf := make([]byte, MaxPacketSize)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(packet)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		q.Push(packet)
+		q.Pop(buf)
+	}
+}
+
+// BenchmarkQueueSlowConsumer measures pushing while the consumer can't keep up, i.e. every push drops a packet
+func BenchmarkQueueSlowConsumer(b *testing.B) {
+	q := New(128, IsVP8Keyframe)
+	packet := rtpPacket(b, 1, make([]byte, 1100))
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(packet)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		q.Push(packet)
+	}
+}
+
+func BenchmarkQueueConcurrent(b *testing.B) {
+	q := New(128, IsVP8Keyframe)
+	packet := rtpPacket(b, 1, make([]byte, 1100))
+	done := make(chan struct{})
+	go func() {
+		buf := make([]byte, MaxPacketSize)
+		for {
+			if _, ok := q.Pop(buf); !ok {
+				close(done)
+				return
+			}
+		}
+	}()
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(packet)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		q.Push(packet)
+	}
+	b.StopTimer()
+	q.Close()
+	<-done
+}
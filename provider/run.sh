@@ -1,4 +1,9 @@
-# Prebuild image
-APP_NAME=tarzan_pc docker-compose build
+# Prebuild images of all apps
+for conf in appconf/*.env; do
+  APP_NAME="$(basename "$conf" .env)"
+  # Apps sharing the image of another app
+  grep -q '^appdir=' "$conf" && continue
+  docker build --build-arg APP_NAME="$APP_NAME" -t "cope-appvm:$APP_NAME" ../appvm
+done
 
-go run main.go -owner=$1
+go run main.go -token=$1 "${@:2}"
@@ -1,5 +1,11 @@
 package settings
 
+import (
+	"time"
+
+	"provider/app/codec"
+)
+
 type Range struct {
 	Min uint16
 	Max uint16
@@ -11,16 +17,88 @@ var (
 	IceIpMap                   string
 	DisableDefaultInterceptors bool
 
-	VideoCodec string
+	// Video codecs the VM can encode, in order of preference
+	VideoCodecs []codec.Codec
+	// Bitrates of the video stream in bps, adapted to the link of the player between min and max
+	VideoBitrate    int
+	MinVideoBitrate int
+	MaxVideoBitrate int
+	// Minimum time between two changes of the video bitrate
+	BitrateUpdateInterval time.Duration
+	// Minimum time between two keyframes requested by the player after losing packets
+	KeyframeRequestInterval time.Duration
+	// Number of RTP packets buffered for the player, the oldest are dropped once full
+	VideoQueueSize int
+	AudioQueueSize int
+	// How often stream quality of sessions is reported to the coordinator
+	StatsReportInterval time.Duration
+	// Minimum time between two inputs whose latency is measured
+	LatencyProbeInterval time.Duration
+	// How long sessions keep running after losing the connection to the player, who may reconnect in the meantime
+	ResumeGracePeriod time.Duration
+	// Maximum number of spectators watching a session besides its player
+	MaxSpectators int
 
 	CoordinatorAddr string
+
+	// Maximum number of concurrent sessions, derived from CPUs and memory if not set
+	MaxSessions int
+	// Resources needed by a single session, in CPUs and GB
+	SessionCpuNum  int
+	SessionMemSize float64
+
+	// VM backend, either "docker" or "fake"
+	VMBackend  string
+	DockerHost string
+	VMImage    string
+	// uinput device of the host VMs create virtual gamepads with, gamepads are disabled if empty.
+	// VMs may then use any input device of the host, so it's only set on hosts dedicated to the provider.
+	UInputDevice string
+	AppConfDir   string
+	AppsDir      string
+
+	// Saves of players are kept per player and app in this directory
+	SavesDir string
+	// Number of snapshots of the saves of a player for an app which are kept
+	SaveSnapshots int
+	// Whether saves are synced with the coordinator, so players get them back on other providers
+	SyncSaves bool
+	// Maximum size of the compressed saves of a player for an app, in bytes
+	MaxSaveSize int64
 )
 
 func init() {
 	SinglePort = 8443
 	DisableDefaultInterceptors = false
 
-	VideoCodec = "vpx"
+	VideoCodecs = []codec.Codec{codec.H264, codec.VP9, codec.VP8}
+	VideoBitrate = 1500000
+	MinVideoBitrate = 150000
+	MaxVideoBitrate = 4000000
+	BitrateUpdateInterval = 2 * time.Second
+	KeyframeRequestInterval = 2 * time.Second
+	VideoQueueSize = 256
+	AudioQueueSize = 64
+	StatsReportInterval = 5 * time.Second
+	LatencyProbeInterval = 200 * time.Millisecond
+	ResumeGracePeriod = 30 * time.Second
+	MaxSpectators = 4
 
 	CoordinatorAddr = "localhost:8080"
+
+	MaxSessions = 0
+	SessionCpuNum = 2
+	SessionMemSize = 2
+
+	VMBackend = "docker"
+	DockerHost = "/var/run/docker.sock"
+	VMImage = "cope-appvm"
+	UInputDevice = ""
+	AppConfDir = "appconf"
+	AppsDir = "../appvm/apps"
+
+	SavesDir = "saves"
+	SaveSnapshots = 5
+	SyncSaves = false
+	MaxSaveSize = 16 << 20
 }
@@ -1,3 +0,0 @@
-#!/bin/bash
-
-APP_NAME="$2" docker-compose -p "$1" down
@@ -1,19 +1,33 @@
-import React, { useState, useEffect } from "react";
+import React, { useState, useEffect, useRef } from "react";
 import AppChoice from "./views/AppChoice";
 import AppPlayer from "./views/AppPlayer";
 import ProviderChoice from "./views/ProviderChoice";
 import Welcoming from "./views/Welcoming";
 import ProviderInstruction from "./views/ProviderInstruction";
 import ServerList from "./views/ServerList";
+import Login from "./views/Login";
 
 import { decodeBase64, encodeBase64 } from "./utils";
-import { addRemoteSdp, addIceCandidate } from "./services/webrtc";
+import {
+  addRemoteSdp,
+  addIceCandidate,
+  getVideoCodecs,
+} from "./services/webrtc";
 import { getDevice } from "./services/api/apps";
+import { watchGamepads } from "./services/gamepad";
+import { inputChannel } from "./services/input";
+import { getSession } from "./services/api/auth";
 
 import "./App.scss";
 
+// Let the coordinator choose the provider
+const AUTO_PROVIDER = "auto";
+// Time to let the connection recover by itself before resuming the session
+const RESUME_DELAY = 3000;
+
 function App() {
   const [welcoming, setWelcoming] = useState(true);
+  const [account, setAccount] = useState(getSession());
   const [instructions, setInstructions] = useState(false);
   const [ws, setWs] = useState(null);
   const [pc, setPc] = useState(null);
@@ -22,6 +36,28 @@ function App() {
   const [selectedApp, setSelectedApp] = useState("");
   const [selectedProvider, setSelectedProvider] = useState("");
   const [showServers, setShowServers] = useState(false);
+  // ID of the provider running the app, known only after matching in case of AUTO_PROVIDER
+  const providerRef = useRef("");
+  // Position in the queue while waiting for a free provider
+  const [queuePosition, setQueuePosition] = useState(0);
+  // Remaining play minutes once they are about to run out
+  const [creditWarning, setCreditWarning] = useState(0);
+  // Input latency percentiles reported by the provider, in milliseconds
+  const [latency, setLatency] = useState(null);
+  // Ticket to resume the session when the connection to the provider drops
+  const ticketRef = useRef(null);
+  const resumingRef = useRef(false);
+  const resumeTimerRef = useRef(null);
+  // Codes friends use to watch the session or play it in another seat, once the player invited them
+  const [inviteCode, setInviteCode] = useState("");
+  const [seatCode, setSeatCode] = useState("");
+  // Guests playing in the other seats of the session
+  const [seats, setSeats] = useState([]);
+  // Code of the session of a friend, which is watched or played as a guest
+  const [friendCode, setFriendCode] = useState("");
+  const [joined, setJoined] = useState(false);
+  const spectateCodeRef = useRef("");
+  const seatCodeRef = useRef("");
 
   useEffect(() => {
     setTimeout(() => {
@@ -30,7 +66,11 @@ function App() {
   });
 
   useEffect(() => {
-    const ws = new WebSocket(process.env.REACT_APP_WS_ENDPOINT);
+    if (account === null) return;
+
+    const ws = new WebSocket(
+      `${process.env.REACT_APP_WS_ENDPOINT}?token=${account.token}`
+    );
 
     ws.onopen = () => {
       setWs(ws);
@@ -48,7 +88,7 @@ function App() {
     };
 
     return () => ws.close();
-  }, []);
+  }, [account]);
 
   useEffect(() => {
     if (pc === null) return;
@@ -63,25 +103,78 @@ function App() {
       data: JSON.stringify({
         appID: selectedApp,
         device: getDevice(),
+        codecs: getVideoCodecs(),
       }),
     };
+    if (spectateCodeRef.current !== "") {
+      // Spectators only receive the streams of the player who invited them
+      msg.type = "spectate";
+      msg.receiverID = "";
+      msg.data = JSON.stringify({ code: spectateCodeRef.current });
+      providerRef.current = "";
+    } else if (seatCodeRef.current !== "") {
+      // Guests play the game of the player who invited them in a seat of their own
+      msg.type = "join-seat";
+      msg.receiverID = "";
+      msg.data = JSON.stringify({ code: seatCodeRef.current });
+      providerRef.current = "";
+    } else if (resumingRef.current) {
+      // Provider keeps the game running for a while, only the connection is renegotiated
+      resumingRef.current = false;
+      msg.type = "resume";
+      msg.receiverID = "";
+      msg.data = JSON.stringify(ticketRef.current);
+    } else if (selectedProvider === AUTO_PROVIDER) {
+      msg.type = "play";
+      msg.receiverID = "";
+      providerRef.current = "";
+    } else {
+      providerRef.current = selectedProvider;
+    }
     ws.send(JSON.stringify(msg));
 
     ws.onmessage = async (event) => {
       const msg = JSON.parse(event.data);
-      if (msg.type === "sdp") {
+      if (msg.type === "queue") {
+        setQueuePosition(JSON.parse(msg.data).position);
+      } else if (msg.type === "session") {
+        ticketRef.current = JSON.parse(msg.data);
+      } else if (msg.type === "invite") {
+        const invite = JSON.parse(msg.data);
+        if (invite.seat) {
+          setSeatCode(invite.code);
+        } else {
+          setInviteCode(invite.code);
+        }
+      } else if (msg.type === "seats") {
+        setSeats(JSON.parse(msg.data).seats);
+      } else if (msg.type === "ended") {
+        alert("The game has ended");
+        closeApp();
+      } else if (msg.type === "credit") {
+        setCreditWarning(JSON.parse(msg.data).minutes);
+      } else if (msg.type === "matched") {
+        setQueuePosition(0);
+        providerRef.current = JSON.parse(msg.data).providerID;
+        console.log(`Matched with provider ${providerRef.current}`);
+      } else if (msg.type === "sdp") {
         const offer = JSON.parse(decodeBase64(msg.data));
         const answer = await addRemoteSdp(pc, offer);
         ws.send(
           JSON.stringify({
             type: "sdp",
-            receiverID: selectedProvider,
+            receiverID: providerRef.current,
             data: encodeBase64(JSON.stringify(answer)),
           })
         );
       } else if (msg.type === "ice-candidate") {
         const ice = JSON.parse(decodeBase64(msg.data));
         addIceCandidate(pc, ice);
+      } else if (msg.type === "error") {
+        const err = JSON.parse(msg.data);
+        console.log(`Failed to launch the game at ${err.stage}: ${err.code}`);
+        alert(`Couldn't launch the game: ${err.message}`);
+        closeApp();
       }
     };
   }, [pc]);
@@ -90,37 +183,22 @@ function App() {
     if (inpChannel === null) return;
 
     const onKeyDown = (event) => {
-      if (inpChannel.readyState !== "open") return;
-
-      inpChannel.send(
-        JSON.stringify({
-          type: "KEYDOWN",
-          data: JSON.stringify({
-            keyCode: event.keyCode,
-          }),
-        })
-      );
+      inpChannel.send("KEYDOWN", { keyCode: event.keyCode });
     };
 
     const onKeyUp = (event) => {
-      if (inpChannel.readyState !== "open") return;
-
-      inpChannel.send(
-        JSON.stringify({
-          type: "KEYUP",
-          data: JSON.stringify({
-            keyCode: event.keyCode,
-          }),
-        })
-      );
+      inpChannel.send("KEYUP", { keyCode: event.keyCode });
     };
 
     document.addEventListener("keydown", onKeyDown);
     document.addEventListener("keyup", onKeyUp);
 
+    const stopGamepads = watchGamepads(inpChannel.send);
+
     return () => {
       document.removeEventListener("keydown", onKeyDown);
       document.removeEventListener("keyup", onKeyUp);
+      stopGamepads();
     };
   }, [inpChannel]);
 
@@ -138,7 +216,7 @@ function App() {
       if (channel.label === "app-input") {
         channel.onopen = () => {
           console.log("got input datachannel");
-          setInpChannel(channel);
+          setInpChannel(inputChannel(channel));
         };
       } else if (channel.label === "health-check") {
         let healthCheckIntId;
@@ -150,6 +228,13 @@ function App() {
           }, 2000);
         };
 
+        channel.onmessage = (event) => {
+          const msg = JSON.parse(event.data);
+          if (msg.type === "LATENCY") {
+            setLatency(JSON.parse(msg.data));
+          }
+        };
+
         channel.onclose = () => {
           clearInterval(healthCheckIntId);
         };
@@ -180,7 +265,7 @@ function App() {
         ws.send(
           JSON.stringify({
             type: "ice-candidate",
-            receiverID: selectedProvider,
+            receiverID: providerRef.current,
             data: encodeBase64(JSON.stringify(iceCandidate)),
           })
         );
@@ -188,12 +273,35 @@ function App() {
     };
 
     newPc.oniceconnectionstatechange = (event) => {
-      console.log(event.target.iceConnectionState);
+      const state = event.target.iceConnectionState;
+      console.log(state);
+      if (state === "connected" || state === "completed") {
+        clearTimeout(resumeTimerRef.current);
+      } else if (state === "disconnected") {
+        clearTimeout(resumeTimerRef.current);
+        resumeTimerRef.current = setTimeout(
+          () => resumeApp(newPc),
+          RESUME_DELAY
+        );
+      } else if (state === "failed") {
+        resumeApp(newPc);
+      }
     };
 
     setPc(newPc);
   };
 
+  const resumeApp = (oldPc) => {
+    clearTimeout(resumeTimerRef.current);
+    if (ticketRef.current === null) return;
+
+    console.log(`Resuming session ${ticketRef.current.sessionID}`);
+    oldPc.close();
+    resumingRef.current = true;
+    setInpChannel(null);
+    startApp();
+  };
+
   const selectApp = (appId) => {
     setSelectedApp(appId);
   };
@@ -202,7 +310,29 @@ function App() {
     if (pc !== null) {
       pc.close();
     }
+    if (queuePosition > 0) {
+      ws.send(JSON.stringify({ type: "leave-queue" }));
+      setQueuePosition(0);
+    }
+    if (spectateCodeRef.current !== "") {
+      ws.send(JSON.stringify({ type: "stop-spectating" }));
+      spectateCodeRef.current = "";
+    }
+    if (seatCodeRef.current !== "") {
+      ws.send(JSON.stringify({ type: "leave-seat" }));
+      seatCodeRef.current = "";
+    }
+    clearTimeout(resumeTimerRef.current);
+    ticketRef.current = null;
+    resumingRef.current = false;
 
+    setInviteCode("");
+    setSeatCode("");
+    setSeats([]);
+    setJoined(false);
+
+    setCreditWarning(0);
+    setLatency(null);
     setPc(null);
     setVideoStream(null);
     setInpChannel(null);
@@ -215,6 +345,47 @@ function App() {
     startApp();
   };
 
+  const playNow = () => {
+    selectProvider(AUTO_PROVIDER);
+  };
+
+  const inviteSpectators = () => {
+    ws.send(JSON.stringify({ type: "invite" }));
+  };
+
+  const inviteGuests = () => {
+    ws.send(
+      JSON.stringify({ type: "invite", data: JSON.stringify({ seat: true }) })
+    );
+  };
+
+  const kickGuest = (guestID) => {
+    ws.send(
+      JSON.stringify({ type: "kick", data: JSON.stringify({ guestID }) })
+    );
+  };
+
+  const moveGuest = (guestID, seat) => {
+    ws.send(
+      JSON.stringify({ type: "seat", data: JSON.stringify({ guestID, seat }) })
+    );
+  };
+
+  // joinApp watches the game of a friend, or plays it in a seat of its own
+  const joinApp = (asGuest) => {
+    const code = friendCode.trim();
+    if (code === "") return;
+
+    if (asGuest) {
+      seatCodeRef.current = code;
+    } else {
+      spectateCodeRef.current = code;
+    }
+    setFriendCode("");
+    setJoined(true);
+    startApp();
+  };
+
   const reselectApp = () => {
     setSelectedApp("");
     setSelectedProvider("");
@@ -235,21 +406,70 @@ function App() {
   return (
     <div className="App">
       {welcoming && <Welcoming />}
-      {instructions && <ProviderInstruction onBack={reselectApp} />}
-      {showServers && <ServerList onBack={reselectApp} />}
-      {selectedApp !== "" && selectedProvider !== "" ? (
+      {instructions && (
+        <ProviderInstruction onBack={reselectApp}>
+          {account.role === "owner" ? (
+            <p className="provider-instruction__token">
+              Your token: {account.token}
+            </p>
+          ) : (
+            <p>You are signed in as a player, please register as an owner.</p>
+          )}
+        </ProviderInstruction>
+      )}
+      {showServers && (
+        <ServerList ownerID={account.accountID} onBack={reselectApp} />
+      )}
+      {account === null ? (
+        <Login onLogin={setAccount} />
+      ) : joined || (selectedApp !== "" && selectedProvider !== "") ? (
         <AppPlayer
+          queuePosition={queuePosition}
+          creditWarning={creditWarning}
+          latency={latency}
           videoStream={videoStream}
           inpChannel={inpChannel}
+          inviteCode={inviteCode}
+          seatCode={seatCode}
+          seats={seats}
+          onInvite={joined ? null : inviteSpectators}
+          onInviteGuests={joined ? null : inviteGuests}
+          onKickGuest={kickGuest}
+          onMoveGuest={moveGuest}
           onCloseApp={closeApp}
         />
       ) : selectedApp !== "" ? (
         <ProviderChoice
           onSelectProvider={selectProvider}
+          onPlayNow={playNow}
           onBack={reselectApp}
         />
       ) : (
         <AppChoice onSelectApp={selectApp}>
+          <form
+            className="app-choice__spectate"
+            onSubmit={(event) => event.preventDefault()}
+          >
+            <input
+              className="app-choice__spectate-code"
+              placeholder="Code of a friend"
+              value={friendCode}
+              onChange={(event) => setFriendCode(event.target.value)}
+            />
+            <button
+              className="app-choice__provider-btn"
+              onClick={() => joinApp(false)}
+            >
+              Watch
+            </button>
+            or
+            <button
+              className="app-choice__provider-btn"
+              onClick={() => joinApp(true)}
+            >
+              Play
+            </button>
+          </form>
           <div className="app-choice__for-provider">
             <button
               className="app-choice__provider-btn"
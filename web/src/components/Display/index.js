@@ -13,76 +13,115 @@ export default function Display({ streamSrc, inpChannel }) {
     videoRef.current.srcObject = streamSrc;
   }, [streamSrc]);
 
-  const sendMouseDown = (data) => {
-    if (!inpChannel) return;
-
-    inpChannel.send(
-      JSON.stringify({
-        type: "MOUSEDOWN",
-        data: JSON.stringify(data),
-      })
+  // Touch listeners must not be passive to keep browsers from scrolling and emulating mouse events
+  useEffect(() => {
+    const video = videoRef.current;
+    if (!video || !inpChannel) return;
+
+    const sendTouches = (type) => (event) => {
+      event.preventDefault();
+
+      const boundRect = video.getBoundingClientRect();
+      for (const touch of event.changedTouches) {
+        inpChannel.send(type, {
+          id: touch.identifier,
+          x: touch.clientX - boundRect.left,
+          y: touch.clientY - boundRect.top,
+          width: boundRect.width,
+          height: boundRect.height,
+        });
+      }
+    };
+
+    const listeners = {
+      touchstart: sendTouches("TOUCHSTART"),
+      touchmove: sendTouches("TOUCHMOVE"),
+      touchend: sendTouches("TOUCHEND"),
+      touchcancel: sendTouches("TOUCHEND"),
+    };
+    Object.entries(listeners).forEach(([event, listener]) =>
+      video.addEventListener(event, listener, { passive: false })
     );
-  };
 
-  const sendMouseUp = (data) => {
-    if (!inpChannel) return;
-
-    inpChannel.send(
-      JSON.stringify({
-        type: "MOUSEUP",
-        data: JSON.stringify(data),
-      })
-    );
-  };
+    return () => {
+      Object.entries(listeners).forEach(([event, listener]) =>
+        video.removeEventListener(event, listener)
+      );
+    };
+  }, [inpChannel]);
 
-  const sendMouseMove = (data) => {
+  const send = (type, data) => {
     if (!inpChannel) return;
 
-    inpChannel.send(
-      JSON.stringify({
-        type: "MOUSEMOVE",
-        data: JSON.stringify(data),
-      })
-    );
+    inpChannel.send(type, data);
   };
 
-  const isLeftButton = (button) => {
-    return button === 0 ? 1 : 0; // 1 is right button
-  };
+  const isLocked = () => document.pointerLockElement === videoRef.current;
+
+  const mouseData = (event) => {
+    // Captured cursors don't move on the page, buttons are pressed wherever the cursor of the VM is
+    if (isLocked()) return { button: event.button, locked: true };
 
-  const onMouseDown = (event) => {
     const boundRect = videoRef.current.getBoundingClientRect();
-    sendMouseDown({
-      isLeft: isLeftButton(event.button),
+    return {
+      isLeft: event.button === 0 ? 1 : 0, // For providers which only know left and right buttons
+      button: event.button,
       x: event.clientX - boundRect.left,
       y: event.clientY - boundRect.top,
       width: boundRect.width,
       height: boundRect.height,
-    });
+    };
+  };
+
+  const onMouseDown = (event) => {
+    event.preventDefault(); // Keeps the middle button from starting autoscroll
+    send("MOUSEDOWN", mouseData(event));
   };
 
   const onMouseUp = (event) => {
-    const boundRect = videoRef.current.getBoundingClientRect();
-    sendMouseUp({
-      isLeft: isLeftButton(event.button),
-      x: event.clientX - boundRect.left,
-      y: event.clientY - boundRect.top,
-      width: boundRect.width,
-      height: boundRect.height,
-    });
+    send("MOUSEUP", mouseData(event));
   };
 
   const onMouseMove = (event) => {
-    const boundRect = videoRef.current.getBoundingClientRect();
-    sendMouseMove({
-      isLeft: isLeftButton(event.button),
-      x: event.clientX - boundRect.left,
-      y: event.clientY - boundRect.top,
-      width: boundRect.width,
-      height: boundRect.height,
-    });
+    if (isLocked()) {
+      send("MOUSEMOVEREL", { dx: event.movementX, dy: event.movementY });
+      return;
+    }
+    send("MOUSEMOVE", mouseData(event));
   };
 
+  // Double clicking captures the cursor for games which look around with the mouse, Escape releases it
+  const onDoubleClick = () => {
+    if (!isLocked() && videoRef.current.requestPointerLock) {
+      videoRef.current.requestPointerLock();
+    }
+  };
+
+  // Wheel listener must not be passive to keep the page from scrolling
+  useEffect(() => {
+    const video = videoRef.current;
+    if (!video || !inpChannel) return;
+
+    const onWheel = (event) => {
+      event.preventDefault();
+
+      // Deltas are sent in notches: browsers scroll about 100 pixels or 3 lines per notch
+      const scale =
+        event.deltaMode === WheelEvent.DOM_DELTA_PIXEL
+          ? 1 / 100
+          : event.deltaMode === WheelEvent.DOM_DELTA_LINE
+          ? 1 / 3
+          : 1;
+      inpChannel.send("MOUSEWHEEL", {
+        deltaX: event.deltaX * scale,
+        deltaY: event.deltaY * scale,
+      });
+    };
+
+    video.addEventListener("wheel", onWheel, { passive: false });
+    return () => video.removeEventListener("wheel", onWheel);
+  }, [inpChannel]);
+
   const onContextMenu = (event) => {
     event.preventDefault();
     return false;
@@ -98,6 +137,7 @@ export default function Display({ streamSrc, inpChannel }) {
         onMouseDown={onMouseDown}
         onMouseUp={onMouseUp}
         onMouseMove={onMouseMove}
+        onDoubleClick={onDoubleClick}
         onContextMenu={onContextMenu}
       />
     </div>
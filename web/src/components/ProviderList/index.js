@@ -8,7 +8,8 @@ export default function ProviderList({ onSelectProvider, ownerID }) {
   const [providers, setProviders] = useState([]);
 
   useEffect(async () => {
-    const resp = await getProviderList(ownerID);
+    // Players can only choose providers with free slots
+    const resp = await getProviderList(ownerID, onSelectProvider !== undefined);
     if (resp.errorCode === undefined || resp.errorCode === 0) {
       if (resp.data?.providers !== null) {
         setProviders(resp.data.providers);
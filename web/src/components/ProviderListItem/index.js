@@ -29,6 +29,10 @@ export default function ProviderListItem({ provider }) {
         <span>CPU: {Math.ceil(provider.cpuPercent)}%</span>
         <br />
         <span>Mem: {Math.ceil(provider.memPercent)}%</span>
+        <br />
+        <span>
+          Slots: {provider.freeSlots}/{provider.maxSessions}
+        </span>
       </div>
     </div>
   );
@@ -0,0 +1,32 @@
+import axiosClient from "./axios";
+
+const REGISTER_API = "register";
+const LOGIN_API = "login";
+const SESSION_KEY = "session";
+
+const saveSession = (resp) => {
+  if (resp.errorCode === undefined || resp.errorCode === 0) {
+    localStorage.setItem(SESSION_KEY, JSON.stringify(resp.data));
+  }
+  return resp;
+};
+
+export const register = async (name, password, role) => {
+  const resp = await axiosClient.post(REGISTER_API, { name, password, role });
+  return saveSession(resp);
+};
+
+export const login = async (name, password) => {
+  const resp = await axiosClient.post(LOGIN_API, { name, password });
+  return saveSession(resp);
+};
+
+export const logout = () => {
+  localStorage.removeItem(SESSION_KEY);
+};
+
+// getSession returns the logged in account with its token, or null
+export const getSession = () => {
+  const session = localStorage.getItem(SESSION_KEY);
+  return session === null ? null : JSON.parse(session);
+};
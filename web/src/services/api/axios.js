@@ -4,12 +4,21 @@ const axiosClient = axios.create({
   baseURL: process.env.REACT_APP_API_ENDPOINT,
 });
 
+axiosClient.interceptors.request.use((config) => {
+  const session = localStorage.getItem("session");
+  if (session !== null) {
+    config.headers.Authorization = `Bearer ${JSON.parse(session).token}`;
+  }
+  return config;
+});
+
 axiosClient.interceptors.response.use(
   (response) => response.data,
   (error) => {
     console.log(error.response);
     return {
       errorCode: -1,
+      error: error.response?.data?.error,
     };
   }
 );
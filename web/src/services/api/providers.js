@@ -2,10 +2,18 @@ import axiosClient from "./axios";
 
 const PROVIDER_LIST_API = "providers";
 
-export const getProviderList = async (ownerID) => {
-  let url = `${PROVIDER_LIST_API}`;
+export const getProviderList = async (ownerID, available) => {
+  const params = new URLSearchParams();
   if (ownerID !== undefined) {
-    url += `?owner=${ownerID}`;
+    params.append("owner", ownerID);
+  }
+  if (available) {
+    params.append("available", "true");
+  }
+
+  let url = `${PROVIDER_LIST_API}`;
+  if (params.toString() !== "") {
+    url += `?${params.toString()}`;
   }
 
   const resp = await axiosClient.get(url);
@@ -0,0 +1,64 @@
+// Pads of the standard mapping report their triggers as buttons 6 and 7
+const TRIGGER_BUTTONS = { 6: 0, 7: 1 };
+// Stick movements smaller than this are noise
+const AXIS_DEADZONE = 0.05;
+const MAX_GAMEPADS = 4;
+
+// watchGamepads polls connected pads on every frame and sends their changes, it returns a function to stop
+export const watchGamepads = (send) => {
+  if (!navigator.getGamepads) return () => {};
+
+  // Last sent state of each pad
+  const states = {};
+  let frameId;
+
+  const poll = () => {
+    const pads = navigator.getGamepads();
+    for (let pad = 0; pad < MAX_GAMEPADS; pad++) {
+      const gamepad = pads[pad];
+      if (!gamepad || !gamepad.connected || gamepad.mapping !== "standard") {
+        if (states[pad]) {
+          delete states[pad];
+          send("GAMEPADDISCONNECT", { pad });
+        }
+        continue;
+      }
+
+      if (!states[pad]) {
+        states[pad] = { buttons: [], axes: [] };
+        send("GAMEPADCONNECT", { pad });
+      }
+      const state = states[pad];
+
+      gamepad.buttons.forEach((button, index) => {
+        const value = button.value;
+        if (state.buttons[index] === value) return;
+        state.buttons[index] = value;
+
+        if (index in TRIGGER_BUTTONS) {
+          send("GAMEPADTRIGGER", { pad, index: TRIGGER_BUTTONS[index], value });
+        } else {
+          send("GAMEPADBUTTON", { pad, index, value });
+        }
+      });
+
+      gamepad.axes.forEach((axis, index) => {
+        const value = Math.abs(axis) < AXIS_DEADZONE ? 0 : axis;
+        if (state.axes[index] === value) return;
+        state.axes[index] = value;
+
+        send("GAMEPADAXIS", { pad, index, value });
+      });
+    }
+
+    frameId = requestAnimationFrame(poll);
+  };
+  frameId = requestAnimationFrame(poll);
+
+  return () => {
+    cancelAnimationFrame(frameId);
+    Object.keys(states).forEach((pad) =>
+      send("GAMEPADDISCONNECT", { pad: Number(pad) })
+    );
+  };
+};
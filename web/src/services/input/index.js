@@ -0,0 +1,104 @@
+// Binary input protocol of the provider, see provider/pkg/inputproto
+const VERSION = 1;
+
+// Event types, payload encoders and sizes
+const KEY = (view, data) => view.setUint16(2, data.keyCode, true);
+const MOUSE = (view, data) => {
+  view.setUint8(2, data.button ?? (data.isLeft ? 0 : 2));
+  setPosition(view, 3, data);
+};
+const BUTTON = (view, data) => view.setUint8(2, data.button);
+// Wheel deltas are notches, scaled to WHEEL_DELTA units with the vertical axis positive away from the user
+const WHEEL = (view, data) => {
+  view.setInt16(2, Math.round(clamp(data.deltaX, -10, 10) * 120), true);
+  view.setInt16(4, Math.round(clamp(-data.deltaY, -10, 10) * 120), true);
+};
+const DELTA = (view, data) => {
+  view.setInt16(2, Math.round(clamp(data.dx, -32768, 32767)), true);
+  view.setInt16(4, Math.round(clamp(data.dy, -32768, 32767)), true);
+};
+const GAMEPAD = (view, data) => {
+  view.setUint8(2, data.pad);
+  view.setUint8(3, data.index ?? 0);
+  view.setInt16(4, Math.round(clamp(data.value ?? 0, -1, 1) * 32767), true);
+};
+const TOUCH = (view, data) => {
+  view.setUint8(2, data.id);
+  setPosition(view, 3, data);
+};
+
+const EVENTS = {
+  KEYDOWN: [1, 2, KEY],
+  KEYUP: [2, 2, KEY],
+  MOUSEMOVE: [3, 5, MOUSE],
+  MOUSEDOWN: [4, 5, MOUSE],
+  MOUSEUP: [5, 5, MOUSE],
+  MOUSEMOVEREL: [9, 4, DELTA],
+  MOUSEWHEEL: [8, 4, WHEEL],
+  GAMEPADCONNECT: [10, 4, GAMEPAD],
+  GAMEPADDISCONNECT: [11, 4, GAMEPAD],
+  GAMEPADBUTTON: [12, 4, GAMEPAD],
+  GAMEPADAXIS: [13, 4, GAMEPAD],
+  GAMEPADTRIGGER: [14, 4, GAMEPAD],
+  TOUCHSTART: [15, 5, TOUCH],
+  TOUCHMOVE: [16, 5, TOUCH],
+  TOUCHEND: [17, 5, TOUCH],
+};
+// Button events wherever the cursor is, when it's captured
+const BUTTONDOWN = [6, 1, BUTTON];
+const BUTTONUP = [7, 1, BUTTON];
+
+function clamp(value, min, max) {
+  return Math.min(max, Math.max(min, value || 0));
+}
+
+// Positions are fractions of the screen scaled to 0-65535
+function setPosition(view, offset, data) {
+  const scale = (value, size) => Math.round(clamp(value / size, 0, 1) * 65535);
+  view.setUint16(offset, scale(data.x, data.width), true);
+  view.setUint16(offset + 2, scale(data.y, data.height), true);
+}
+
+function encode(type, data) {
+  let event = EVENTS[type];
+  if (data.locked && type === "MOUSEDOWN") event = BUTTONDOWN;
+  if (data.locked && type === "MOUSEUP") event = BUTTONUP;
+  if (!event) return null;
+
+  const [eventType, size, setPayload] = event;
+  const view = new DataView(new ArrayBuffer(2 + size));
+  view.setUint8(0, VERSION);
+  view.setUint8(1, eventType);
+  setPayload(view, data);
+  return view.buffer;
+}
+
+// inputChannel wraps the input data channel, it sends JSON packets until the provider announces it supports
+// the binary protocol
+export const inputChannel = (channel) => {
+  let binary = false;
+
+  channel.addEventListener("message", (event) => {
+    if (typeof event.data !== "string") return;
+
+    const msg = JSON.parse(event.data);
+    if (msg.type !== "INPUTPROTOCOL") return;
+    binary = JSON.parse(msg.data).versions.includes(VERSION);
+  });
+
+  return {
+    get readyState() {
+      return channel.readyState;
+    },
+    send(type, data) {
+      if (channel.readyState !== "open") return;
+
+      const frame = binary && encode(type, data);
+      if (frame) {
+        channel.send(frame);
+      } else {
+        channel.send(JSON.stringify({ type, data: JSON.stringify(data) }));
+      }
+    },
+  };
+};
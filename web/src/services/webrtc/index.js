@@ -12,3 +12,14 @@ export const addIceCandidate = async (pc, iceCandidate) => {
     console.log(e);
   }
 };
+
+// Video codecs the browser can decode, as MIME types such as video/VP8
+export const getVideoCodecs = () => {
+  if (!window.RTCRtpReceiver || !RTCRtpReceiver.getCapabilities) return [];
+
+  const capabilities = RTCRtpReceiver.getCapabilities("video");
+  if (!capabilities) return [];
+
+  const codecs = capabilities.codecs.map((codec) => codec.mimeType);
+  return [...new Set(codecs)];
+};
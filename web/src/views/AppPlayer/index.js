@@ -4,15 +4,91 @@ import TimeCounter from "../../components/TimeCounter";
 
 import "./style.scss";
 
-export default function AppPlayer({ videoStream, inpChannel, onCloseApp }) {
+// Seats of guests, the first seat is the player's
+const SEATS = [1, 2, 3];
+
+export default function AppPlayer({
+  queuePosition,
+  creditWarning,
+  latency,
+  videoStream,
+  inpChannel,
+  inviteCode,
+  seatCode,
+  seats,
+  onInvite,
+  onInviteGuests,
+  onKickGuest,
+  onMoveGuest,
+  onCloseApp,
+}) {
   return (
     <div className="app-player">
       <div className="app-player__timer">
         <TimeCounter />
       </div>
+      {latency && (
+        <div
+          className="app-player__latency"
+          title={`Provider: ${Math.round(latency.input.p50)} ms`}
+        >
+          {Math.round(latency.endToEnd.p50)} ms
+        </div>
+      )}
       <button className="app-player__close" onClick={onCloseApp}>
         Exit
       </button>
+      {onInvite &&
+        (inviteCode ? (
+          <div className="app-player__invite">Invite code: {inviteCode}</div>
+        ) : (
+          <button className="app-player__invite" onClick={onInvite}>
+            Invite friends to watch
+          </button>
+        ))}
+      {onInviteGuests && (
+        <div className="app-player__seats">
+          {seatCode ? (
+            <div>Player code: {seatCode}</div>
+          ) : (
+            <button className="app-player__seats-btn" onClick={onInviteGuests}>
+              Invite friends to play
+            </button>
+          )}
+          {seats.map(({ guestID, seat }) => (
+            <div key={guestID} className="app-player__seat">
+              <select
+                value={seat}
+                onChange={(event) =>
+                  onMoveGuest(guestID, Number(event.target.value))
+                }
+              >
+                {SEATS.map((s) => (
+                  <option key={s} value={s}>
+                    Player {s + 1}
+                  </option>
+                ))}
+              </select>
+              <button
+                className="app-player__seats-btn"
+                onClick={() => onKickGuest(guestID)}
+              >
+                Remove
+              </button>
+            </div>
+          ))}
+        </div>
+      )}
+      {queuePosition > 0 && (
+        <div className="app-player__queue">
+          All servers are busy, you are #{queuePosition} in the queue
+        </div>
+      )}
+      {creditWarning > 0 && (
+        <div className="app-player__credit">
+          Only {creditWarning} minute(s) of play time left
+        </div>
+      )}
       <div className="app-player__display">
         <Display streamSrc={videoStream} inpChannel={inpChannel} />
       </div>
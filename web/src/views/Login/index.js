@@ -0,0 +1,67 @@
+import React, { useState } from "react";
+import { login, register } from "../../services/api/auth";
+
+import "./style.scss";
+
+export default function Login({ onLogin }) {
+  const [name, setName] = useState("");
+  const [password, setPassword] = useState("");
+  const [role, setRole] = useState("player");
+  const [error, setError] = useState("");
+
+  const handleResp = (resp) => {
+    if (resp.errorCode === undefined || resp.errorCode === 0) {
+      onLogin(resp.data);
+    } else {
+      setError(resp.error || "Something went wrong, please try again");
+    }
+  };
+
+  const onLoginClick = async (e) => {
+    e.preventDefault();
+    handleResp(await login(name, password));
+  };
+
+  const onRegisterClick = async (e) => {
+    e.preventDefault();
+    handleResp(await register(name, password, role));
+  };
+
+  return (
+    <div className="login">
+      <h1 className="login__title">Sign in to play</h1>
+      <form className="login__form">
+        <input
+          className="login__input"
+          placeholder="Name"
+          value={name}
+          onChange={(e) => setName(e.target.value)}
+        />
+        <input
+          className="login__input"
+          placeholder="Password"
+          type="password"
+          value={password}
+          onChange={(e) => setPassword(e.target.value)}
+        />
+        <select
+          className="login__input"
+          value={role}
+          onChange={(e) => setRole(e.target.value)}
+        >
+          <option value="player">I want to play</option>
+          <option value="owner">I want to provide my computers</option>
+        </select>
+        {error !== "" && <span className="login__error">{error}</span>}
+        <div>
+          <button className="login__btn" onClick={onLoginClick}>
+            Login
+          </button>
+          <button className="login__btn" onClick={onRegisterClick}>
+            Register
+          </button>
+        </div>
+      </form>
+    </div>
+  );
+}
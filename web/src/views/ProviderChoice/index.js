@@ -3,13 +3,20 @@ import ProviderList from "../../components/ProviderList";
 
 import "./style.scss";
 
-export default function ProviderChoice({ onSelectProvider, onBack }) {
+export default function ProviderChoice({
+  onSelectProvider,
+  onPlayNow,
+  onBack,
+}) {
   return (
     <div className="provider-choice">
       <button className="provider-choice__close" onClick={onBack}>
         Back
       </button>
       <h1 className="provider-choice__title">Choose a game server</h1>
+      <button className="provider-choice__play-now" onClick={onPlayNow}>
+        Play now on the best server
+      </button>
       <div className="provider-choice__provider-list">
         <ProviderList onSelectProvider={onSelectProvider} />
       </div>
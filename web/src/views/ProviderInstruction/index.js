@@ -22,7 +22,7 @@ export default function ProviderChoice({ onBack, children }) {
       <p> (Notice that we only support Linux computers for now)</p>
       <ol>
         <li>Install Golang (v1.16 or above)</li>
-        <li>Install Docker and docker-compose</li>
+        <li>Install Docker</li>
         <li>
           Make sure that the current user on your computer has permissions to
           run Docker
@@ -32,13 +32,13 @@ export default function ProviderChoice({ onBack, children }) {
           from Github
         </li>
         <li>
-          Run provider/run.sh in the cloned folder. If you already have some
-          other servers running, append your ID to the command. For example:
-          provider/run.sh 123
+          Register an account with the &ldquo;I want to provide my
+          computers&rdquo; option. Your auth token is shown below
         </li>
         <li>
-          If the command runs successfully, it will print out your ID. Use it
-          for your other servers
+          Run provider/run.sh in the cloned folder with your token. For
+          example: provider/run.sh your-token. Use the same token for all of
+          your servers
         </li>
         <li>You&apos;re now a provider!</li>
       </ol>